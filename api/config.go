@@ -0,0 +1,52 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Config は [api] セクションの設定
+type Config struct {
+	Addr   string        `toml:"addr"` // 待ち受けアドレス（空ならAPIを無効にする）
+	Tokens []TokenConfig `toml:"tokens"`
+}
+
+// TokenConfig はAPIのアクセストークンと、そのトークンで実行できるコマンド
+type TokenConfig struct {
+	Name  string `toml:"name"`  // 監査ログなどに記録する名前
+	Token string `toml:"token"` // Authorization: Bearer で送る値
+	// Commands は実行できるコマンドのキーワード（[[commands]] の keyword と同じ文字列）。空なら制限しない。
+	Commands []string `toml:"commands"`
+}
+
+// Enabled reports whether the API is configured.
+func (c *Config) Enabled() bool {
+	return c.Addr != ""
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	if !c.Enabled() {
+		return nil
+	}
+	if len(c.Tokens) == 0 {
+		return errors.New("api.tokens is required when api.addr is set")
+	}
+	names := map[string]bool{}
+	tokens := map[string]bool{}
+	for i, t := range c.Tokens {
+		if strings.TrimSpace(t.Name) == "" || t.Token == "" {
+			return fmt.Errorf("api.tokens[%d]: name and token are required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("api.tokens: duplicate name '%s'", t.Name)
+		}
+		if tokens[t.Token] {
+			return fmt.Errorf("api.tokens: duplicate token for '%s'", t.Name)
+		}
+		names[t.Name] = true
+		tokens[t.Token] = true
+	}
+	return nil
+}
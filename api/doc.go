@@ -0,0 +1,3 @@
+// Package api serves an authenticated HTTP API that lets CI pipelines and other
+// services run commands and have the results posted to the chat service.
+package api
@@ -0,0 +1,361 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hnw/slack-commander/audit"
+	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/metrics"
+	"github.com/hnw/slack-commander/pubsub"
+)
+
+const (
+	// maxJobs は状態を保持しておくジョブの件数。超えたら古いものから忘れる。
+	maxJobs = 1000
+	// maxOutput はジョブごとに保持する出力の最大バイト数
+	maxOutput = 64 * 1024
+	// maxRequestBody はリクエストボディの最大バイト数
+	maxRequestBody = 1 << 20
+)
+
+// ジョブの状態
+const (
+	StatusQueued   = "queued"
+	StatusRunning  = "running"
+	StatusFinished = "finished"
+)
+
+// Job はAPIから実行したコマンドの状態
+type Job struct {
+	ID           uint64    `json:"id"`
+	Text         string    `json:"text"`
+	Channel      string    `json:"channel"`
+	ThreadTS     string    `json:"thread_ts,omitempty"`
+	Status       string    `json:"status"`
+	ExitCode     *int      `json:"exit_code,omitempty"`
+	Output       string    `json:"output"`
+	HistoryJobID uint64    `json:"history_job_id,omitempty"` // history_file を設定している場合のジョブID
+	CreatedAt    time.Time `json:"created_at"`
+	FinishedAt   time.Time `json:"finished_at,omitzero"`
+
+	token     string // ジョブを作成したトークンの名前
+	truncated bool
+}
+
+type runRequest struct {
+	Text      string `json:"text"`
+	Channel   string `json:"channel"`
+	ThreadTS  string `json:"thread_ts"`
+	Workspace string `json:"workspace"` // [[workspaces]] を使う場合に投稿先のワークスペース名
+}
+
+// Server は POST /v1/run と GET /v1/jobs/{id} を提供する
+type Server struct {
+	// tokens は設定の再読み込み時に UpdateTokens で丸ごと差し替える
+	tokens       atomic.Pointer[[]TokenConfig]
+	table        *cmd.CommandTable
+	commandQueue chan *cmd.CommandInput
+	audit        *audit.Logger
+	rateLimiter  pubsub.RateLimiter
+
+	mu     sync.Mutex
+	nextID uint64
+	jobs   map[uint64]*Job
+	order  []uint64 // 作成順のジョブID
+}
+
+// Option configures optional behavior of a Server.
+type Option func(*Server)
+
+// WithRateLimiter makes the server check l before enqueueing a command and
+// respond with 429 Too Many Requests when a limit is exceeded.
+func WithRateLimiter(l pubsub.RateLimiter) Option {
+	return func(s *Server) {
+		s.rateLimiter = l
+	}
+}
+
+// New returns a Server that checks commands against table and enqueues them to commandQueue.
+// auditLogger may be nil.
+func New(
+	cfg Config,
+	table *cmd.CommandTable,
+	commandQueue chan *cmd.CommandInput,
+	auditLogger *audit.Logger,
+	opts ...Option,
+) *Server {
+	s := &Server{
+		table:        table,
+		commandQueue: commandQueue,
+		audit:        auditLogger,
+		jobs:         map[uint64]*Job{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.UpdateTokens(cfg.Tokens)
+	return s
+}
+
+// UpdateTokens atomically replaces the access tokens. Jobs created with a
+// removed token can no longer be looked up.
+func (s *Server) UpdateTokens(tokens []TokenConfig) {
+	if s == nil {
+		return
+	}
+	tokens = append([]TokenConfig(nil), tokens...)
+	s.tokens.Store(&tokens)
+}
+
+// Register adds the API handlers to mux.
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v1/run", s.handleRun)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+}
+
+// authenticate は Authorization ヘッダのトークンに対応する設定を返す
+func (s *Server) authenticate(r *http.Request) (*TokenConfig, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return nil, false
+	}
+	tokens := *s.tokens.Load()
+	for i := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(tokens[i].Token)) == 1 {
+			return &tokens[i], true
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	tc, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	var req runRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" || req.Channel == "" {
+		writeError(w, http.StatusBadRequest, "text and channel are required")
+		return
+	}
+	senderID := "api:" + tc.Name
+	if reason := s.checkCommands(tc, req.Text); reason != "" {
+		s.audit.Log(audit.Record{
+			Event:     audit.EventDenied,
+			UserID:    senderID,
+			ChannelID: req.Channel,
+			Text:      req.Text,
+			Reason:    reason,
+		})
+		writeError(w, http.StatusForbidden, reason)
+		return
+	}
+
+	job := s.newJob(tc.Name, req)
+	var reply interface{} = &pubsub.APIReply{
+		ChannelReply: pubsub.ChannelReply{Channel: req.Channel, ThreadTimeStamp: req.ThreadTS},
+		Observe:      func(output *cmd.CommandOutput) { s.observe(job.ID, output) },
+	}
+	if req.Workspace != "" {
+		reply = &pubsub.WorkspaceReply{Workspace: req.Workspace, ReplyInfo: reply}
+	}
+	input := &cmd.CommandInput{
+		ReplyInfo: reply,
+		Text:      req.Text,
+		SenderID:  senderID,
+		ChannelID: req.Channel,
+		// 受け付けた後に設定が再読み込みされても、実行時にトークンの制限で照合し直す
+		AllowedCommands: tc.Commands,
+		Done:            func(exitCode int) { s.finish(job.ID, exitCode) },
+		// r.Context() はレスポンスを返すとキャンセルされるので、値だけを引き継ぐ
+		Context:    context.WithoutCancel(r.Context()),
+		EnqueuedAt: time.Now(),
+	}
+	if s.rateLimiter != nil {
+		if ok, reason := s.rateLimiter.Allow(input); !ok {
+			s.audit.Log(audit.Record{
+				Event:     audit.EventDenied,
+				UserID:    senderID,
+				ChannelID: req.Channel,
+				Text:      req.Text,
+				Reason:    "rate limit exceeded",
+			})
+			s.forget(job.ID)
+			writeError(w, http.StatusTooManyRequests, reason)
+			return
+		}
+	}
+	select {
+	case s.commandQueue <- input:
+		metrics.CommandReceived()
+	default:
+		metrics.QueueDropped("command")
+		s.forget(job.ID)
+		writeError(w, http.StatusServiceUnavailable, "command queue is full")
+		return
+	}
+	s.audit.Log(audit.Record{
+		Event:     audit.EventAccepted,
+		UserID:    senderID,
+		ChannelID: req.Channel,
+		Text:      req.Text,
+	})
+	w.Header().Set("Location", fmt.Sprintf("/v1/jobs/%d", job.ID))
+	writeJSON(w, http.StatusAccepted, s.snapshot(job.ID))
+}
+
+// checkCommands はトークンで text のコマンドを実行できるかを調べ、できなければ理由を返す
+func (s *Server) checkCommands(tc *TokenConfig, text string) string {
+	if len(tc.Commands) == 0 {
+		return ""
+	}
+	keywords, err := s.table.Keywords(text)
+	if err != nil {
+		return "failed to parse command: " + err.Error()
+	}
+	for _, kw := range keywords {
+		if kw == "" {
+			return "command not found"
+		}
+		if !contains(tc.Commands, kw) {
+			return fmt.Sprintf("command '%s' is not allowed for this token", kw)
+		}
+	}
+	return ""
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	tc, ok := s.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	job := s.snapshot(id)
+	// 他のトークンで作成したジョブは見せない
+	if job == nil || job.token != tc.Name {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) newJob(token string, req runRequest) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	job := &Job{
+		ID:        s.nextID,
+		Text:      req.Text,
+		Channel:   req.Channel,
+		ThreadTS:  req.ThreadTS,
+		Status:    StatusQueued,
+		CreatedAt: time.Now(),
+		token:     token,
+	}
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	for len(s.order) > maxJobs {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	return job
+}
+
+func (s *Server) forget(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.jobs, id)
+}
+
+// snapshot はジョブのコピーを返す。ジョブがなければnilを返す。
+func (s *Server) snapshot(id uint64) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil
+	}
+	c := *job
+	return &c
+}
+
+// observe はWriterに渡されたコマンドの出力をジョブに記録する
+func (s *Server) observe(id uint64, output *cmd.CommandOutput) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	if output.Queued || output.Dequeued {
+		// 待ち順の通知はジョブの出力に含めない
+		return
+	}
+	if output.Spawned && job.Status == StatusQueued {
+		job.Status = StatusRunning
+	}
+	if output.JobID != 0 {
+		job.HistoryJobID = output.JobID
+	}
+	if output.Text == "" || job.truncated {
+		return
+	}
+	text := output.Text
+	if len(job.Output)+len(text) > maxOutput {
+		text = strings.ToValidUTF8(text[:maxOutput-len(job.Output)], "")
+		job.truncated = true
+	}
+	job.Output += text
+}
+
+// finish はExecutorがコマンドを実行し終えた時に呼ばれる
+func (s *Server) finish(id uint64, exitCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return
+	}
+	job.Status = StatusFinished
+	job.ExitCode = &exitCode
+	job.FinishedAt = time.Now()
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
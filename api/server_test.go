@@ -0,0 +1,183 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/pubsub"
+)
+
+func newTestServer(t *testing.T, q chan *cmd.CommandInput, opts ...Option) (*httptest.Server, *Server) {
+	t.Helper()
+	table := cmd.NewCommandTable([]*cmd.CommandConfig{
+		cmd.NewCommandConfig(&cmd.Definition{Keyword: "deploy *", Command: "deploy.sh"}, nil),
+		cmd.NewCommandConfig(&cmd.Definition{Keyword: "date", Command: "date"}, nil),
+	}, nil)
+	s := New(Config{
+		Addr: "127.0.0.1:0",
+		Tokens: []TokenConfig{
+			{Name: "ci", Token: "ci-token", Commands: []string{"deploy *"}},
+			{Name: "ops", Token: "ops-token"},
+		},
+	}, table, q, nil, opts...)
+	mux := http.NewServeMux()
+	s.Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, s
+}
+
+func doRequest(t *testing.T, method, url, token, body string) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	var v map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return resp, v
+}
+
+func TestRunAndJobStatus(t *testing.T) {
+	q := make(chan *cmd.CommandInput, 1)
+	srv, _ := newTestServer(t, q)
+
+	resp, v := doRequest(t, http.MethodPost, srv.URL+"/v1/run", "ci-token",
+		`{"text":"deploy web","channel":"C123","thread_ts":"1.2"}`)
+	if resp.StatusCode != http.StatusAccepted || v["status"] != StatusQueued {
+		t.Fatalf("unexpected response: %d %v", resp.StatusCode, v)
+	}
+	location := resp.Header.Get("Location")
+
+	input := <-q
+	if input.Text != "deploy web" || input.SenderID != "api:ci" || input.ChannelID != "C123" {
+		t.Fatalf("unexpected input: %+v", input)
+	}
+	if len(input.AllowedCommands) != 1 || input.AllowedCommands[0] != "deploy *" {
+		t.Fatalf("expected the token's commands to restrict execution: %v", input.AllowedCommands)
+	}
+	reply, ok := input.ReplyInfo.(*pubsub.APIReply)
+	if !ok || reply.Channel != "C123" || reply.ThreadTimeStamp != "1.2" {
+		t.Fatalf("unexpected reply info: %#v", input.ReplyInfo)
+	}
+	reply.Observe(&cmd.CommandOutput{ReplyInfo: reply, Spawned: true})
+	reply.Observe(&cmd.CommandOutput{ReplyInfo: reply, Text: "deployed\n", JobID: 7})
+	_, v = doRequest(t, http.MethodGet, srv.URL+location, "ci-token", "")
+	if v["status"] != StatusRunning || v["output"] != "deployed\n" || v["history_job_id"] != float64(7) {
+		t.Fatalf("unexpected running job: %v", v)
+	}
+
+	input.Done(3)
+	_, v = doRequest(t, http.MethodGet, srv.URL+location, "ci-token", "")
+	if v["status"] != StatusFinished || v["exit_code"] != float64(3) {
+		t.Fatalf("unexpected finished job: %v", v)
+	}
+
+	// 他のトークンで作成したジョブは見えない
+	resp, _ = doRequest(t, http.MethodGet, srv.URL+location, "ops-token", "")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for other token, got %d", resp.StatusCode)
+	}
+}
+
+func TestRunRejectsRequests(t *testing.T) {
+	q := make(chan *cmd.CommandInput, 1)
+	srv, _ := newTestServer(t, q)
+
+	tests := []struct {
+		name  string
+		token string
+		body  string
+		want  int
+	}{
+		{"no token", "", `{"text":"date","channel":"C1"}`, http.StatusUnauthorized},
+		{"bad token", "wrong", `{"text":"date","channel":"C1"}`, http.StatusUnauthorized},
+		{"missing channel", "ops-token", `{"text":"date"}`, http.StatusBadRequest},
+		{"not allowed", "ci-token", `{"text":"date","channel":"C1"}`, http.StatusForbidden},
+		{"not allowed in chain", "ci-token", `{"text":"deploy web && date","channel":"C1"}`, http.StatusForbidden},
+		{"unknown command", "ci-token", `{"text":"rm -rf","channel":"C1"}`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, _ := doRequest(t, http.MethodPost, srv.URL+"/v1/run", tt.token, tt.body)
+			if resp.StatusCode != tt.want {
+				t.Fatalf("got %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+	if len(q) != 0 {
+		t.Fatalf("rejected requests must not be queued")
+	}
+
+	// 制限のないトークンは任意のコマンドを実行できる
+	resp, _ := doRequest(t, http.MethodPost, srv.URL+"/v1/run", "ops-token", `{"text":"date","channel":"C1"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+	// キューが一杯なら503を返す
+	resp, _ = doRequest(t, http.MethodPost, srv.URL+"/v1/run", "ops-token", `{"text":"date","channel":"C1"}`)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+}
+
+// denyingLimiter は deploy だけを拒否するテスト用のRateLimiter
+type denyingLimiter struct{}
+
+func (denyingLimiter) Allow(input *cmd.CommandInput) (bool, string) {
+	if strings.HasPrefix(input.Text, "deploy") {
+		return false, "too many deploys"
+	}
+	return true, ""
+}
+
+func TestRunRateLimited(t *testing.T) {
+	q := make(chan *cmd.CommandInput, 1)
+	srv, _ := newTestServer(t, q, WithRateLimiter(denyingLimiter{}))
+
+	resp, v := doRequest(t, http.MethodPost, srv.URL+"/v1/run", "ci-token", `{"text":"deploy web","channel":"C1"}`)
+	if resp.StatusCode != http.StatusTooManyRequests || v["error"] != "too many deploys" {
+		t.Fatalf("unexpected response: %d %v", resp.StatusCode, v)
+	}
+	if len(q) != 0 {
+		t.Fatal("rate limited requests must not be queued")
+	}
+	resp, _ = doRequest(t, http.MethodPost, srv.URL+"/v1/run", "ops-token", `{"text":"date","channel":"C1"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+}
+
+func TestUpdateTokens(t *testing.T) {
+	q := make(chan *cmd.CommandInput, 2)
+	srv, s := newTestServer(t, q)
+
+	// 漏れたトークンを無効にし、同じ名前で新しいトークンを発行する
+	s.UpdateTokens([]TokenConfig{{Name: "ci", Token: "new-ci-token", Commands: []string{"date"}}})
+	resp, _ := doRequest(t, http.MethodPost, srv.URL+"/v1/run", "ci-token", `{"text":"deploy web","channel":"C1"}`)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected the old token to be rejected, got %d", resp.StatusCode)
+	}
+	resp, _ = doRequest(t, http.MethodPost, srv.URL+"/v1/run", "new-ci-token", `{"text":"deploy web","channel":"C1"}`)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected the new commands to apply, got %d", resp.StatusCode)
+	}
+	resp, _ = doRequest(t, http.MethodPost, srv.URL+"/v1/run", "new-ci-token", `{"text":"date","channel":"C1"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// 記録するイベントの種類
+const (
+	EventAccepted  = "accepted"  // 発言者・チャンネルが許可され、コマンドとして受け付けた
+	EventDenied    = "denied"    // 発言者・チャンネルが許可されていない、または実行回数の上限やキューの上限を超えたため無視した
+	EventMatched   = "matched"   // キーワードにマッチした
+	EventStarted   = "started"   // コマンドを起動した
+	EventCancelled = "cancelled" // タイムアウトまたはキャンセルで終了した
+	EventFinished  = "finished"  // コマンドが終了した
+)
+
+// Record は監査ログ1件分
+type Record struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"`
+	UserID    string    `json:"user_id,omitempty"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Keyword   string    `json:"keyword,omitempty"`
+	Runner    string    `json:"runner,omitempty"`
+	Argv      []string  `json:"argv,omitempty"`
+	ExitCode  *int      `json:"exit_code,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	// PrevHash は直前のレコードの Hash。Hash は PrevHash を含むこのレコード（Hash自身を除く）のSHA-256。
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash,omitempty"`
+}
+
+// ExitCode returns a pointer to code, for use in Record.ExitCode.
+func ExitCode(code int) *int {
+	return &code
+}
+
+func (r *Record) computeHash() (string, error) {
+	tmp := *r
+	tmp.Hash = ""
+	data, err := json.Marshal(&tmp)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Sink は監査ログの書き込み先
+type Sink interface {
+	// Write writes one JSON-encoded record (without a trailing newline).
+	Write(record []byte) error
+	Close() error
+}
+
+// Logger は監査ログを記録する。nilの *Logger に対する呼び出しは何もしない。
+type Logger struct {
+	mu       sync.Mutex
+	sinks    []Sink
+	redactor *Redactor
+	prevHash string
+	now      func() time.Time
+	logf     func(format string, args ...interface{})
+}
+
+// NewLogger returns a Logger writing to sinks.
+// prevHash is the hash of the last record written by a previous process, if any.
+func NewLogger(
+	sinks []Sink,
+	redactor *Redactor,
+	prevHash string,
+	logf func(format string, args ...interface{}),
+) *Logger {
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	return &Logger{
+		sinks:    sinks,
+		redactor: redactor,
+		prevHash: prevHash,
+		now:      time.Now,
+		logf:     logf,
+	}
+}
+
+// Log redacts secrets from r, chains it to the previous record and writes it to all sinks.
+func (l *Logger) Log(r Record) {
+	if l == nil {
+		return
+	}
+	r.Text = l.redactor.Redact(r.Text)
+	r.Argv = l.redactor.RedactArgs(r.Argv)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if r.Time.IsZero() {
+		r.Time = l.now()
+	}
+	r.PrevHash = l.prevHash
+	hash, err := r.computeHash()
+	if err != nil {
+		l.logf("audit: %v", err)
+		return
+	}
+	r.Hash = hash
+	data, err := json.Marshal(&r)
+	if err != nil {
+		l.logf("audit: %v", err)
+		return
+	}
+	l.prevHash = hash
+	for _, s := range l.sinks {
+		if err := s.Write(data); err != nil {
+			l.logf("audit: %v", err)
+		}
+	}
+}
+
+// Close closes all sinks.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var errs []error
+	for _, s := range l.sinks {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Verify checks the hash chain of JSON-lines audit records read from r.
+// The first record's PrevHash is not checked, since it refers to a record
+// in a previous (possibly rotated) file.
+// It returns the hash of the last record.
+func Verify(r io.Reader) (string, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	prev := ""
+	line := 0
+	first := true
+	for sc.Scan() {
+		line++
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return "", fmt.Errorf("line %d: %w", line, err)
+		}
+		if !first && rec.PrevHash != prev {
+			return "", fmt.Errorf("line %d: prev_hash does not match the previous record", line)
+		}
+		hash, err := rec.computeHash()
+		if err != nil {
+			return "", fmt.Errorf("line %d: %w", line, err)
+		}
+		if hash != rec.Hash {
+			return "", fmt.Errorf("line %d: hash mismatch (record was modified)", line)
+		}
+		prev = rec.Hash
+		first = false
+	}
+	return prev, sc.Err()
+}
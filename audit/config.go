@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Config は [audit] の設定項目
+type Config struct {
+	File           string            `toml:"file"`
+	FileMaxSizeMB  int               `toml:"file_max_size_mb"`
+	FileMaxBackups int               `toml:"file_max_backups"`
+	Syslog         bool              `toml:"syslog"`
+	SyslogNetwork  string            `toml:"syslog_network"`
+	SyslogAddress  string            `toml:"syslog_address"`
+	WebhookURL     string            `toml:"webhook_url"`
+	WebhookHeaders map[string]string `toml:"webhook_headers"`
+	RedactPatterns []string          `toml:"redact_patterns"`
+}
+
+// Enabled reports whether any sink is configured.
+func (c *Config) Enabled() bool {
+	return c.File != "" || c.Syslog || c.WebhookURL != ""
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	if c.FileMaxSizeMB < 0 || c.FileMaxBackups < 0 {
+		return errors.New("audit file_max_size_mb and file_max_backups must be >= 0")
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid audit webhook_url '%s'", c.WebhookURL)
+		}
+	}
+	if _, err := NewRedactor(c.RedactPatterns); err != nil {
+		return err
+	}
+	return nil
+}
+
+// New builds a Logger from cfg. It returns nil if no sink is configured.
+func New(cfg Config, logf func(format string, args ...interface{})) (*Logger, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	redactor, err := NewRedactor(cfg.RedactPatterns)
+	if err != nil {
+		return nil, err
+	}
+	var sinks []Sink
+	closeAll := func() {
+		for _, s := range sinks {
+			_ = s.Close()
+		}
+	}
+	prevHash := ""
+	if strings.TrimSpace(cfg.File) != "" {
+		f, err := OpenFileSink(cfg.File, int64(cfg.FileMaxSizeMB)<<20, cfg.FileMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		prevHash = f.LastHash()
+		sinks = append(sinks, f)
+	}
+	if cfg.Syslog {
+		s, err := OpenSyslogSink(cfg.SyslogNetwork, cfg.SyslogAddress)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, NewWebhookSink(cfg.WebhookURL, cfg.WebhookHeaders, logf))
+	}
+	return NewLogger(sinks, redactor, prevHash, logf), nil
+}
@@ -0,0 +1,3 @@
+// Package audit writes an append-only, hash-chained audit trail of
+// authorization decisions and command executions.
+package audit
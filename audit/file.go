@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// defaultFileMaxBackups は file_max_backups 未指定時に残すローテート済みファイルの数
+const defaultFileMaxBackups = 5
+
+// FileSink はJSON Lines形式でファイルに追記する。
+// ファイルサイズが maxSize を超えると path.1, path.2, ... にローテートする。
+type FileSink struct {
+	path       string
+	maxSize    int64 // 0ならローテートしない
+	maxBackups int
+	f          *os.File
+	size       int64
+	lastHash   string
+}
+
+// OpenFileSink opens path for appending.
+func OpenFileSink(path string, maxSize int64, maxBackups int) (*FileSink, error) {
+	if maxBackups <= 0 {
+		maxBackups = defaultFileMaxBackups
+	}
+	s := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	lastHash, err := readLastHash(path)
+	if err != nil {
+		return nil, err
+	}
+	s.lastHash = lastHash
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LastHash returns the hash of the last record in the file when it was opened.
+func (s *FileSink) LastHash() string {
+	return s.lastHash
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends record and a newline.
+func (s *FileSink) Write(record []byte) error {
+	n := int64(len(record) + 1)
+	if s.maxSize > 0 && s.size > 0 && s.size+n > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	written, err := s.f.Write(append(record, '\n'))
+	s.size += int64(written)
+	return err
+}
+
+func (s *FileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	// 一番古いものは上書きで消える
+	for i := s.maxBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", s.path, i)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.Rename(from, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	return s.f.Close()
+}
+
+// readLastHash は再起動後もハッシュチェーンをつなげるため、既存ファイルの最後のレコードのハッシュを返す
+func readLastHash(path string) (string, error) {
+	f, err := os.Open(path) // #nosec G304 -- 設定ファイルで指定されたパス
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var last []byte
+	for sc.Scan() {
+		if len(sc.Bytes()) > 0 {
+			last = append(last[:0], sc.Bytes()...)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	if last == nil {
+		return "", nil
+	}
+	var rec Record
+	if err := json.Unmarshal(last, &rec); err != nil {
+		return "", fmt.Errorf("%s: broken last record: %w", path, err)
+	}
+	return rec.Hash, nil
+}
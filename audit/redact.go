@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// redacted は秘密情報を置き換える文字列
+const redacted = "[REDACTED]"
+
+type redactRule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// defaultRedactRules は設定によらず常に適用するルール
+var defaultRedactRules = []redactRule{
+	// Slackのトークン
+	{regexp.MustCompile(`\bx(?:ox[abposre]|app)-[0-9A-Za-z-]+`), redacted},
+	// Authorization ヘッダ
+	{regexp.MustCompile(`(?i)\b(authorization:\s*(?:bearer|basic|token)\s+)\S+`), "${1}" + redacted},
+	// password=xxx, token: xxx 等
+	{
+		regexp.MustCompile(
+			`(?i)\b((?:password|passwd|pwd|token|secret|api[_-]?key|access[_-]?key)\s*[=:]\s*)[^\s&]+`,
+		),
+		"${1}" + redacted,
+	},
+	// URLに埋め込まれたパスワード
+	{regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`), "${1}" + redacted + "@"},
+}
+
+// reSecretFlag は次の引数が秘密情報であるオプション（--password xxx 等）
+var reSecretFlag = regexp.MustCompile(`(?i)^--?(?:password|passwd|token|secret|api[_-]?key|access[_-]?key)$`)
+
+// Redactor は監査ログに残す文字列から秘密情報を取り除く
+type Redactor struct {
+	rules []redactRule
+}
+
+// NewRedactor returns a Redactor applying the default rules and patterns.
+// Every match of a pattern is replaced entirely.
+func NewRedactor(patterns []string) (*Redactor, error) {
+	rules := append([]redactRule(nil), defaultRedactRules...)
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redact pattern '%s': %w", p, err)
+		}
+		rules = append(rules, redactRule{re: re, repl: redacted})
+	}
+	return &Redactor{rules: rules}, nil
+}
+
+// Redact returns s with secrets replaced.
+func (r *Redactor) Redact(s string) string {
+	if s == "" {
+		return s
+	}
+	rules := defaultRedactRules
+	if r != nil {
+		rules = r.rules
+	}
+	for _, rule := range rules {
+		s = rule.re.ReplaceAllString(s, rule.repl)
+	}
+	return s
+}
+
+// RedactArgs returns a copy of args with secrets replaced.
+func (r *Redactor) RedactArgs(args []string) []string {
+	if len(args) == 0 {
+		return args
+	}
+	out := make([]string, len(args))
+	for i, a := range args {
+		if i > 0 && reSecretFlag.MatchString(args[i-1]) {
+			out[i] = redacted
+			continue
+		}
+		out[i] = r.Redact(a)
+	}
+	return out
+}
@@ -0,0 +1,30 @@
+//go:build !windows && !plan9
+
+package audit
+
+import "log/syslog"
+
+// SyslogSink はsyslogにレコードを送る
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// OpenSyslogSink connects to the syslog daemon.
+// If network and addr are empty, the local syslog server is used.
+func OpenSyslogSink(network, addr string) (*SyslogSink, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_AUTH, "slack-commander")
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Write sends record as a single syslog message.
+func (s *SyslogSink) Write(record []byte) error {
+	return s.w.Info(string(record))
+}
+
+// Close closes the connection.
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,23 @@
+//go:build windows || plan9
+
+package audit
+
+import "errors"
+
+// SyslogSink is not supported on this platform.
+type SyslogSink struct{}
+
+// OpenSyslogSink always fails on this platform.
+func OpenSyslogSink(_, _ string) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// Write does nothing.
+func (s *SyslogSink) Write(_ []byte) error {
+	return nil
+}
+
+// Close does nothing.
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	webhookQueueSize    = 256
+	webhookTimeout      = 10 * time.Second
+	webhookCloseTimeout = 5 * time.Second
+)
+
+// WebhookSink はレコードを1件ずつJSONでPOSTする。
+// コマンドの実行を止めないよう、送信はバックグラウンドで行う。
+type WebhookSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+	queue   chan []byte
+	done    chan struct{}
+	logf    func(format string, args ...interface{})
+}
+
+// NewWebhookSink starts a sink posting records to url.
+func NewWebhookSink(
+	url string,
+	headers map[string]string,
+	logf func(format string, args ...interface{}),
+) *WebhookSink {
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	s := &WebhookSink{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: webhookTimeout},
+		queue:   make(chan []byte, webhookQueueSize),
+		done:    make(chan struct{}),
+		logf:    logf,
+	}
+	go s.loop()
+	return s
+}
+
+// Write queues record for sending. It fails if the queue is full.
+func (s *WebhookSink) Write(record []byte) error {
+	select {
+	case s.queue <- append([]byte(nil), record...):
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full; dropping audit record")
+	}
+}
+
+func (s *WebhookSink) loop() {
+	defer close(s.done)
+	for record := range s.queue {
+		if err := s.post(record); err != nil {
+			s.logf("audit: webhook: %v", err)
+		}
+	}
+}
+
+func (s *WebhookSink) post(record []byte) error {
+	req, err := http.NewRequestWithContext(
+		context.Background(),
+		http.MethodPost,
+		s.url,
+		bytes.NewReader(record),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// Close sends the queued records and stops the sink.
+func (s *WebhookSink) Close() error {
+	close(s.queue)
+	select {
+	case <-s.done:
+		return nil
+	case <-time.After(webhookCloseTimeout):
+		return fmt.Errorf("webhook: gave up sending %d queued audit records", len(s.queue))
+	}
+}
@@ -0,0 +1,46 @@
+package audit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWebhookSinkPostsRecords(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(data))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	var errs []string
+	logf := func(format string, _ ...interface{}) {
+		errs = append(errs, format)
+	}
+	sink := NewWebhookSink(srv.URL, map[string]string{"X-Token": "secret"}, logf)
+	if err := sink.Write([]byte(`{"event":"accepted"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write([]byte(`{"event":"denied"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(bodies) != 2 || bodies[0] != `{"event":"accepted"}` || bodies[1] != `{"event":"denied"}` {
+		t.Fatalf("unexpected bodies: %v", bodies)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// runSubcommand は args[0] がサブコマンドならそれを実行して終了コードを返す。
+// サブコマンドでなければ handled に false を返す。
+func runSubcommand(args []string, stdout, stderr io.Writer) (exitCode int, handled bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+	switch args[0] {
+	case "check":
+		return runCheck(args[1:], stdout, stderr), true
+	case "match", "dry-run":
+		return runMatch(args[0], args[1:], stdout, stderr), true
+	}
+	return 0, false
+}
+
+func newSubcommandFlagSet(name string, stderr io.Writer) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	configFile := fs.String("config-file", "config.toml", "Specify configuration file")
+	return fs, configFile
+}
+
+// runCheck は設定ファイルを検証し、実行時には黙って無視される問題も報告する
+func runCheck(args []string, stdout, stderr io.Writer) int {
+	fs, configFile := newSubcommandFlagSet("check", stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "%s: %v\n", *configFile, err)
+		return 1
+	}
+	problems := cmd.CheckDefinitions(buildCommandConfigs(cfg, nil))
+	for _, p := range problems {
+		_, _ = fmt.Fprintf(stderr, "%s: %s\n", *configFile, p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	_, _ = fmt.Fprintf(stdout, "%s: OK\n", *configFile)
+	return 0
+}
+
+// runMatch は入力にマッチするコマンド定義と、実行するはずのコマンドを表示する（実行はしない）
+func runMatch(name string, args []string, stdout, stderr io.Writer) int {
+	fs, configFile := newSubcommandFlagSet(name, stderr)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		_, _ = fmt.Fprintf(stderr, "usage: slack-commander %s [-config-file file] text\n", name)
+		return 2
+	}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "%s: %v\n", *configFile, err)
+		return 1
+	}
+	results, err := cmd.Match(buildCommandConfigs(cfg, nil), strings.Join(fs.Args(), " "))
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	exitCode := 0
+	for _, r := range results {
+		if !printMatchResult(stdout, r) {
+			exitCode = 1
+		}
+	}
+	return exitCode
+}
+
+func printMatchResult(w io.Writer, r *cmd.MatchResult) bool {
+	_, _ = fmt.Fprintf(w, "> %s\n", strings.Join(r.Input, " "))
+	if r.Config == nil {
+		_, _ = fmt.Fprintln(w, "no matching command")
+		return false
+	}
+	runner := r.Config.Runner
+	if runner == "" {
+		runner = "exec"
+	}
+	_, _ = fmt.Fprintf(w, "keyword: %s\nrunner:  %s\n", r.Config.Keyword, runner)
+	if r.Request != nil {
+		printRequest(w, r.Request)
+	} else {
+		_, _ = fmt.Fprintf(w, "argv:    %q\n", r.Args)
+	}
+	return true
+}
+
+func printRequest(w io.Writer, req *http.Request) {
+	_, _ = fmt.Fprintf(w, "request: %s %s\n", req.Method, req.URL)
+	names := make([]string, 0, len(req.Header))
+	for k := range req.Header {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		_, _ = fmt.Fprintf(w, "header:  %s: %s\n", k, strings.Join(req.Header[k], ", "))
+	}
+	if req.Body == nil {
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "body:    (error: %v)\n", err)
+		return
+	}
+	if len(body) > 0 {
+		_, _ = fmt.Fprintf(w, "body:\n%s\n", body)
+	}
+}
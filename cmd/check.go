@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/mattn/go-shellwords"
+)
+
+// Problem は CheckDefinitions が見つけた設定の問題
+type Problem struct {
+	Keyword string
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("keyword '%s': %s", p.Keyword, p.Message)
+}
+
+// CheckDefinitions は実行時には黙って無視される設定の問題を探す。
+// 見つけるのは、パースできないキーワード、先に定義されたキーワードに隠れて決してマッチしないキーワード、
+// 見つからない実行ファイル、パースできない http ランナーのURL。
+func CheckDefinitions(cfgs []*CommandConfig) []Problem {
+	var problems []Problem
+	var matchers []*Matcher
+	for _, cfg := range cfgs {
+		m := newMatcher(cfg)
+		if m == nil {
+			problems = append(problems, Problem{cfg.Keyword, "keyword cannot be parsed"})
+			continue
+		}
+		for _, prev := range matchers {
+			if shadows(prev.keywords, m.keywords) {
+				problems = append(problems, Problem{
+					cfg.Keyword,
+					fmt.Sprintf("unreachable: shadowed by keyword '%s'", prev.cfg.Keyword),
+				})
+				break
+			}
+		}
+		matchers = append(matchers, m)
+		if msg := checkRunnerTarget(cfg); msg != "" {
+			problems = append(problems, Problem{cfg.Keyword, msg})
+		}
+	}
+	return problems
+}
+
+// shadows は a にマッチする入力が b にマッチする入力を全て含むかを返す
+func shadows(a, b []string) bool {
+	aPrefix, aSuffix, aWild := splitAtWildcard(a)
+	bPrefix, bSuffix, bWild := splitAtWildcard(b)
+	if !aWild {
+		// ワイルドカードなしのキーワードは同一のキーワードだけを隠す
+		return !bWild && equalStrings(a, b)
+	}
+	if !bWild {
+		_, ok := matchKeywords(a, b, true)
+		return ok
+	}
+	return len(aPrefix) <= len(bPrefix) && equalStrings(aPrefix, bPrefix[:len(aPrefix)]) &&
+		len(aSuffix) <= len(bSuffix) && equalStrings(aSuffix, bSuffix[len(bSuffix)-len(aSuffix):])
+}
+
+func splitAtWildcard(keywords []string) ([]string, []string, bool) {
+	for i, v := range keywords {
+		if v == "*" {
+			return keywords[:i], keywords[i+1:], true
+		}
+	}
+	return keywords, nil, false
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRunnerTarget はランナーの呼び出し先を確認し、問題があればその説明を返す
+func checkRunnerTarget(cfg *CommandConfig) string {
+	switch strings.ToLower(strings.TrimSpace(cfg.Runner)) {
+	case "", "exec":
+		args, err := shellwords.Parse(strings.Replace(cfg.Command, "*", "", 1))
+		if err != nil || len(args) == 0 {
+			return "command cannot be parsed"
+		}
+		if _, err := exec.LookPath(args[0]); err != nil {
+			return fmt.Sprintf("executable not found: %s", args[0])
+		}
+	case "http":
+		// ワイルドカードには実行時に何かしらの文字列が入る
+		u, err := url.Parse(strings.Replace(cfg.URL, "*", "x", 1))
+		if err != nil {
+			return fmt.Sprintf("invalid url: %v", err)
+		}
+		if u.Scheme == "" || u.Host == "" {
+			return fmt.Sprintf("invalid url: %s", cfg.URL)
+		}
+	}
+	return ""
+}
+
+// MatchResult は Match が返す、1つのコマンドに対するマッチ結果
+type MatchResult struct {
+	Input   []string       // 入力されたコマンドのトークン
+	Config  *CommandConfig // マッチしたコマンド定義（マッチしなければnil）
+	Args    []string       // 組み立てたコマンドライン
+	Request *http.Request  // http ランナーの場合に送信するリクエスト
+}
+
+// Match は text を実行した場合にどのコマンド定義がマッチし、何を実行するかを返す。
+// コマンドは実行しない。
+func Match(cfgs []*CommandConfig, text string) ([]*MatchResult, error) {
+	cmdMsg, stdinText := splitCommandInput(text)
+	cmds, err := parseCommands(cmdMsg)
+	if err != nil {
+		return nil, err
+	}
+	matchers := buildMatchers(cfgs, func(*CommandConfig) CommandRunner { return nil })
+	results := make([]*MatchResult, 0, len(cmds))
+	for _, c := range cmds {
+		r := &MatchResult{Input: c.args}
+		results = append(results, r)
+		m, args := findMatchedMatcher(c, matchers)
+		if m == nil {
+			continue
+		}
+		r.Config = m.cfg
+		r.Args = args
+		if strings.ToLower(strings.TrimSpace(m.cfg.Runner)) != "http" {
+			continue
+		}
+		stdin := stdinText
+		if c.pipedFromPrev {
+			// パイプの途中のコマンドの標準入力は前のコマンドを実行しないとわからない
+			stdin = ""
+		}
+		hc := NewHTTPRunner(m.cfg).CommandContext(context.Background(), "", args[1:]...).(*httpCmd)
+		hc.SetStdin(strings.NewReader(stdin))
+		req, err := hc.buildRequest()
+		if err != nil {
+			return nil, err
+		}
+		r.Request = req
+	}
+	return results, nil
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestCheckDefinitions(t *testing.T) {
+	cfgs := []*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "ping *", Command: "echo *"}, nil),
+		NewCommandConfig(&Definition{Keyword: "ping localhost", Command: "echo localhost"}, nil),
+		NewCommandConfig(&Definition{Keyword: "ping * now", Command: "echo *"}, nil),
+		NewCommandConfig(&Definition{Keyword: "echo 'x", Command: "echo"}, nil),
+		NewCommandConfig(&Definition{Keyword: "missing", Command: "no-such-command-xyz"}, nil),
+		NewCommandConfig(&Definition{Keyword: "hook", Runner: "http", URL: "://bad"}, nil),
+		NewCommandConfig(&Definition{Keyword: "true", Command: "true"}, nil),
+	}
+	got := CheckDefinitions(cfgs)
+	want := []Problem{
+		{"ping localhost", "unreachable: shadowed by keyword 'ping *'"},
+		{"ping * now", "unreachable: shadowed by keyword 'ping *'"},
+		{"echo 'x", "keyword cannot be parsed"},
+		{"missing", "executable not found: no-such-command-xyz"},
+	}
+	if len(got) != len(want)+1 {
+		t.Fatalf("unexpected problems: %v", got)
+	}
+	if !reflect.DeepEqual(got[:len(want)], want) {
+		t.Fatalf("unexpected problems: %v", got)
+	}
+	if got[len(want)].Keyword != "hook" {
+		t.Fatalf("expected url problem, got %v", got[len(want)])
+	}
+}
+
+func TestShadows(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"ping *", "ping * -c4", true},
+		{"ping * now", "ping *", false},
+		{"* now", "ping * now", true},
+		{"ping", "ping *", false},
+		{"ping x", "ping x", true},
+	}
+	for _, tt := range tests {
+		a := newMatcher(NewCommandConfig(&Definition{Keyword: tt.a}, nil))
+		b := newMatcher(NewCommandConfig(&Definition{Keyword: tt.b}, nil))
+		if got := shadows(a.keywords, b.keywords); got != tt.want {
+			t.Errorf("shadows(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cfgs := []*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "transfer *", Command: "bin/transfer --to *"}, nil),
+		NewCommandConfig(&Definition{
+			Keyword:  "hook *",
+			Runner:   "http",
+			Method:   "PUT",
+			URL:      "https://example.com/*",
+			BodyFrom: "stdin",
+		}, nil),
+	}
+	results, err := Match(cfgs, "transfer foo 1000 && hook bar || nope\npayload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("unexpected results: %v", results)
+	}
+	if want := []string{"bin/transfer", "--to", "foo", "1000"}; !reflect.DeepEqual(results[0].Args, want) {
+		t.Errorf("unexpected args: %q", results[0].Args)
+	}
+	req := results[1].Request
+	if req == nil || req.Method != "PUT" || req.URL.String() != "https://example.com/bar" {
+		t.Fatalf("unexpected request: %v", req)
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != "payload" {
+		t.Errorf("unexpected body: %q", body)
+	}
+	if results[2].Config != nil {
+		t.Errorf("expected no match for %v", results[2].Input)
+	}
+}
@@ -2,42 +2,102 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/mattn/go-shellwords"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hnw/slack-commander/audit"
+	"github.com/hnw/slack-commander/metrics"
+	"github.com/hnw/slack-commander/tracing"
 )
 
 // CommandInput はPubSubからの情報をExecutorに引き渡す構造体
 type CommandInput struct {
-	ReplyInfo interface{} // PubSubの返信に必要な構造体（PubSubの種類ごとにキャストして利用する）
-	Text      string      // 起動コマンド平文
+	ReplyInfo interface{}  // PubSubの返信に必要な構造体（PubSubの種類ごとにキャストして利用する）
+	Text      string       // 起動コマンド平文
+	Files     []*InputFile // メッセージに添付されたファイル
+	SenderID  string       // 発言者のID（botの場合はbot ID）
+	ChannelID string       // 発言されたチャンネルのID
+	// Done はコマンドの実行が終わった時に終了コードを引数に呼ばれる（nilでもよい）
+	Done func(exitCode int)
+	// Context はトレースの伝搬に使う（nilでもよい）。キャンセルには使わない。
+	Context context.Context
+	// EnqueuedAt はcommandQueueに投入した時刻（ゼロ値でもよい）
+	EnqueuedAt time.Time
+	// AllowedCommands は実行できるコマンド定義のキーワード（空なら制限しない）
+	AllowedCommands []string
+	// SourceKey は起動元メッセージを識別する文字列（空でもよい）。
+	// 元メッセージが編集・削除された時に Scheduler.Cancel で実行を取り消すのに使う。
+	SourceKey string
+}
+
+// InputFile はメッセージに添付されたファイルを表す構造体
+type InputFile struct {
+	Name     string
+	MimeType string
+	// Open はファイル本体を取得する（Slackの場合はダウンロードが発生する）
+	Open func(ctx context.Context) (io.ReadCloser, error)
 }
 
 // CommandOutput はExecutorからの実行結果を引き渡してPubSubに書き出すための構造体
 type CommandOutput struct {
 	ReplyInfo   interface{}
 	ReplyConfig interface{}
-	Text        string // コマンドからのテキスト出力（ImageData と排他）
-	ImageData   []byte // sixel を変換した PNG バイト列（Text と排他）
+	Text        string          // コマンドからのテキスト出力（ImageData と排他）
+	ImageData   []byte          // sixel を変換した PNG バイト列（Text と排他）
+	Blocks      json.RawMessage // Block KitのブロックのJSON配列（Text, ImageData と排他）
 	IsErrOut    bool
 	Spawned     bool
 	Finished    bool
 	ExitCode    int
+	JobID       uint64 // ジョブ履歴を記録している場合のジョブID（記録していなければ0）
+	// Queued はワーカーが空くのを待っていることを、Dequeued は待っていた入力の実行が始まるか取り消されたことを表す。
+	// QueueFull は待っている入力が多すぎて受け付けなかったことを、
+	// Rejected は max_concurrency や lock_group の上限に達して実行しなかったこと（on_limit = "reject"）を表す。
+	Queued        bool
+	Dequeued      bool
+	QueueFull     bool
+	Rejected      bool
+	QueuePosition int // Queued の場合の待ち順（1始まり）
+	// Context はトレースの伝搬に使う（nilでもよい）。キャンセルには使わない。
+	Context context.Context
 }
 
 // Definition describes a command definition in the configuration.
 type Definition struct {
-	Timeout int
-	Keyword string
-	Command string
-	Runner  string
-	Method  string
-	URL     string
-	Headers map[string]string
-	Body    string
+	Timeout        int
+	Keyword        string
+	Command        string
+	Runner         string
+	Method         string
+	URL            string
+	Headers        map[string]string
+	Body           string
+	BodyFrom       string `toml:"body_from"`
+	MultipartField string `toml:"multipart_field"`
+	Target         string
+	DescriptorSet  string `toml:"descriptor_set"`
+	OutputTemplate string `toml:"output_template"`
+	TLS            bool   `toml:"tls"`
+	Script         string
+	ScriptFile     string `toml:"script_file"`
+	MaxSteps       int    `toml:"max_steps"`
+	PreopenDir     string `toml:"preopen_dir"`
+	MaxMemoryPages int    `toml:"max_memory_pages"`
+	MaxConcurrency int    `toml:"max_concurrency"`
+	LockGroup      string `toml:"lock_group"`
+	OnLimit        string `toml:"on_limit"`
+	Priority       int    `toml:"priority"`
+	RateLimit      string `toml:"rate_limit"`
 }
 
 // CommandConfig holds a Definition with reply configuration.
@@ -62,6 +122,31 @@ func Executor(rq chan *CommandInput, wq chan *CommandOutput, cfgs []*CommandConf
 // RunnerFactory returns a runner for the given command config.
 type RunnerFactory func(cfg *CommandConfig) CommandRunner
 
+// ExecutorOption configures optional behavior of ExecutorWithRunner.
+type ExecutorOption func(*executorOptions)
+
+type executorOptions struct {
+	jobStore JobStore
+	jobLogf  func(format string, args ...interface{})
+	audit    *audit.Logger
+}
+
+// WithJobStore records every executed command to store.
+// Failures to record a job are reported with logf (ignored when logf is nil).
+func WithJobStore(store JobStore, logf func(format string, args ...interface{})) ExecutorOption {
+	return func(o *executorOptions) {
+		o.jobStore = store
+		o.jobLogf = logf
+	}
+}
+
+// WithAuditLogger records matched, started, cancelled and finished events to l.
+func WithAuditLogger(l *audit.Logger) ExecutorOption {
+	return func(o *executorOptions) {
+		o.audit = l
+	}
+}
+
 // ExecutorWithRunner runs commands using runners provided by runnerFactory.
 func ExecutorWithRunner(
 	ctx context.Context,
@@ -69,9 +154,24 @@ func ExecutorWithRunner(
 	wq chan *CommandOutput,
 	cfgs []*CommandConfig,
 	runnerFactory RunnerFactory,
+	opts ...ExecutorOption,
 ) {
-	runnerFactory = normalizeRunnerFactory(runnerFactory)
-	matchers := buildMatchers(cfgs, runnerFactory)
+	ExecutorWithTable(ctx, rq, wq, NewCommandTable(cfgs, runnerFactory), opts...)
+}
+
+// ExecutorWithTable runs commands defined in table.
+// Changes made by table.Update take effect from the next input.
+func ExecutorWithTable(
+	ctx context.Context,
+	rq chan *CommandInput,
+	wq chan *CommandOutput,
+	table *CommandTable,
+	opts ...ExecutorOption,
+) {
+	var o executorOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
 
 	if ctx == nil {
 		ctx = context.Background()
@@ -84,11 +184,78 @@ func ExecutorWithRunner(
 			if !ok {
 				return
 			}
-			cmdMsg, stdinText := splitCommandInput(input.Text)
-			cmds, parseErr := parseCommands(cmdMsg)
-			_ = executeCommands(ctx, cmds, parseErr, stdinText, input, matchers, wq)
+			// 入力ごとにその時点のコマンド定義を使う
+			ret := runInput(ctx, input, table.load(), wq, &o)
+			if input.Done != nil {
+				input.Done(ret)
+			}
+		}
+	}
+}
+
+func runInput(
+	ctx context.Context,
+	input *CommandInput,
+	matchers []*Matcher,
+	wq chan *CommandOutput,
+	o *executorOptions,
+) int {
+	ctx, span := startExecuteSpan(ctx, input)
+	defer span.End()
+
+	matchers = restrictMatchers(matchers, input.AllowedCommands)
+	cmdMsg, stdinText := splitCommandInput(input.Text)
+	cmds, parseErr := parseCommands(cmdMsg)
+	hooks := execHooks{audit: o.audit}
+	if o.jobStore == nil {
+		return executeCommands(ctx, cmds, parseErr, stdinText, input, matchers, wq, hooks)
+	}
+	rec := newJobRecorder(o.jobStore, o.jobLogf, input, wq)
+	hooks.onStart = rec.start
+	ret := executeCommands(ctx, cmds, parseErr, stdinText, input, matchers, rec.out, hooks)
+	rec.finish(ret)
+	return ret
+}
+
+// restrictMatchers はキーワードが keywords に含まれるマッチャーだけを返す。keywords が空なら全て返す。
+func restrictMatchers(matchers []*Matcher, keywords []string) []*Matcher {
+	if len(keywords) == 0 {
+		return matchers
+	}
+	allowed := make(map[string]bool, len(keywords))
+	for _, kw := range keywords {
+		allowed[kw] = true
+	}
+	restricted := make([]*Matcher, 0, len(matchers))
+	for _, m := range matchers {
+		if allowed[m.cfg.Keyword] {
+			restricted = append(restricted, m)
 		}
 	}
+	return restricted
+}
+
+// startExecuteSpan はinput.Contextのトレースを引き継いでExecutorのspanを開始する。
+// キュー待ちの時間も別のspanとして記録する。
+func startExecuteSpan(ctx context.Context, input *CommandInput) (context.Context, trace.Span) {
+	if input.Context != nil {
+		ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(input.Context))
+	}
+	tracer := tracing.Tracer()
+	if !input.EnqueuedAt.IsZero() {
+		_, wait := tracer.Start(ctx, "queue.wait", trace.WithTimestamp(input.EnqueuedAt))
+		wait.End()
+	}
+	return tracer.Start(ctx, "executor.execute", trace.WithAttributes(
+		attribute.String("slack.user_id", input.SenderID),
+		attribute.String("slack.channel_id", input.ChannelID),
+	))
+}
+
+// execHooks はコマンド実行の途中で呼び出す処理をまとめたもの
+type execHooks struct {
+	onStart func(m *Matcher) // 最初のコマンドの実行開始時に呼ばれる（nilでもよい）
+	audit   *audit.Logger    // nilでもよい
 }
 
 func normalizeRunnerFactory(runnerFactory RunnerFactory) RunnerFactory {
@@ -148,27 +315,40 @@ func executeCommands(
 	input *CommandInput,
 	matchers []*Matcher,
 	wq chan *CommandOutput,
+	hooks execHooks,
 ) int {
 	ret := 0
-	for i, cmd := range cmds {
-		if shouldSkipCommand(cmd, ret) {
+	for i := 0; i < len(cmds); {
+		// パイプでつながったコマンド列をまとめて1つのパイプラインとして扱う
+		pipeline := collectPipeline(cmds, i)
+		first := i == 0
+		i += len(pipeline)
+		if shouldSkipCommand(pipeline[0], ret) {
 			continue
 		}
 		ret = -1
-		m, args := findMatchedMatcher(cmd, matchers)
-		if m == nil {
-			if i == 0 {
-				// キーワードにマッチしなかったらparse errorがあっても表示せず終了
-				return 0
-			}
-			ret = writeCommandNotFound(wq, input, cmd)
-			continue
+		_, matchSpan := tracing.Tracer().Start(ctx, "executor.match")
+		stages, notFound := matchPipeline(pipeline, matchers)
+		matchSpan.SetAttributes(attribute.Bool("matched", notFound == nil))
+		matchSpan.End()
+		if first && notFound == pipeline[0] {
+			// キーワードにマッチしなかったらparse errorがあっても表示せず終了
+			metrics.CommandUnmatched()
+			return 0
 		}
-		if i == 0 {
+		for _, st := range stages {
+			metrics.CommandMatched(st.m.cfg.Keyword)
+			hooks.audit.Log(auditRecord(audit.EventMatched, input, st.m, st.args))
+		}
+		if first {
+			if hooks.onStart != nil {
+				hooks.onStart(stages[0].m)
+			}
 			// コマンド実行開始を通知
 			wq <- &CommandOutput{
 				ReplyInfo: input.ReplyInfo,
 				Spawned:   true,
+				Context:   ctx,
 			}
 			// 関数を抜ける時に必ず終了通知を送る
 			defer func() {
@@ -176,20 +356,82 @@ func executeCommands(
 					ReplyInfo: input.ReplyInfo,
 					Finished:  true,
 					ExitCode:  ret,
+					Context:   ctx,
 				}
 			}()
 		}
 		if parseErr != nil {
 			// parse errorありで1つ目のコマンドがキーワードマッチした場合
 			// エラー表示して処理全体を終了
-			ret = writeParseError(wq, input, parseErr)
+			ret = writeParseError(ctx, wq, input, parseErr)
 			return ret
 		}
-		ret = runMatchedCommand(ctx, m, args, stdinText, input, wq)
+		if notFound != nil {
+			metrics.CommandUnmatched()
+			ret = writeCommandNotFound(ctx, wq, input, notFound)
+			continue
+		}
+		ret = runSegment(ctx, stages, stdinText, input, wq, hooks.audit)
 	}
 	return ret
 }
 
+// runSegment は連結されたコマンドのうち1つ（パイプラインを含む）をspanの中で実行する
+func runSegment(
+	ctx context.Context,
+	stages []pipelineStage,
+	stdinText string,
+	input *CommandInput,
+	wq chan *CommandOutput,
+	al *audit.Logger,
+) int {
+	keywords := make([]string, len(stages))
+	for i, st := range stages {
+		keywords[i] = st.m.cfg.Keyword
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "executor.segment", trace.WithAttributes(
+		attribute.StringSlice("command.keywords", keywords),
+	))
+	defer span.End()
+	ret := runPipeline(ctx, stages, stdinText, input, wq, al)
+	span.SetAttributes(attribute.Int("command.exit_code", ret))
+	if ret != 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("exit code %d", ret))
+	}
+	return ret
+}
+
+// collectPipeline はcmds[start]から始まり、パイプでつながったコマンド列を返す
+func collectPipeline(cmds []*parsedCommand, start int) []*parsedCommand {
+	end := start + 1
+	for end < len(cmds) && cmds[end].pipedFromPrev {
+		end++
+	}
+	return cmds[start:end]
+}
+
+type pipelineStage struct {
+	m    *Matcher
+	args []string
+}
+
+// matchPipeline はパイプラインの各コマンドをキーワードマッチさせる。
+// マッチしないコマンドがあった場合は、そこまでにマッチした分とそのコマンドを返す。
+func matchPipeline(
+	pipeline []*parsedCommand,
+	matchers []*Matcher,
+) ([]pipelineStage, *parsedCommand) {
+	stages := make([]pipelineStage, 0, len(pipeline))
+	for _, cmd := range pipeline {
+		m, args := findMatchedMatcher(cmd, matchers)
+		if m == nil {
+			return stages, cmd
+		}
+		stages = append(stages, pipelineStage{m: m, args: args})
+	}
+	return stages, nil
+}
+
 func shouldSkipCommand(cmd *parsedCommand, ret int) bool {
 	if ret == 0 && cmd.skipIfSucceeded {
 		return true
@@ -197,28 +439,119 @@ func shouldSkipCommand(cmd *parsedCommand, ret int) bool {
 	return ret != 0 && cmd.skipIfFailed
 }
 
-func writeParseError(wq chan *CommandOutput, input *CommandInput, parseErr error) int {
-	syserr := newErrWriter(wq, input.ReplyInfo, nil)
+func writeParseError(
+	ctx context.Context,
+	wq chan *CommandOutput,
+	input *CommandInput,
+	parseErr error,
+) int {
+	syserr := newErrWriter(ctx, wq, input.ReplyInfo, nil)
 	_, _ = fmt.Fprintf(syserr, "%v", parseErr)
 	_ = syserr.Flush()
 	return 2
 }
 
-func writeCommandNotFound(wq chan *CommandOutput, input *CommandInput, cmd *parsedCommand) int {
-	syserr := newErrWriter(wq, input.ReplyInfo, nil)
+func writeCommandNotFound(
+	ctx context.Context,
+	wq chan *CommandOutput,
+	input *CommandInput,
+	cmd *parsedCommand,
+) int {
+	syserr := newErrWriter(ctx, wq, input.ReplyInfo, nil)
 	_, _ = fmt.Fprintf(syserr, "コマンドが見つかりませんでした: %v", strings.Join(cmd.args, " "))
 	_ = syserr.Flush()
 	return 127
 }
 
+// runPipeline はパイプラインの各コマンドを並行して起動し、前段の標準出力を
+// 次段の標準入力につなぐ。Slackにポストされる標準出力は最後のコマンドのものだけで、
+// 標準エラー出力は全てのコマンドのものがポストされる。
+// 終了コードは pipefail 相当（0以外で終了した一番右のコマンドの終了コード）。
+func runPipeline(
+	ctx context.Context,
+	stages []pipelineStage,
+	stdinText string,
+	input *CommandInput,
+	wq chan *CommandOutput,
+	al *audit.Logger,
+) int {
+	last := stages[len(stages)-1]
+	stdout := newStdWriter(ctx, wq, input.ReplyInfo, last.m.cfg.ReplyConfig)
+	exitCodes := make([]int, len(stages))
+
+	var wg sync.WaitGroup
+	var stdin io.Reader = strings.NewReader(stdinText)
+	for i, st := range stages {
+		var out io.Writer = stdout
+		var pr *io.PipeReader
+		var pw *io.PipeWriter
+		if i < len(stages)-1 {
+			pr, pw = io.Pipe()
+			out = &pipeWriter{pw: pw}
+		}
+		in := stdin
+		stderr := newErrWriter(ctx, wq, input.ReplyInfo, st.m.cfg.ReplyConfig)
+		wg.Add(1)
+		go func(i int, st pipelineStage) {
+			defer wg.Done()
+			exitCodes[i] = runMatchedCommand(ctx, st.m, st.args, in, out, stderr, input, wq, al)
+			_ = stderr.Flush()
+			if pw != nil {
+				// 次段に EOF を伝える
+				_ = pw.Close()
+			}
+			if r, ok := in.(*io.PipeReader); ok {
+				// 前段が書き込み続けてもブロックしないようにする
+				_ = r.Close()
+			}
+		}(i, st)
+		stdin = pr
+	}
+	wg.Wait()
+	_ = stdout.Flush()
+
+	ret := 0
+	for _, code := range exitCodes {
+		if code != 0 {
+			ret = code
+		}
+	}
+	return ret
+}
+
+// pipeWriter は後段が先に終了して読み手がいなくなった場合に、
+// 書き込みを捨てて前段をエラー終了させないための io.Writer
+type pipeWriter struct {
+	pw *io.PipeWriter
+}
+
+func (w *pipeWriter) Write(data []byte) (int, error) {
+	if _, err := w.pw.Write(data); err != nil {
+		if errors.Is(err, io.ErrClosedPipe) {
+			return len(data), nil
+		}
+		return 0, err
+	}
+	return len(data), nil
+}
+
 func runMatchedCommand(
 	ctx context.Context,
 	m *Matcher,
 	args []string,
-	stdinText string,
+	stdin io.Reader,
+	stdout io.Writer,
+	stderr io.Writer,
 	input *CommandInput,
 	wq chan *CommandOutput,
+	al *audit.Logger,
 ) int {
+	ctx, span := tracing.Tracer().Start(ctx, "runner.run", trace.WithAttributes(
+		attribute.String("command.keyword", m.cfg.Keyword),
+		attribute.String("command.runner", runnerName(m.cfg.Runner)),
+	))
+	defer span.End()
+
 	var cmdCtx context.Context
 	var cancel context.CancelFunc
 	if m.cfg.Timeout > 0 {
@@ -232,36 +565,85 @@ func runMatchedCommand(
 	defer cancel()
 
 	execCmd := m.runner.CommandContext(cmdCtx, args[0], args[1:]...)
-	execCmd.SetStdin(strings.NewReader(stdinText))
-	stdout := newStdWriter(wq, input.ReplyInfo, m.cfg.ReplyConfig)
-	stderr := newErrWriter(wq, input.ReplyInfo, m.cfg.ReplyConfig)
+	execCmd.SetStdin(stdin)
+	if s, ok := execCmd.(InputSetter); ok {
+		s.SetInput(input)
+	}
 	execCmd.SetStdout(stdout)
 	execCmd.SetStderr(stderr)
+	if p, ok := execCmd.(BlockPoster); ok {
+		p.SetBlockPoster(func(blocks json.RawMessage) {
+			// それまでのテキスト出力より後に表示されるようにする
+			if w, ok := stdout.(*OutputWriter); ok {
+				_ = w.Flush()
+			}
+			wq <- &CommandOutput{
+				ReplyInfo:   input.ReplyInfo,
+				ReplyConfig: m.cfg.ReplyConfig,
+				Blocks:      blocks,
+				Context:     ctx,
+			}
+		})
+	}
+	al.Log(auditRecord(audit.EventStarted, input, m, args))
+	startedAt := time.Now()
 	ret := execCmd.Run(m.cfg.Timeout)
-	_ = stdout.Flush()
-	_ = stderr.Flush()
-
+	metrics.CommandFinished(m.cfg.Keyword, m.cfg.Runner, time.Since(startedAt).Seconds(), ret)
+	rec := auditRecord(audit.EventFinished, input, m, args)
+	rec.ExitCode = audit.ExitCode(ret)
+	if err := cmdCtx.Err(); err != nil {
+		rec.Event = audit.EventCancelled
+		rec.Reason = err.Error()
+	}
+	al.Log(rec)
+	span.SetAttributes(attribute.Int("command.exit_code", ret))
+	if ret != 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("exit code %d", ret))
+	}
 	return ret
 }
 
+func runnerName(runner string) string {
+	if runner == "" {
+		return "exec"
+	}
+	return runner
+}
+
+func auditRecord(event string, input *CommandInput, m *Matcher, args []string) audit.Record {
+	return audit.Record{
+		Event:     event,
+		UserID:    input.SenderID,
+		ChannelID: input.ChannelID,
+		Keyword:   m.cfg.Keyword,
+		Runner:    m.cfg.Runner,
+		Argv:      args,
+	}
+}
+
 type parsedCommand struct {
 	skipIfSucceeded bool
 	skipIfFailed    bool
+	pipedFromPrev   bool // 直前のコマンドの標準出力を標準入力として受け取る
 	args            []string
 }
 
 func newParsedCommand(op string, args []string) *parsedCommand {
 	skipIfSucceeded := false
 	skipIfFailed := false
+	pipedFromPrev := false
 	switch op {
 	case "&&":
 		skipIfFailed = true
 	case "||":
 		skipIfSucceeded = true
+	case "|":
+		pipedFromPrev = true
 	}
 	return &parsedCommand{
 		skipIfSucceeded: skipIfSucceeded,
 		skipIfFailed:    skipIfFailed,
+		pipedFromPrev:   pipedFromPrev,
 		args:            args,
 	}
 }
@@ -293,7 +675,7 @@ func parse(line string) ([]*parsedCommand, error) {
 		}
 		i := parser.Position
 		token := line[i:]
-		operators := []string{";", "&&", "||"}
+		operators := []string{";", "&&", "||", "|"} // 「||」を「|」より先に判定する
 		prevOperator = ""
 		for _, op := range operators {
 			if strings.HasPrefix(token, op) {
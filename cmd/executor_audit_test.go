@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnw/slack-commander/audit"
+)
+
+type auditTestSink struct {
+	buf bytes.Buffer
+}
+
+func (s *auditTestSink) Write(record []byte) error {
+	s.buf.Write(record)
+	s.buf.WriteByte('\n')
+	return nil
+}
+
+func (s *auditTestSink) Close() error { return nil }
+
+func (s *auditTestSink) records(t *testing.T) []audit.Record {
+	t.Helper()
+	var recs []audit.Record
+	for _, line := range strings.Split(strings.TrimSpace(s.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var r audit.Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		recs = append(recs, r)
+	}
+	return recs
+}
+
+func runAuditExecutor(t *testing.T, input *CommandInput, timeout int) []audit.Record {
+	t.Helper()
+	sink := &auditTestSink{}
+	l := audit.NewLogger([]audit.Sink{sink}, nil, "", nil)
+	cfgs := []*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "emit *", Command: "emit *"}, nil),
+		NewCommandConfig(&Definition{Keyword: "upper", Command: "upper"}, nil),
+		NewCommandConfig(&Definition{Keyword: "sleep", Command: "sleep 10", Timeout: timeout}, nil),
+	}
+	rq := make(chan *CommandInput, 1)
+	wq := make(chan *CommandOutput, 20)
+	done := make(chan struct{})
+	go func() {
+		ExecutorWithRunner(context.Background(), rq, wq, cfgs, func(cfg *CommandConfig) CommandRunner {
+			if cfg.Keyword == "sleep" {
+				return NewExecRunner()
+			}
+			return &pipeTestRunner{}
+		}, WithAuditLogger(l))
+		close(done)
+	}()
+	rq <- input
+	close(rq)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executor did not finish")
+	}
+	drainOutputs(wq)
+	return sink.records(t)
+}
+
+func TestExecutorAuditEvents(t *testing.T) {
+	input := &CommandInput{Text: "emit password=hunter2 | upper", SenderID: "U1", ChannelID: "C1"}
+	recs := runAuditExecutor(t, input, 0)
+	var events []string
+	for _, r := range recs {
+		events = append(events, r.Event)
+		if r.UserID != "U1" || r.ChannelID != "C1" {
+			t.Fatalf("unexpected ids: %+v", r)
+		}
+		if strings.Contains(strings.Join(r.Argv, " "), "hunter2") {
+			t.Fatalf("secret was not redacted: %+v", r)
+		}
+	}
+	// パイプラインの各段が並行して動くので started/finished の順序は決まらない
+	counts := map[string]int{}
+	for _, e := range events {
+		counts[e]++
+	}
+	if counts[audit.EventMatched] != 2 ||
+		counts[audit.EventStarted] != 2 ||
+		counts[audit.EventFinished] != 2 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	if events[0] != audit.EventMatched || events[1] != audit.EventMatched {
+		t.Fatalf("expected matched events first: %v", events)
+	}
+}
+
+func TestExecutorAuditCancelled(t *testing.T) {
+	recs := runAuditExecutor(t, &CommandInput{Text: "sleep"}, 1)
+	last := recs[len(recs)-1]
+	if last.Event != audit.EventCancelled || last.ExitCode == nil || *last.ExitCode != 143 {
+		t.Fatalf("expected cancelled event, got %+v", last)
+	}
+}
+
+func TestExecutorAuditIgnoresUnmatchedInput(t *testing.T) {
+	recs := runAuditExecutor(t, &CommandInput{Text: "hello"}, 0)
+	if len(recs) != 0 {
+		t.Fatalf("expected no records, got %+v", recs)
+	}
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeTestRunner はコマンド名に応じて標準入出力を加工するテスト用ランナー
+type pipeTestRunner struct{}
+
+func (r *pipeTestRunner) CommandContext(_ context.Context, name string, arg ...string) Cmd {
+	return &pipeTestCmd{name: name, args: arg}
+}
+
+type pipeTestCmd struct {
+	name   string
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *pipeTestCmd) SetStdin(r io.Reader)  { c.stdin = r }
+func (c *pipeTestCmd) SetStdout(w io.Writer) { c.stdout = w }
+func (c *pipeTestCmd) SetStderr(w io.Writer) { c.stderr = w }
+
+func (c *pipeTestCmd) Run(_ int) int {
+	switch c.name {
+	case "emit":
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(c.stdout, "%s %d\n", strings.Join(c.args, " "), i)
+		}
+		return 0
+	case "upper":
+		data, _ := io.ReadAll(c.stdin)
+		_, _ = io.WriteString(c.stdout, strings.ToUpper(string(data)))
+		return 0
+	case "head1":
+		line, _ := bufio.NewReader(c.stdin).ReadString('\n')
+		_, _ = io.WriteString(c.stdout, line)
+		return 0
+	case "fail":
+		_, _ = io.Copy(io.Discard, c.stdin)
+		_, _ = io.WriteString(c.stderr, "failed")
+		return 3
+	}
+	return 127
+}
+
+func runPipeExecutor(t *testing.T, input string) []*CommandOutput {
+	t.Helper()
+	cfgs := []*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "emit *", Command: "emit *"}, nil),
+		NewCommandConfig(&Definition{Keyword: "upper", Command: "upper"}, nil),
+		NewCommandConfig(&Definition{Keyword: "head1", Command: "head1"}, nil),
+		NewCommandConfig(&Definition{Keyword: "fail", Command: "fail"}, nil),
+	}
+	rq := make(chan *CommandInput, 1)
+	wq := make(chan *CommandOutput, 20)
+	done := make(chan struct{})
+	go func() {
+		ExecutorWithRunner(context.Background(), rq, wq, cfgs, func(*CommandConfig) CommandRunner {
+			return &pipeTestRunner{}
+		})
+		close(done)
+	}()
+	rq <- &CommandInput{Text: input}
+	close(rq)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("executor did not finish")
+	}
+	return drainOutputs(wq)
+}
+
+func collectText(outputs []*CommandOutput, isErrOut bool) string {
+	var sb strings.Builder
+	for _, out := range outputs {
+		if out.IsErrOut == isErrOut {
+			sb.WriteString(out.Text)
+		}
+	}
+	return sb.String()
+}
+
+func finishedExitCode(t *testing.T, outputs []*CommandOutput) int {
+	t.Helper()
+	for _, out := range outputs {
+		if out.Finished {
+			return out.ExitCode
+		}
+	}
+	t.Fatal("finished notification not found")
+	return -1
+}
+
+func TestExecutorPipeConnectsStdoutToStdin(t *testing.T) {
+	outputs := runPipeExecutor(t, "emit foo | upper")
+	if got := collectText(outputs, false); got != "FOO 0\nFOO 1\nFOO 2\n" {
+		t.Fatalf("unexpected stdout: %q", got)
+	}
+	if code := finishedExitCode(t, outputs); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+}
+
+func TestExecutorPipeStopsReadingEarly(t *testing.T) {
+	outputs := runPipeExecutor(t, "emit foo | head1 | upper")
+	if got := collectText(outputs, false); got != "FOO 0\n" {
+		t.Fatalf("unexpected stdout: %q", got)
+	}
+}
+
+func TestExecutorPipeExitStatusIsPipefail(t *testing.T) {
+	outputs := runPipeExecutor(t, "emit foo | fail | upper")
+	if code := finishedExitCode(t, outputs); code != 3 {
+		t.Fatalf("expected exit code 3, got %d", code)
+	}
+	if got := collectText(outputs, true); got != "failed" {
+		t.Fatalf("unexpected stderr: %q", got)
+	}
+	if got := collectText(outputs, false); got != "" {
+		t.Fatalf("unexpected stdout: %q", got)
+	}
+}
+
+func TestExecutorPipeUnknownCommand(t *testing.T) {
+	outputs := runPipeExecutor(t, "emit foo | nosuch")
+	if code := finishedExitCode(t, outputs); code != 127 {
+		t.Fatalf("expected exit code 127, got %d", code)
+	}
+	if got := collectText(outputs, true); !strings.Contains(got, "nosuch") {
+		t.Fatalf("expected command not found error, got %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -16,7 +17,6 @@ func TestParse(t *testing.T) {
 		"x&",
 		"x|",
 		"x&y",
-		"x|x",
 		"x&;x",
 		"x|;x",
 		"tr -cd '[:graph:]' < /dev/urandom",
@@ -34,3 +34,31 @@ func TestParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePipe(t *testing.T) {
+	cmds, err := parse("a x | b || c | d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []struct {
+		args          string
+		pipedFromPrev bool
+		skipIfSucc    bool
+	}{
+		{args: "a x"},
+		{args: "b", pipedFromPrev: true},
+		{args: "c", skipIfSucc: true},
+		{args: "d", pipedFromPrev: true},
+	}
+	if len(cmds) != len(want) {
+		t.Fatalf("expected %d commands, got %d", len(want), len(cmds))
+	}
+	for i, w := range want {
+		c := cmds[i]
+		if strings.Join(c.args, " ") != w.args ||
+			c.pipedFromPrev != w.pipedFromPrev ||
+			c.skipIfSucceeded != w.skipIfSucc {
+			t.Errorf("command %d = %+v, want %+v", i, c, w)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTestTracing はグローバルのTracerProviderをメモリ上のexporterに差し替える
+func setupTestTracing(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prevTP := otel.GetTracerProvider()
+	prevProp := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	})
+	return exporter
+}
+
+func TestExecutorPropagatesTrace(t *testing.T) {
+	exporter := setupTestTracing(t)
+
+	var gotTraceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTraceparent = r.Header.Get("traceparent")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	cfgs := []*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "emit *", Command: "emit *"}, nil),
+		NewCommandConfig(&Definition{Keyword: "upper", Command: "upper"}, nil),
+		NewCommandConfig(&Definition{Keyword: "ping", Runner: "http", URL: srv.URL}, nil),
+	}
+	parentCtx, parent := otel.Tracer("test").Start(context.Background(), "slack.receive")
+	parent.End()
+
+	rq := make(chan *CommandInput, 1)
+	wq := make(chan *CommandOutput, 20)
+	done := make(chan struct{})
+	go func() {
+		ExecutorWithRunner(context.Background(), rq, wq, cfgs, func(cfg *CommandConfig) CommandRunner {
+			if cfg.Runner == "http" {
+				return NewHTTPRunner(cfg)
+			}
+			return &pipeTestRunner{}
+		})
+		close(done)
+	}()
+	rq <- &CommandInput{
+		Text:       "emit foo | upper && ping",
+		Context:    parentCtx,
+		EnqueuedAt: time.Now().Add(-time.Second),
+	}
+	close(rq)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("executor did not finish")
+	}
+
+	traceID := parent.SpanContext().TraceID()
+	counts := map[string]int{}
+	for _, s := range exporter.GetSpans() {
+		if s.SpanContext.TraceID() != traceID {
+			t.Fatalf("span %s is not in the parent trace", s.Name)
+		}
+		counts[s.Name]++
+	}
+	want := map[string]int{
+		"slack.receive":    1,
+		"queue.wait":       1,
+		"executor.execute": 1,
+		"executor.match":   2,
+		"executor.segment": 2,
+		"runner.run":       3,
+	}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("expected %d %s spans, got %d (all: %v)", n, name, counts[name], counts)
+		}
+	}
+
+	for _, out := range drainOutputs(wq) {
+		if trace.SpanContextFromContext(out.Context).TraceID() != traceID {
+			t.Fatalf("output does not carry the trace: %+v", out)
+		}
+	}
+
+	if gotTraceparent == "" {
+		t.Fatal("traceparent header was not sent")
+	}
+	if gotTraceparent[3:35] != traceID.String() {
+		t.Fatalf("traceparent %q does not match trace %s", gotTraceparent, traceID)
+	}
+}
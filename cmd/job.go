@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxJobOutput はジョブの記録に残す出力の最大バイト数
+const maxJobOutput = 4000
+
+// Job はCommandInput 1件分の実行記録
+type Job struct {
+	ID         uint64    `json:"id"`
+	UserID     string    `json:"user_id"`
+	ChannelID  string    `json:"channel_id"`
+	Text       string    `json:"text"`
+	Keyword    string    `json:"keyword"` // 最初にマッチしたコマンドのキーワード
+	Runner     string    `json:"runner"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Finished   bool      `json:"finished"`
+	ExitCode   int       `json:"exit_code"`
+	Output     string    `json:"output"` // 標準出力と標準エラー出力（maxJobOutput バイトまで）
+}
+
+// Duration returns how long the job ran (or has been running).
+func (j *Job) Duration() time.Duration {
+	if !j.Finished {
+		return time.Since(j.StartedAt)
+	}
+	return j.FinishedAt.Sub(j.StartedAt)
+}
+
+// JobStore persists job records.
+type JobStore interface {
+	// CreateJob stores a new job and assigns job.ID.
+	CreateJob(job *Job) error
+	// FinishJob updates the job with its result.
+	FinishJob(job *Job) error
+}
+
+// jobRecorder はexecuteCommandsの出力を中継しながらジョブの記録を取る
+type jobRecorder struct {
+	store JobStore
+	logf  func(format string, args ...interface{}) // 記録の失敗を知らせる（nilなら知らせない）
+	input *CommandInput
+	wq    chan *CommandOutput
+	out   chan *CommandOutput // executeCommands はここに書き込む
+	done  chan struct{}
+
+	job       *Job
+	output    strings.Builder
+	truncated bool
+}
+
+func newJobRecorder(
+	store JobStore,
+	logf func(format string, args ...interface{}),
+	input *CommandInput,
+	wq chan *CommandOutput,
+) *jobRecorder {
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	r := &jobRecorder{
+		store: store,
+		logf:  logf,
+		input: input,
+		wq:    wq,
+		out:   make(chan *CommandOutput),
+		done:  make(chan struct{}),
+	}
+	go r.forward()
+	return r
+}
+
+// start はコマンドがキーワードにマッチして実行を開始する時に呼ばれる。
+// Spawned通知より前に呼ぶ必要がある。
+func (r *jobRecorder) start(m *Matcher) {
+	job := &Job{
+		UserID:    r.input.SenderID,
+		ChannelID: r.input.ChannelID,
+		Text:      r.input.Text,
+		Keyword:   m.cfg.Keyword,
+		Runner:    m.cfg.Runner,
+		StartedAt: time.Now(),
+	}
+	if job.Runner == "" {
+		job.Runner = "exec"
+	}
+	if err := r.store.CreateJob(job); err != nil {
+		r.logf("failed to record job: %v", err)
+		return
+	}
+	r.job = job
+}
+
+func (r *jobRecorder) forward() {
+	defer close(r.done)
+	for out := range r.out {
+		if r.job != nil {
+			out.JobID = r.job.ID
+			r.capture(out.Text)
+		}
+		r.wq <- out
+	}
+}
+
+func (r *jobRecorder) capture(text string) {
+	if text == "" || r.truncated {
+		return
+	}
+	if r.output.Len()+len(text) > maxJobOutput {
+		n := maxJobOutput - r.output.Len()
+		for n > 0 && !utf8.RuneStart(text[n]) {
+			n--
+		}
+		text = text[:n]
+		r.truncated = true
+	}
+	r.output.WriteString(text)
+}
+
+// finish は全ての出力を中継し終えてからジョブの結果を記録する
+func (r *jobRecorder) finish(exitCode int) {
+	close(r.out)
+	<-r.done
+	if r.job == nil {
+		return
+	}
+	r.job.Finished = true
+	r.job.FinishedAt = time.Now()
+	r.job.ExitCode = exitCode
+	r.job.Output = r.output.String()
+	if err := r.store.FinishJob(r.job); err != nil {
+		r.logf("failed to record job result: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memJobStore はテスト用のメモリ上のJobStore
+type memJobStore struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+func (s *memJobStore) CreateJob(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.ID = uint64(len(s.jobs) + 1)
+	cp := *job
+	s.jobs = append(s.jobs, &cp)
+	return nil
+}
+
+func (s *memJobStore) FinishJob(job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *job
+	s.jobs[job.ID-1] = &cp
+	return nil
+}
+
+func runJobExecutor(t *testing.T, store JobStore, inputs ...*CommandInput) []*CommandOutput {
+	t.Helper()
+	cfgs := []*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "emit *", Command: "emit *"}, nil),
+		NewCommandConfig(&Definition{Keyword: "fail", Command: "fail"}, nil),
+	}
+	rq := make(chan *CommandInput, len(inputs))
+	wq := make(chan *CommandOutput, 20)
+	done := make(chan struct{})
+	go func() {
+		ExecutorWithRunner(context.Background(), rq, wq, cfgs, func(*CommandConfig) CommandRunner {
+			return &pipeTestRunner{}
+		}, WithJobStore(store, t.Logf))
+		close(done)
+	}()
+	for _, in := range inputs {
+		rq <- in
+	}
+	close(rq)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("executor did not finish")
+	}
+	return drainOutputs(wq)
+}
+
+func TestExecutorRecordsJob(t *testing.T) {
+	store := &memJobStore{}
+	outputs := runJobExecutor(t, store, &CommandInput{Text: "emit foo", SenderID: "U1", ChannelID: "C1"})
+
+	if len(store.jobs) != 1 {
+		t.Fatalf("expected 1 job, got %d", len(store.jobs))
+	}
+	job := store.jobs[0]
+	if !job.Finished || job.ExitCode != 0 {
+		t.Fatalf("unexpected job state: %+v", job)
+	}
+	if job.UserID != "U1" || job.ChannelID != "C1" || job.Keyword != "emit *" || job.Text != "emit foo" {
+		t.Fatalf("unexpected job fields: %+v", job)
+	}
+	if job.Output != "foo 0\nfoo 1\nfoo 2\n" {
+		t.Fatalf("unexpected job output: %q", job.Output)
+	}
+	for _, out := range outputs {
+		if out.JobID != job.ID {
+			t.Fatalf("expected JobID %d on all outputs, got %+v", job.ID, out)
+		}
+	}
+}
+
+func TestExecutorRecordsFailedJob(t *testing.T) {
+	store := &memJobStore{}
+	runJobExecutor(t, store, &CommandInput{Text: "fail"})
+	if len(store.jobs) != 1 || store.jobs[0].ExitCode != 3 || store.jobs[0].Output != "failed" {
+		t.Fatalf("unexpected jobs: %+v", store.jobs)
+	}
+}
+
+func TestExecutorDoesNotRecordUnmatchedInput(t *testing.T) {
+	store := &memJobStore{}
+	outputs := runJobExecutor(t, store, &CommandInput{Text: "hello world"})
+	if len(store.jobs) != 0 {
+		t.Fatalf("expected no jobs, got %+v", store.jobs)
+	}
+	if len(outputs) != 0 {
+		t.Fatalf("expected no outputs, got %+v", outputs)
+	}
+}
+
+// failingJobStore は記録に失敗するJobStore
+type failingJobStore struct{}
+
+func (failingJobStore) CreateJob(*Job) error { return errors.New("disk full") }
+func (failingJobStore) FinishJob(*Job) error { return errors.New("disk full") }
+
+func TestJobRecorderReportsStoreErrors(t *testing.T) {
+	var logs []string
+	logf := func(format string, args ...interface{}) { logs = append(logs, fmt.Sprintf(format, args...)) }
+	wq := make(chan *CommandOutput, 1)
+	rec := newJobRecorder(failingJobStore{}, logf, &CommandInput{Text: "date"}, wq)
+	rec.start(&Matcher{cfg: NewCommandConfig(&Definition{Keyword: "date"}, nil)})
+	rec.out <- &CommandOutput{Text: "ok"}
+	rec.finish(0)
+
+	// 記録に失敗しても出力は中継する
+	if out := <-wq; out.Text != "ok" || out.JobID != 0 {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+	if len(logs) != 1 || logs[0] != "failed to record job: disk full" {
+		t.Fatalf("unexpected logs: %q", logs)
+	}
+}
@@ -51,8 +51,12 @@ func (m *Matcher) build(keywords []string) []string {
 		return nil
 	}
 	runner := strings.ToLower(strings.TrimSpace(m.cfg.Runner))
-	if runner == "http" {
-		return buildHTTPArgs(hasWildcard, wildcard)
+	switch runner {
+	case "http", "grpc":
+		return buildRequestArgs(runner, hasWildcard, wildcard)
+	case "script":
+		// スクリプトには * にマッチしたトークンをそのまま args として渡す
+		return append([]string{runner}, wildcard...)
 	}
 	return buildCommandArgs(m.cfg.Command, hasWildcard, wildcard)
 }
@@ -95,11 +99,13 @@ func matchKeywords(template []string, keywords []string, hasWildcard bool) ([]st
 	return wildcard, true
 }
 
-func buildHTTPArgs(hasWildcard bool, wildcard []string) []string {
+// buildRequestArgs は command を持たないランナー向けに、
+// ランナー名と * にマッチした文字列だけを返す
+func buildRequestArgs(runner string, hasWildcard bool, wildcard []string) []string {
 	if hasWildcard {
-		return []string{"http", strings.Join(wildcard, " ")}
+		return []string{runner, strings.Join(wildcard, " ")}
 	}
-	return []string{"http"}
+	return []string{runner}
 }
 
 func buildCommandArgs(line string, hasWildcard bool, wildcard []string) []string {
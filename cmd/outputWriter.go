@@ -3,6 +3,7 @@ package cmd
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"sync"
@@ -17,21 +18,32 @@ type OutputWriter struct {
 	mu    sync.Mutex
 }
 
-func newStdWriter(ch chan *CommandOutput, replyInfo interface{}, cfg interface{}) *OutputWriter {
-	return newOutputWriter(ch, replyInfo, cfg, false)
+func newStdWriter(
+	ctx context.Context,
+	ch chan *CommandOutput,
+	replyInfo interface{},
+	cfg interface{},
+) *OutputWriter {
+	return newOutputWriter(ctx, ch, replyInfo, cfg, false)
 }
 
-func newErrWriter(ch chan *CommandOutput, replyInfo interface{}, cfg interface{}) *OutputWriter {
-	return newOutputWriter(ch, replyInfo, cfg, true)
+func newErrWriter(
+	ctx context.Context,
+	ch chan *CommandOutput,
+	replyInfo interface{},
+	cfg interface{},
+) *OutputWriter {
+	return newOutputWriter(ctx, ch, replyInfo, cfg, true)
 }
 
 func newOutputWriter(
+	ctx context.Context,
 	ch chan *CommandOutput,
 	replyInfo interface{},
 	cfg interface{},
 	isErrOut bool,
 ) *OutputWriter {
-	raw := newRawWriter(ch, replyInfo, cfg, isErrOut)
+	raw := newRawWriter(ctx, ch, replyInfo, cfg, isErrOut)
 	return &OutputWriter{
 		bufw: bufio.NewWriterSize(raw, 2048),
 		raw:  raw,
@@ -74,6 +86,7 @@ func (w *OutputWriter) flushLocked() {
 }
 
 type rawWriter struct {
+	Ctx         context.Context // トレースの伝搬用
 	Ch          chan *CommandOutput
 	ReplyInfo   interface{}
 	ReplyConfig interface{}
@@ -82,12 +95,14 @@ type rawWriter struct {
 }
 
 func newRawWriter(
+	ctx context.Context,
 	ch chan *CommandOutput,
 	replyInfo interface{},
 	cfg interface{},
 	isErrOut bool,
 ) *rawWriter {
 	return &rawWriter{
+		Ctx:         ctx,
 		Ch:          ch,
 		ReplyInfo:   replyInfo,
 		ReplyConfig: cfg,
@@ -104,6 +119,7 @@ func (w *rawWriter) emitText(text []byte) {
 		ReplyConfig: w.ReplyConfig,
 		Text:        string(text),
 		IsErrOut:    w.IsErrOut,
+		Context:     w.Ctx,
 	}
 }
 
@@ -118,6 +134,7 @@ func (w *rawWriter) emitImage(sixelData []byte) {
 		ReplyConfig: w.ReplyConfig,
 		ImageData:   pngBytes,
 		IsErrOut:    w.IsErrOut,
+		Context:     w.Ctx,
 	}
 }
 
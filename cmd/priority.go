@@ -0,0 +1,144 @@
+package cmd
+
+import "time"
+
+// defaultAgingInterval は待っている入力の優先度を1上げる間隔
+const defaultAgingInterval = time.Minute
+
+// maxServedSenders は送信者ごとの実行順を覚えておく人数。超えたら待っている入力のない送信者を忘れる。
+const maxServedSenders = 1024
+
+// priorityQueue は Scheduler の待機中の入力を、次の順で並べる。
+//
+//  1. 優先度の高い順。優先度はコマンド定義の priority に、待った時間 agingInterval ごとに1を足したもの
+//  2. 最後に実行が始まったのが古い送信者の順（同じ優先度なら、全員に1回ずつ順番が回ってから2回目を実行する）
+//  3. 受け付けた順
+type priorityQueue struct {
+	items         []*scheduledInput
+	agingInterval time.Duration
+	now           func() time.Time
+	seq           uint64            // 受け付けた入力の通し番号
+	served        uint64            // 実行を始めた入力の通し番号
+	lastServed    map[string]uint64 // 送信者ごとに最後に実行を始めた入力の served
+}
+
+func newPriorityQueue(now func() time.Time) *priorityQueue {
+	return &priorityQueue{
+		agingInterval: defaultAgingInterval,
+		now:           now,
+		lastServed:    map[string]uint64{},
+	}
+}
+
+func (q *priorityQueue) len() int { return len(q.items) }
+
+// push は入力を受け付けた順番と時刻を記録して追加する
+func (q *priorityQueue) push(item *scheduledInput) {
+	q.seq++
+	item.seq = q.seq
+	item.enqueuedAt = q.now()
+	item.priority = 0
+	for i, m := range item.uses {
+		if i == 0 || m.cfg.Priority > item.priority {
+			item.priority = m.cfg.Priority
+		}
+	}
+	q.items = append(q.items, item)
+}
+
+// pop は runnable を満たす入力のうち、一番先に実行すべきものを取り出す。なければnilを返す。
+func (q *priorityQueue) pop(runnable func(*scheduledInput) bool) *scheduledInput {
+	now := q.now()
+	best := -1
+	for i, item := range q.items {
+		if !runnable(item) {
+			continue
+		}
+		if best < 0 || q.less(item, q.items[best], now) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil
+	}
+	item := q.items[best]
+	q.items = append(q.items[:best], q.items[best+1:]...)
+	q.served++
+	q.lastServed[item.input.SenderID] = q.served
+	q.forgetIdleSenders()
+	return item
+}
+
+// remove は match を満たす入力を全て取り除いて返す
+func (q *priorityQueue) remove(match func(*scheduledInput) bool) []*scheduledInput {
+	var removed []*scheduledInput
+	kept := q.items[:0]
+	for _, item := range q.items {
+		if match(item) {
+			removed = append(removed, item)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	clear(q.items[len(kept):])
+	q.items = kept
+	return removed
+}
+
+// position は item が何番目に実行される予定か（1始まり）を返す
+func (q *priorityQueue) position(item *scheduledInput) int {
+	now := q.now()
+	position := 1
+	for _, p := range q.items {
+		if p != item && q.less(p, item, now) {
+			position++
+		}
+	}
+	return position
+}
+
+// ahead は item より先に実行される予定の入力を返す
+func (q *priorityQueue) ahead(item *scheduledInput) []*scheduledInput {
+	now := q.now()
+	var ahead []*scheduledInput
+	for _, p := range q.items {
+		if p != item && q.less(p, item, now) {
+			ahead = append(ahead, p)
+		}
+	}
+	return ahead
+}
+
+// less は a を b より先に実行すべきかを返す
+func (q *priorityQueue) less(a, b *scheduledInput, now time.Time) bool {
+	if pa, pb := q.effectivePriority(a, now), q.effectivePriority(b, now); pa != pb {
+		return pa > pb
+	}
+	if sa, sb := q.lastServed[a.input.SenderID], q.lastServed[b.input.SenderID]; sa != sb {
+		return sa < sb
+	}
+	return a.seq < b.seq
+}
+
+func (q *priorityQueue) effectivePriority(item *scheduledInput, now time.Time) int {
+	if q.agingInterval <= 0 {
+		return item.priority
+	}
+	return item.priority + int(now.Sub(item.enqueuedAt)/q.agingInterval)
+}
+
+// forgetIdleSenders は覚えている送信者が多すぎる場合に、待っている入力のない送信者を忘れる
+func (q *priorityQueue) forgetIdleSenders() {
+	if len(q.lastServed) <= maxServedSenders {
+		return
+	}
+	waiting := map[string]bool{}
+	for _, item := range q.items {
+		waiting[item.input.SenderID] = true
+	}
+	for sender := range q.lastServed {
+		if !waiting[sender] {
+			delete(q.lastServed, sender)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock はテストで時刻を進めるための時計
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newQueueItem(sender, text string, priority int) *scheduledInput {
+	m := &Matcher{cfg: NewCommandConfig(&Definition{Keyword: text, Priority: priority}, nil)}
+	return &scheduledInput{
+		input: &CommandInput{SenderID: sender, Text: text},
+		uses:  []*Matcher{m},
+	}
+}
+
+func anyRunnable(*scheduledInput) bool { return true }
+
+// popAll は全ての入力を取り出し、"送信者:テキスト" を実行順に返す
+func popAll(q *priorityQueue) string {
+	var order []string
+	for q.len() > 0 {
+		item := q.pop(anyRunnable)
+		order = append(order, item.input.SenderID+":"+item.input.Text)
+	}
+	return strings.Join(order, ",")
+}
+
+func TestPriorityQueueOrdersByPriority(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	q := newPriorityQueue(clock.now)
+	q.push(newQueueItem("U1", "low", -1))
+	q.push(newQueueItem("U1", "normal", 0))
+	high := newQueueItem("U1", "high", 10)
+	q.push(high)
+
+	if p := q.position(high); p != 1 {
+		t.Fatalf("unexpected position of high: %d", p)
+	}
+	if got, want := popAll(q), "U1:high,U1:normal,U1:low"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestPriorityQueueRoundRobinAcrossSenders(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	q := newPriorityQueue(clock.now)
+	for _, text := range []string{"a1", "a2", "a3"} {
+		q.push(newQueueItem("U1", text, 0))
+	}
+	q.push(newQueueItem("U2", "b1", 0))
+	q.push(newQueueItem("U3", "c1", 0))
+	q.push(newQueueItem("U2", "b2", 0))
+
+	// 全員に1回ずつ順番が回ってから2回目を実行する
+	if got, want := popAll(q), "U1:a1,U2:b1,U3:c1,U1:a2,U2:b2,U1:a3"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestPriorityQueueAging(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	q := newPriorityQueue(clock.now)
+	q.agingInterval = time.Minute
+	q.push(newQueueItem("U1", "batch", 0))
+	clock.advance(90 * time.Second)
+	q.push(newQueueItem("U2", "urgent", 2))
+
+	// batch は1分半待ったので優先度が1上がるが、まだ urgent の方が高い
+	if item := q.pop(anyRunnable); item.input.Text != "urgent" {
+		t.Fatalf("expected urgent first, got %s", item.input.Text)
+	}
+	q.push(newQueueItem("U2", "urgent", 2))
+	clock.advance(90 * time.Second)
+	// batch は3分待って優先度が3、新しい urgent は1分半待って3で並び、
+	// U2 は直前に実行したので batch が先になる
+	if got, want := popAll(q), "U1:batch,U2:urgent"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
+
+func TestPriorityQueueSkipsBlockedInputs(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	q := newPriorityQueue(clock.now)
+	q.push(newQueueItem("U1", "deploy", 5))
+	q.push(newQueueItem("U2", "date", 0))
+
+	item := q.pop(func(item *scheduledInput) bool { return item.input.Text != "deploy" })
+	if item == nil || item.input.Text != "date" {
+		t.Fatalf("expected date, got %+v", item)
+	}
+	if item := q.pop(func(*scheduledInput) bool { return false }); item != nil {
+		t.Fatalf("expected nil, got %s", item.input.Text)
+	}
+	if q.len() != 1 {
+		t.Fatalf("blocked input must stay queued")
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -18,6 +19,18 @@ type Cmd interface {
 	Run(timeout int) int
 }
 
+// InputSetter is implemented by Cmds that need the original CommandInput
+// (e.g. files attached to the message).
+type InputSetter interface {
+	SetInput(input *CommandInput)
+}
+
+// BlockPoster is implemented by Cmds that can post Block Kit messages
+// in addition to plain text output.
+type BlockPoster interface {
+	SetBlockPoster(post func(blocks json.RawMessage))
+}
+
 // CommandRunner creates Cmd instances for a given command.
 type CommandRunner interface {
 	CommandContext(ctx context.Context, name string, arg ...string) Cmd
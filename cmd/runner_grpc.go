@@ -0,0 +1,333 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+type grpcRunner struct {
+	cfg *CommandConfig
+
+	once    sync.Once
+	files   *protoregistry.Files // descriptor_set 指定時のみ
+	loadErr error
+}
+
+// NewGRPCRunner returns a runner that invokes a unary gRPC method.
+// Method descriptors are resolved from descriptor_set if configured,
+// otherwise via server reflection.
+func NewGRPCRunner(cfg *CommandConfig) CommandRunner {
+	return &grpcRunner{cfg: cfg}
+}
+
+func (r *grpcRunner) load() {
+	path := strings.TrimSpace(r.cfg.DescriptorSet)
+	if path == "" {
+		return
+	}
+	r.files, r.loadErr = loadDescriptorSet(path)
+}
+
+func (r *grpcRunner) CommandContext(ctx context.Context, _ string, arg ...string) Cmd {
+	if ctx == nil {
+		panic("nil Context")
+	}
+	r.once.Do(r.load)
+	wildcard := ""
+	if len(arg) > 0 {
+		wildcard = arg[0]
+	}
+	return &grpcCmd{
+		ctx:      ctx,
+		cfg:      r.cfg,
+		files:    r.files,
+		loadErr:  r.loadErr,
+		wildcard: wildcard,
+	}
+}
+
+type grpcCmd struct {
+	ctx      context.Context
+	cfg      *CommandConfig
+	files    *protoregistry.Files
+	loadErr  error
+	wildcard string
+	stdin    io.Reader
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+func (c *grpcCmd) SetStdin(r io.Reader) {
+	c.stdin = r
+}
+
+func (c *grpcCmd) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *grpcCmd) SetStderr(w io.Writer) {
+	c.stderr = w
+}
+
+// Run invokes the configured method and returns its exit code.
+// - 0: OK
+// - 1-16: gRPC status code
+// - 127: configuration or descriptor error
+// - 143: cancelled or timed out
+func (c *grpcCmd) Run(timeout int) int {
+	if c.loadErr != nil {
+		c.writeErr(c.loadErr)
+		return 127
+	}
+	service, method, err := splitGRPCMethod(c.cfg.Method)
+	if err != nil {
+		c.writeErr(err)
+		return 127
+	}
+
+	conn, err := grpc.NewClient(c.cfg.Target, grpc.WithTransportCredentials(c.credentials()))
+	if err != nil {
+		c.writeErr(err)
+		return 127
+	}
+	defer func() { _ = conn.Close() }()
+
+	md, err := c.findMethod(conn, service, method)
+	if err != nil {
+		return c.handleError(err, timeout)
+	}
+	req, err := c.buildRequest(md.Input())
+	if err != nil {
+		c.writeErr(err)
+		return 127
+	}
+	resp := dynamicpb.NewMessage(md.Output())
+	fullMethod := "/" + service + "/" + method
+	if err := conn.Invoke(c.ctx, fullMethod, req, resp); err != nil {
+		return c.handleError(err, timeout)
+	}
+	if err := c.writeResponse(resp); err != nil {
+		c.writeErr(err)
+		return 127
+	}
+	return 0
+}
+
+func (c *grpcCmd) credentials() credentials.TransportCredentials {
+	if c.cfg.TLS {
+		return credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	return insecure.NewCredentials()
+}
+
+// splitGRPCMethod は "pkg.Service/Method" または "pkg.Service.Method" を分解する
+func splitGRPCMethod(fullName string) (string, string, error) {
+	name := strings.TrimPrefix(strings.TrimSpace(fullName), "/")
+	sep := "."
+	if strings.Contains(name, "/") {
+		sep = "/"
+	}
+	if i := strings.LastIndex(name, sep); i > 0 && i < len(name)-1 {
+		return name[:i], name[i+1:], nil
+	}
+	return "", "", fmt.Errorf("invalid grpc method '%s' (expected 'package.Service/Method')", fullName)
+}
+
+func (c *grpcCmd) findMethod(
+	conn *grpc.ClientConn,
+	service, method string,
+) (protoreflect.MethodDescriptor, error) {
+	files := c.files
+	if files == nil {
+		var err error
+		files, err = resolveByReflection(c.ctx, conn, service)
+		if err != nil {
+			return nil, err
+		}
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil, fmt.Errorf("service '%s' not found: %w", service, err)
+	}
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a service", service)
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return nil, fmt.Errorf("method '%s' not found in service '%s'", method, service)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("streaming method '%s' is not supported", method)
+	}
+	return md, nil
+}
+
+func (c *grpcCmd) buildRequest(desc protoreflect.MessageDescriptor) (proto.Message, error) {
+	stdin := ""
+	if c.stdin != nil {
+		data, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return nil, err
+		}
+		stdin = string(data)
+	}
+	// 発言者が書いた引数はテンプレートとして解釈させず、データ（.Args）としてだけ渡す
+	body, err := renderTemplate("body", c.cfg.Body, bodyTemplateData{Args: c.wildcard, Stdin: stdin})
+	if err != nil {
+		return nil, err
+	}
+	req := dynamicpb.NewMessage(desc)
+	if strings.TrimSpace(body) == "" {
+		return req, nil
+	}
+	if err := protojson.Unmarshal([]byte(body), req); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	return req, nil
+}
+
+func (c *grpcCmd) writeResponse(resp proto.Message) error {
+	data, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	out := string(data)
+	if tmpl := c.cfg.OutputTemplate; tmpl != "" {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		out, err = renderTemplate("output_template", tmpl, v)
+		if err != nil {
+			return err
+		}
+	}
+	if c.stdout != nil && out != "" {
+		_, _ = io.WriteString(c.stdout, out)
+	}
+	return nil
+}
+
+func (c *grpcCmd) handleError(err error, timeout int) int {
+	if c.ctx != nil {
+		if errors.Is(c.ctx.Err(), context.Canceled) {
+			return 143
+		}
+		if errors.Is(c.ctx.Err(), context.DeadlineExceeded) {
+			if timeout > 0 && c.stderr != nil {
+				_, _ = fmt.Fprintf(c.stderr, "Timeout exceeded (%ds)", timeout)
+			}
+			return 143
+		}
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		c.writeErr(err)
+		return 127
+	}
+	if c.stderr != nil {
+		_, _ = fmt.Fprintf(c.stderr, "%s: %s", st.Code(), st.Message())
+	}
+	return int(st.Code())
+}
+
+func (c *grpcCmd) writeErr(err error) {
+	if c.stderr != nil {
+		_, _ = fmt.Fprintf(c.stderr, "Error: %v", err)
+	}
+}
+
+func loadDescriptorSet(path string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- 設定ファイルで指定されたパス
+	if err != nil {
+		return nil, err
+	}
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &fds); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s: %w", path, err)
+	}
+	return protodesc.NewFiles(&fds)
+}
+
+// resolveByReflection はサーバーリフレクションでserviceを含むファイルと
+// その依存ファイルを取得する
+func resolveByReflection(
+	ctx context.Context,
+	conn *grpc.ClientConn,
+	service string,
+) (*protoregistry.Files, error) {
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = stream.CloseSend() }()
+
+	fetched := map[string]*descriptorpb.FileDescriptorProto{}
+	pending := []*rpb.ServerReflectionRequest{{
+		MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{
+			FileContainingSymbol: service,
+		},
+	}}
+	for len(pending) > 0 {
+		req := pending[0]
+		pending = pending[1:]
+		if err := stream.Send(req); err != nil {
+			return nil, err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return nil, err
+		}
+		if errResp := resp.GetErrorResponse(); errResp != nil {
+			return nil, status.Error(codes.Code(errResp.GetErrorCode()), errResp.GetErrorMessage())
+		}
+		for _, raw := range resp.GetFileDescriptorResponse().GetFileDescriptorProto() {
+			var fd descriptorpb.FileDescriptorProto
+			if err := proto.Unmarshal(raw, &fd); err != nil {
+				return nil, err
+			}
+			if _, ok := fetched[fd.GetName()]; ok {
+				continue
+			}
+			fetched[fd.GetName()] = &fd
+			for _, dep := range fd.GetDependency() {
+				if _, ok := fetched[dep]; ok {
+					continue
+				}
+				pending = append(pending, &rpb.ServerReflectionRequest{
+					MessageRequest: &rpb.ServerReflectionRequest_FileByFilename{
+						FileByFilename: dep,
+					},
+				})
+			}
+		}
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range fetched {
+		fds.File = append(fds.File, fd)
+	}
+	return protodesc.NewFiles(fds)
+}
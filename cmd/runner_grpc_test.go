@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+func startHealthServer(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("foo", healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	reflection.Register(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func runGRPCCommand(t *testing.T, def *Definition, wildcard string) (int, string, string) {
+	t.Helper()
+	def.Runner = "grpc"
+	runner := NewGRPCRunner(NewCommandConfig(def, nil))
+	cmd := runner.CommandContext(context.Background(), "grpc", wildcard)
+	var stdout, stderr bytes.Buffer
+	cmd.SetStdout(&stdout)
+	cmd.SetStderr(&stderr)
+	exitCode := cmd.Run(0)
+	return exitCode, stdout.String(), stderr.String()
+}
+
+func TestGRPCRunnerInvokesMethodViaReflection(t *testing.T) {
+	target := startHealthServer(t)
+	exitCode, stdout, stderr := runGRPCCommand(t, &Definition{
+		Target: target,
+		Method: "grpc.health.v1.Health/Check",
+		Body:   `{"service":{{json .Args}}}`,
+	}, "foo")
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr=%q)", exitCode, stderr)
+	}
+	if !strings.Contains(stdout, `"SERVING"`) {
+		t.Fatalf("unexpected stdout: %q", stdout)
+	}
+}
+
+func TestGRPCRunnerOutputTemplate(t *testing.T) {
+	target := startHealthServer(t)
+	exitCode, stdout, stderr := runGRPCCommand(t, &Definition{
+		Target:         target,
+		Method:         "grpc.health.v1.Health.Check",
+		Body:           `{"service":"foo"}`,
+		OutputTemplate: "status={{.status}}",
+	}, "")
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr=%q)", exitCode, stderr)
+	}
+	if stdout != "status=SERVING" {
+		t.Fatalf("unexpected stdout: %q", stdout)
+	}
+}
+
+func TestGRPCRunnerMapsStatusCodeToExitCode(t *testing.T) {
+	target := startHealthServer(t)
+	exitCode, _, stderr := runGRPCCommand(t, &Definition{
+		Target: target,
+		Method: "grpc.health.v1.Health/Check",
+		Body:   `{"service":{{json .Args}}}`,
+	}, "unknown")
+	if exitCode != 5 {
+		t.Fatalf("expected exit code 5 (NotFound), got %d", exitCode)
+	}
+	if !strings.Contains(stderr, "NotFound") {
+		t.Fatalf("expected NotFound in stderr, got %q", stderr)
+	}
+}
+
+func TestGRPCRunnerPassesArgsAsData(t *testing.T) {
+	c := &grpcCmd{
+		cfg:      NewCommandConfig(&Definition{Body: `{"service":{{json .Args}}}`}, nil),
+		wildcard: `a", "extra":"{{.Stdin}}`,
+		stdin:    strings.NewReader("secret"),
+	}
+	req, err := c.buildRequest((&healthpb.HealthCheckRequest{}).ProtoReflect().Descriptor())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := req.ProtoReflect().Get(req.ProtoReflect().Descriptor().Fields().ByName("service")).String()
+	if got != c.wildcard {
+		t.Fatalf("arguments must be sent literally, got %q", got)
+	}
+}
+
+func TestSplitGRPCMethod(t *testing.T) {
+	tests := []struct {
+		in      string
+		service string
+		method  string
+		wantErr bool
+	}{
+		{in: "pkg.Service/Method", service: "pkg.Service", method: "Method"},
+		{in: "/pkg.Service/Method", service: "pkg.Service", method: "Method"},
+		{in: "pkg.Service.Method", service: "pkg.Service", method: "Method"},
+		{in: "Method", wantErr: true},
+		{in: "pkg.Service/", wantErr: true},
+	}
+	for _, tc := range tests {
+		service, method, err := splitGRPCMethod(tc.in)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("splitGRPCMethod(%q): expected error", tc.in)
+			}
+			continue
+		}
+		if err != nil || service != tc.service || method != tc.method {
+			t.Errorf("splitGRPCMethod(%q) = %q, %q, %v", tc.in, service, method, err)
+		}
+	}
+}
@@ -1,12 +1,25 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// body_from に指定できる値
+const (
+	bodyFromStdin     = "stdin"
+	bodyFromTemplate  = "template"
+	bodyFromMultipart = "multipart"
 )
 
 type httpRunner struct {
@@ -44,6 +57,13 @@ type httpCmd struct {
 	stdin       io.Reader
 	stdout      io.Writer
 	stderr      io.Writer
+	files       []*InputFile
+}
+
+// bodyTemplateData は body_from = "template" の時にテンプレートに渡す値
+type bodyTemplateData struct {
+	Args  string // キーワードの * にマッチした文字列
+	Stdin string // メッセージの2行目以降
 }
 
 func (c *httpCmd) SetStdin(r io.Reader) {
@@ -58,6 +78,12 @@ func (c *httpCmd) SetStderr(w io.Writer) {
 	c.stderr = w
 }
 
+func (c *httpCmd) SetInput(input *CommandInput) {
+	if input != nil {
+		c.files = input.Files
+	}
+}
+
 func (c *httpCmd) Run(timeout int) int {
 	if err := c.validateConfig(); err != nil {
 		c.writeErr(err)
@@ -95,11 +121,10 @@ func (c *httpCmd) buildRequest() (*http.Request, error) {
 		method = "POST"
 	}
 	urlStr := c.expandWildcard(c.cfg.URL)
-	body := c.expandWildcard(c.cfg.Body)
 
-	var bodyReader io.Reader
-	if body != "" {
-		bodyReader = strings.NewReader(body)
+	bodyReader, contentType, err := c.buildBody()
+	if err != nil {
+		return nil, err
 	}
 
 	req, err := http.NewRequestWithContext(c.ctx, method, urlStr, bodyReader)
@@ -113,10 +138,125 @@ func (c *httpCmd) buildRequest() (*http.Request, error) {
 		}
 		req.Header.Set(k, c.expandWildcard(v))
 	}
+	if contentType != "" {
+		// multipartのboundaryは設定値で上書きさせない
+		req.Header.Set("Content-Type", contentType)
+	}
+	// トレースを呼び出し先に伝搬する（traceparent ヘッダ）
+	otel.GetTextMapPropagator().Inject(c.ctx, propagation.HeaderCarrier(req.Header))
 
 	return req, nil
 }
 
+// buildBody は body_from の設定に従ってリクエストボディを組み立てる。
+// Content-Typeを強制したい場合は2番目の戻り値で返す。
+func (c *httpCmd) buildBody() (io.Reader, string, error) {
+	var body string
+	switch strings.ToLower(strings.TrimSpace(c.cfg.BodyFrom)) {
+	case "":
+		// 後方互換のためそのまま置換する（エスケープしないのでJSONのボディには template を使う）
+		body = c.expandWildcard(c.cfg.Body)
+	case bodyFromStdin:
+		stdin, err := c.readStdin()
+		if err != nil {
+			return nil, "", err
+		}
+		body = stdin
+	case bodyFromTemplate:
+		stdin, err := c.readStdin()
+		if err != nil {
+			return nil, "", err
+		}
+		// 発言者が書いた引数はテンプレートとして解釈させず、データ（.Args）としてだけ渡す
+		body, err = renderTemplate("body", c.cfg.Body, bodyTemplateData{
+			Args:  c.wildcard,
+			Stdin: stdin,
+		})
+		if err != nil {
+			return nil, "", err
+		}
+	case bodyFromMultipart:
+		return c.buildMultipartBody()
+	default:
+		return nil, "", fmt.Errorf("unknown body_from '%s'", c.cfg.BodyFrom)
+	}
+	if body == "" {
+		return nil, "", nil
+	}
+	return strings.NewReader(body), "", nil
+}
+
+// buildMultipartBody は標準入力と添付ファイルをmultipart/form-dataのファイルパートとして送る
+func (c *httpCmd) buildMultipartBody() (io.Reader, string, error) {
+	field := strings.TrimSpace(c.cfg.MultipartField)
+	if field == "" {
+		field = "file"
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	stdin, err := c.readStdin()
+	if err != nil {
+		return nil, "", err
+	}
+	if stdin != "" {
+		if err := writeFilePart(mw, field, "stdin.txt", "text/plain; charset=utf-8",
+			strings.NewReader(stdin)); err != nil {
+			return nil, "", err
+		}
+	}
+	for _, f := range c.files {
+		if err := c.writeInputFile(mw, field, f); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return &buf, mw.FormDataContentType(), nil
+}
+
+func (c *httpCmd) writeInputFile(mw *multipart.Writer, field string, f *InputFile) error {
+	if f == nil || f.Open == nil {
+		return nil
+	}
+	rc, err := f.Open(c.ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", f.Name, err)
+	}
+	defer func() { _ = rc.Close() }()
+	return writeFilePart(mw, field, f.Name, f.MimeType, rc)
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func writeFilePart(mw *multipart.Writer, field, filename, contentType string, r io.Reader) error {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		quoteEscaper.Replace(field), quoteEscaper.Replace(filename)))
+	h.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, r)
+	return err
+}
+
+func (c *httpCmd) readStdin() (string, error) {
+	if c.stdin == nil {
+		return "", nil
+	}
+	data, err := io.ReadAll(c.stdin)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 func (c *httpCmd) expandWildcard(value string) string {
 	if !c.hasWildcard {
 		return value
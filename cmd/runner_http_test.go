@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -75,3 +78,131 @@ func TestHTTPRunnerPostWithWildcard(t *testing.T) {
 		t.Fatalf("unexpected body: %q", result.body)
 	}
 }
+
+func runHTTPCommand(
+	t *testing.T,
+	def *Definition,
+	wildcard string,
+	stdin string,
+	input *CommandInput,
+) (*http.Request, []byte) {
+	t.Helper()
+	type requestResult struct {
+		req  *http.Request
+		body []byte
+	}
+	resultCh := make(chan requestResult, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bodyBytes, _ := io.ReadAll(r.Body)
+		resultCh <- requestResult{req: r, body: bodyBytes}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	def.Runner = "http"
+	def.URL = srv.URL + "/hook"
+	runner := NewHTTPRunner(NewCommandConfig(def, nil))
+	cmd := runner.CommandContext(context.Background(), "http", wildcard)
+	var stderr bytes.Buffer
+	cmd.SetStdin(strings.NewReader(stdin))
+	cmd.SetStderr(&stderr)
+	if s, ok := cmd.(InputSetter); ok && input != nil {
+		s.SetInput(input)
+	}
+	if exitCode := cmd.Run(0); exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr=%q)", exitCode, stderr.String())
+	}
+	result := <-resultCh
+	return result.req, result.body
+}
+
+func TestHTTPRunnerBodyFromStdin(t *testing.T) {
+	_, body := runHTTPCommand(t, &Definition{
+		BodyFrom: "stdin",
+		Body:     "ignored",
+	}, "", "line1\nline2", nil)
+	if string(body) != "line1\nline2" {
+		t.Fatalf("unexpected body: %q", string(body))
+	}
+}
+
+func TestHTTPRunnerBodyFromTemplate(t *testing.T) {
+	_, body := runHTTPCommand(t, &Definition{
+		BodyFrom: "template",
+		Body:     `{"title":{{json .Args}},"text":{{json .Stdin}}}`,
+	}, "hello", "say \"hi\"\nbye", nil)
+	want := `{"title":"hello","text":"say \"hi\"\nbye"}`
+	if string(body) != want {
+		t.Fatalf("unexpected body: %q, want %q", string(body), want)
+	}
+
+	// 引数に書かれたテンプレートの構文は展開せずにそのまま送る
+	_, body = runHTTPCommand(t, &Definition{
+		BodyFrom: "template",
+		Body:     `{"title":{{json .Args}}}`,
+	}, `{{.Stdin}}"`, "secret", nil)
+	want = `{"title":"{{.Stdin}}\""}`
+	if string(body) != want {
+		t.Fatalf("unexpected body: %q, want %q", string(body), want)
+	}
+}
+
+func TestHTTPRunnerBodyFromMultipart(t *testing.T) {
+	input := &CommandInput{
+		Files: []*InputFile{
+			{
+				Name:     "report.csv",
+				MimeType: "text/csv",
+				Open: func(context.Context) (io.ReadCloser, error) {
+					return io.NopCloser(strings.NewReader("a,b\n1,2\n")), nil
+				},
+			},
+		},
+	}
+	req, body := runHTTPCommand(t, &Definition{
+		BodyFrom:       "multipart",
+		MultipartField: "upload",
+		Headers:        map[string]string{"Content-Type": "application/json"},
+	}, "", "memo", input)
+
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("failed to parse Content-Type: %v", err)
+	}
+	if mediaType != "multipart/form-data" {
+		t.Fatalf("expected multipart/form-data, got %q", mediaType)
+	}
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	type part struct {
+		field, filename, contentType, data string
+	}
+	var parts []part
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed to read part: %v", err)
+		}
+		data, _ := io.ReadAll(p)
+		parts = append(parts, part{
+			field:       p.FormName(),
+			filename:    p.FileName(),
+			contentType: p.Header.Get("Content-Type"),
+			data:        string(data),
+		})
+	}
+	want := []part{
+		{field: "upload", filename: "stdin.txt", contentType: "text/plain; charset=utf-8", data: "memo"},
+		{field: "upload", filename: "report.csv", contentType: "text/csv", data: "a,b\n1,2\n"},
+	}
+	if len(parts) != len(want) {
+		t.Fatalf("expected %d parts, got %+v", len(want), parts)
+	}
+	for i := range want {
+		if parts[i] != want[i] {
+			t.Fatalf("part %d = %+v, want %+v", i, parts[i], want[i])
+		}
+	}
+}
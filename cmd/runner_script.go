@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// defaultScriptMaxSteps は max_steps 未指定時のStarlarkの実行ステップ上限
+const defaultScriptMaxSteps = 10_000_000
+
+// scriptHTTPBodyLimit は http.get で読み込むレスポンスボディの上限
+const scriptHTTPBodyLimit = 1 << 20
+
+type scriptRunner struct {
+	cfg *CommandConfig
+}
+
+// NewScriptRunner returns a runner that evaluates a Starlark script in-process.
+func NewScriptRunner(cfg *CommandConfig) CommandRunner {
+	return &scriptRunner{cfg: cfg}
+}
+
+func (r *scriptRunner) CommandContext(ctx context.Context, _ string, arg ...string) Cmd {
+	if ctx == nil {
+		panic("nil Context")
+	}
+	return &scriptCmd{
+		ctx:  ctx,
+		cfg:  r.cfg,
+		args: append([]string(nil), arg...),
+	}
+}
+
+type scriptCmd struct {
+	ctx        context.Context
+	cfg        *CommandConfig
+	args       []string
+	input      *CommandInput
+	stdin      io.Reader
+	stdout     io.Writer
+	stderr     io.Writer
+	postBlocks func(blocks json.RawMessage)
+}
+
+func (c *scriptCmd) SetStdin(r io.Reader) {
+	c.stdin = r
+}
+
+func (c *scriptCmd) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *scriptCmd) SetStderr(w io.Writer) {
+	c.stderr = w
+}
+
+func (c *scriptCmd) SetInput(input *CommandInput) {
+	c.input = input
+}
+
+func (c *scriptCmd) SetBlockPoster(post func(blocks json.RawMessage)) {
+	c.postBlocks = post
+}
+
+// Run evaluates the script and returns its exit code.
+// - 0: the script finished without error
+// - 1: the script failed (including fail() and the step limit)
+// - 127: the script could not be loaded
+// - 143: cancelled or timed out
+func (c *scriptCmd) Run(timeout int) int {
+	filename, src, err := c.loadScript()
+	if err != nil {
+		c.writeErr(err)
+		return 127
+	}
+	stdin := ""
+	if c.stdin != nil {
+		data, readErr := io.ReadAll(c.stdin)
+		if readErr != nil {
+			c.writeErr(readErr)
+			return 127
+		}
+		stdin = string(data)
+	}
+
+	thread := &starlark.Thread{
+		Name: "script",
+		Print: func(_ *starlark.Thread, msg string) {
+			if c.stdout != nil {
+				_, _ = fmt.Fprintln(c.stdout, msg)
+			}
+		},
+	}
+	maxSteps := uint64(defaultScriptMaxSteps)
+	if c.cfg.MaxSteps > 0 {
+		maxSteps = uint64(c.cfg.MaxSteps)
+	}
+	thread.SetMaxExecutionSteps(maxSteps)
+	// execCmd.Cancel と同様に、ジョブのcontextが終わったら実行を打ち切る
+	stop := context.AfterFunc(c.ctx, func() {
+		thread.Cancel(c.ctx.Err().Error())
+	})
+	defer stop()
+
+	_, err = starlark.ExecFileOptions(
+		// 設定ファイルに書く小さなスクリプト向けに、トップレベルの制御構文等を許可する
+		&syntax.FileOptions{TopLevelControl: true, While: true, Set: true, GlobalReassign: true},
+		thread,
+		filename,
+		src,
+		c.predeclared(stdin),
+	)
+	if err == nil {
+		return 0
+	}
+	if errors.Is(c.ctx.Err(), context.Canceled) {
+		return 143
+	}
+	if errors.Is(c.ctx.Err(), context.DeadlineExceeded) {
+		if timeout > 0 && c.stderr != nil {
+			_, _ = fmt.Fprintf(c.stderr, "Timeout exceeded (%ds)", timeout)
+		}
+		return 143
+	}
+	var evalErr *starlark.EvalError
+	if errors.As(err, &evalErr) {
+		c.writeErr(errors.New(evalErr.Backtrace()))
+	} else {
+		c.writeErr(err)
+	}
+	return 1
+}
+
+func (c *scriptCmd) loadScript() (string, interface{}, error) {
+	if c.cfg.Script != "" {
+		return c.cfg.Keyword, c.cfg.Script, nil
+	}
+	path := strings.TrimSpace(c.cfg.ScriptFile)
+	if path == "" {
+		return "", nil, errors.New("script or script_file is required for script runner")
+	}
+	data, err := os.ReadFile(path) // #nosec G304 -- 設定ファイルで指定されたパス
+	if err != nil {
+		return "", nil, err
+	}
+	return path, data, nil
+}
+
+func (c *scriptCmd) predeclared(stdin string) starlark.StringDict {
+	args := make([]starlark.Value, len(c.args))
+	for i, a := range c.args {
+		args[i] = starlark.String(a)
+	}
+	user, channel := "", ""
+	if c.input != nil {
+		user, channel = c.input.SenderID, c.input.ChannelID
+	}
+	return starlark.StringDict{
+		"args":  starlark.NewList(args),
+		"stdin": starlark.String(stdin),
+		"slack": starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"user":    starlark.String(user),
+			"channel": starlark.String(channel),
+		}),
+		"http": &starlarkstruct.Module{
+			Name: "http",
+			Members: starlark.StringDict{
+				"get": starlark.NewBuiltin("http.get", c.httpGet),
+			},
+		},
+		"json":        starjson.Module,
+		"post_blocks": starlark.NewBuiltin("post_blocks", c.postBlocksBuiltin),
+	}
+}
+
+// httpGet は http.get(url, headers={}) を実装する。
+// 戻り値は status と body を持つstruct。
+func (c *scriptCmd) httpGet(
+	_ *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var url string
+	var headers *starlark.Dict
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "url", &url, "headers?", &headers); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if headers != nil {
+		for _, item := range headers.Items() {
+			k, ok1 := starlark.AsString(item[0])
+			v, ok2 := starlark.AsString(item[1])
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("%s: headers must be dict[str, str]", b.Name())
+			}
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, scriptHTTPBodyLimit))
+	if err != nil {
+		return nil, err
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"status": starlark.MakeInt(resp.StatusCode),
+		"body":   starlark.String(data),
+	}), nil
+}
+
+// postBlocksBuiltin は post_blocks(blocks) を実装する。
+// blocks はBlock Kitのブロックのlist（またはそのJSON文字列）。
+func (c *scriptCmd) postBlocksBuiltin(
+	thread *starlark.Thread,
+	b *starlark.Builtin,
+	args starlark.Tuple,
+	kwargs []starlark.Tuple,
+) (starlark.Value, error) {
+	var blocks starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &blocks); err != nil {
+		return nil, err
+	}
+	text, ok := starlark.AsString(blocks)
+	if !ok {
+		encoded, err := starlark.Call(thread, starjson.Module.Members["encode"], starlark.Tuple{blocks}, nil)
+		if err != nil {
+			return nil, err
+		}
+		text = string(encoded.(starlark.String))
+	}
+	if !json.Valid([]byte(text)) {
+		return nil, fmt.Errorf("%s: invalid JSON", b.Name())
+	}
+	if c.postBlocks == nil {
+		return nil, fmt.Errorf("%s: not supported in this context", b.Name())
+	}
+	c.postBlocks(json.RawMessage(text))
+	return starlark.None, nil
+}
+
+func (c *scriptCmd) writeErr(err error) {
+	if c.stderr != nil {
+		_, _ = fmt.Fprintf(c.stderr, "Error: %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newScriptCmd(ctx context.Context, def *Definition, args ...string) Cmd {
+	def.Runner = "script"
+	return NewScriptRunner(NewCommandConfig(def, nil)).CommandContext(ctx, "script", args...)
+}
+
+func TestScriptRunnerArgsStdinAndContext(t *testing.T) {
+	cmd := newScriptCmd(context.Background(), &Definition{
+		Keyword: "greet *",
+		Script: `
+print("hello " + " ".join(args))
+print(stdin.upper())
+print(slack.user, slack.channel)
+`,
+	}, "foo", "bar")
+	var stdout, stderr bytes.Buffer
+	cmd.SetStdin(strings.NewReader("line"))
+	cmd.SetStdout(&stdout)
+	cmd.SetStderr(&stderr)
+	cmd.(InputSetter).SetInput(&CommandInput{SenderID: "U1", ChannelID: "C1"})
+
+	if exitCode := cmd.Run(0); exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr=%q)", exitCode, stderr.String())
+	}
+	want := "hello foo bar\nLINE\nU1 C1\n"
+	if stdout.String() != want {
+		t.Fatalf("unexpected stdout: %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestScriptRunnerPostBlocks(t *testing.T) {
+	cmd := newScriptCmd(context.Background(), &Definition{
+		Script: `post_blocks([{"type": "section", "text": {"type": "mrkdwn", "text": "*hi*"}}])`,
+	})
+	var got json.RawMessage
+	cmd.(BlockPoster).SetBlockPoster(func(blocks json.RawMessage) {
+		got = blocks
+	})
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+
+	if exitCode := cmd.Run(0); exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d (stderr=%q)", exitCode, stderr.String())
+	}
+	var blocks []map[string]interface{}
+	if err := json.Unmarshal(got, &blocks); err != nil {
+		t.Fatalf("invalid blocks JSON %q: %v", string(got), err)
+	}
+	if len(blocks) != 1 || blocks[0]["type"] != "section" {
+		t.Fatalf("unexpected blocks: %s", string(got))
+	}
+}
+
+func TestScriptRunnerFailureReturnsOne(t *testing.T) {
+	cmd := newScriptCmd(context.Background(), &Definition{Script: `fail("boom")`})
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+	if exitCode := cmd.Run(0); exitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exitCode)
+	}
+	if !strings.Contains(stderr.String(), "boom") {
+		t.Fatalf("expected error message in stderr, got %q", stderr.String())
+	}
+}
+
+func TestScriptRunnerStepLimit(t *testing.T) {
+	cmd := newScriptCmd(context.Background(), &Definition{
+		Script:   "for i in range(1000000):\n    pass\n",
+		MaxSteps: 1000,
+	})
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+	if exitCode := cmd.Run(0); exitCode != 1 {
+		t.Fatalf("expected exit code 1, got %d", exitCode)
+	}
+	if !strings.Contains(stderr.String(), "too many steps") {
+		t.Fatalf("expected step limit error, got %q", stderr.String())
+	}
+}
+
+func TestScriptRunnerTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	cmd := newScriptCmd(ctx, &Definition{
+		Script:   "for i in range(1 << 40):\n    pass\n",
+		MaxSteps: 1 << 50,
+	})
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+
+	done := make(chan int, 1)
+	go func() { done <- cmd.Run(1) }()
+	select {
+	case exitCode := <-done:
+		if exitCode != 143 {
+			t.Fatalf("expected exit code 143, got %d", exitCode)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("script was not cancelled")
+	}
+	if !strings.Contains(stderr.String(), "Timeout exceeded") {
+		t.Fatalf("expected timeout message, got %q", stderr.String())
+	}
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-shellwords"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+type wasmRunner struct {
+	cfg *CommandConfig
+}
+
+// NewWASMRunner returns a runner that executes WASI modules in-process
+// with the pure-Go wazero runtime.
+// The first element of the command is the path to the .wasm module.
+func NewWASMRunner(cfg *CommandConfig) CommandRunner {
+	return &wasmRunner{cfg: cfg}
+}
+
+// wasmRuntimes はメモリの上限（ページ数）ごとに共有するランタイム。
+// 設定の再読み込みでランナーを作り直しても、ランタイムとコンパイル結果は使い回す。
+var (
+	wasmRuntimesMu sync.Mutex
+	wasmRuntimes   = map[uint32]*wasmRuntime{}
+)
+
+// wasmRuntime はwazeroのランタイムと、そのランタイムでコンパイルしたモジュール
+type wasmRuntime struct {
+	runtime wazero.Runtime
+	initErr error
+
+	mu       sync.Mutex
+	files    map[string]wasmFile               // モジュールのパスごとの、最後に読んだファイルの状態
+	compiled map[[sha256.Size]byte]*wasmModule // 内容のハッシュごとのコンパイル結果
+}
+
+// wasmFile はモジュールのファイルを読んだ時点の状態
+type wasmFile struct {
+	modTime time.Time
+	size    int64
+	sum     [sha256.Size]byte
+}
+
+// wasmModule はコンパイルしたモジュール。
+// wazeroは同じ内容のモジュールのコンパイル結果を共有するので、内容ごとに1つだけ作る。
+type wasmModule struct {
+	module wazero.CompiledModule
+	sum    [sha256.Size]byte
+	paths  int // このモジュールを内容とするパスの数
+	refs   int // 実行中のコマンドの数
+}
+
+func sharedWASMRuntime(maxMemoryPages uint32) *wasmRuntime {
+	wasmRuntimesMu.Lock()
+	defer wasmRuntimesMu.Unlock()
+	if rt, ok := wasmRuntimes[maxMemoryPages]; ok {
+		return rt
+	}
+	rc := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+	if maxMemoryPages > 0 {
+		rc = rc.WithMemoryLimitPages(maxMemoryPages)
+	}
+	ctx := context.Background()
+	rt := &wasmRuntime{
+		runtime:  wazero.NewRuntimeWithConfig(ctx, rc),
+		files:    map[string]wasmFile{},
+		compiled: map[[sha256.Size]byte]*wasmModule{},
+	}
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt.runtime); err != nil {
+		rt.initErr = err
+	}
+	wasmRuntimes[maxMemoryPages] = rt
+	return rt
+}
+
+// acquire は path のモジュールのコンパイル結果を返す。ファイルが更新されていれば読み直す。
+// 使い終わったら release を呼ぶ。
+func (rt *wasmRuntime) acquire(path string) (*wasmModule, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	f, ok := rt.files[path]
+	if !ok || !f.modTime.Equal(info.ModTime()) || f.size != info.Size() {
+		if f, err = rt.load(path, info, f, ok); err != nil {
+			return nil, err
+		}
+	}
+	m := rt.compiled[f.sum]
+	m.refs++
+	return m, nil
+}
+
+// load は path のモジュールを読み、内容が変わっていればコンパイルし直す。
+// 前の内容のコンパイル結果は、使われなくなったら閉じる。rt.mu を持った状態で呼ぶ。
+func (rt *wasmRuntime) load(path string, info os.FileInfo, prev wasmFile, loaded bool) (wasmFile, error) {
+	bin, err := os.ReadFile(path) // #nosec G304 -- 設定ファイルで指定されたパス
+	if err != nil {
+		return wasmFile{}, err
+	}
+	f := wasmFile{modTime: info.ModTime(), size: info.Size(), sum: sha256.Sum256(bin)}
+	if !loaded || prev.sum != f.sum {
+		m, ok := rt.compiled[f.sum]
+		if !ok {
+			compiled, err := rt.runtime.CompileModule(context.Background(), bin)
+			if err != nil {
+				return wasmFile{}, err
+			}
+			m = &wasmModule{module: compiled, sum: f.sum}
+			rt.compiled[f.sum] = m
+		}
+		m.paths++
+		if loaded {
+			old := rt.compiled[prev.sum]
+			old.paths--
+			rt.closeIfUnused(old)
+		}
+	}
+	rt.files[path] = f
+	return f, nil
+}
+
+// CheckWASMModule は command の先頭に指定されたモジュールを読み、コンパイルできるか確かめる。
+// コンパイル結果は実行時にそのまま使う。
+func CheckWASMModule(command string, maxMemoryPages int) error {
+	args, err := shellwords.Parse(strings.Replace(command, "*", "", 1))
+	if err != nil || len(args) == 0 {
+		return errors.New("command cannot be parsed")
+	}
+	rt := sharedWASMRuntime(uint32(max(maxMemoryPages, 0)))
+	if rt.initErr != nil {
+		return rt.initErr
+	}
+	m, err := rt.acquire(args[0])
+	if err != nil {
+		return err
+	}
+	rt.release(m)
+	return nil
+}
+
+func (rt *wasmRuntime) release(m *wasmModule) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	m.refs--
+	rt.closeIfUnused(m)
+}
+
+// closeIfUnused はどのパスの内容でもなく、実行中のコマンドもないコンパイル結果を閉じる。rt.mu を持った状態で呼ぶ。
+func (rt *wasmRuntime) closeIfUnused(m *wasmModule) {
+	if m.paths == 0 && m.refs == 0 {
+		_ = m.module.Close(context.Background())
+		delete(rt.compiled, m.sum)
+	}
+}
+
+func (r *wasmRunner) CommandContext(ctx context.Context, name string, arg ...string) Cmd {
+	if ctx == nil {
+		panic("nil Context")
+	}
+	return &wasmCmd{
+		ctx:  ctx,
+		cfg:  r.cfg,
+		args: append([]string{name}, arg...),
+		rt:   sharedWASMRuntime(uint32(max(r.cfg.MaxMemoryPages, 0))),
+	}
+}
+
+type wasmCmd struct {
+	ctx    context.Context
+	cfg    *CommandConfig
+	args   []string
+	rt     *wasmRuntime
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *wasmCmd) SetStdin(r io.Reader) {
+	c.stdin = r
+}
+
+func (c *wasmCmd) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *wasmCmd) SetStderr(w io.Writer) {
+	c.stderr = w
+}
+
+// Run instantiates the module and returns its exit code.
+// - 0-255: exit code passed to proc_exit
+// - 127: failed to load or instantiate the module
+// - 143: cancelled or timed out
+func (c *wasmCmd) Run(timeout int) int {
+	if c.rt.initErr != nil {
+		c.writeErr(c.rt.initErr)
+		return 127
+	}
+	m, err := c.rt.acquire(c.args[0])
+	if err != nil {
+		c.writeErr(err)
+		return 127
+	}
+	defer c.rt.release(m)
+	mc := wazero.NewModuleConfig().
+		WithName(""). // 同じモジュールを並行して実行できるように無名にする
+		WithArgs(c.args...).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithRandSource(rand.Reader)
+	if c.stdin != nil {
+		mc = mc.WithStdin(c.stdin)
+	}
+	if c.stdout != nil {
+		mc = mc.WithStdout(c.stdout)
+	}
+	if c.stderr != nil {
+		mc = mc.WithStderr(c.stderr)
+	}
+	if dir := strings.TrimSpace(c.cfg.PreopenDir); dir != "" {
+		mc = mc.WithFSConfig(wazero.NewFSConfig().WithDirMount(dir, "/"))
+	}
+
+	mod, err := c.rt.runtime.InstantiateModule(c.ctx, m.module, mc)
+	if mod != nil {
+		defer func() { _ = mod.Close(context.Background()) }()
+	}
+	if err == nil {
+		return 0
+	}
+	var exitErr *sys.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case sys.ExitCodeContextCanceled:
+			return 143
+		case sys.ExitCodeDeadlineExceeded:
+			if timeout > 0 && c.stderr != nil {
+				_, _ = fmt.Fprintf(c.stderr, "Timeout exceeded (%ds)", timeout)
+			}
+			return 143
+		}
+		return int(exitErr.ExitCode())
+	}
+	c.writeErr(err)
+	return 127
+}
+
+func (c *wasmCmd) writeErr(err error) {
+	if c.stderr != nil {
+		_, _ = fmt.Fprintf(c.stderr, "Error: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var (
+	wasmToolOnce sync.Once
+	wasmToolPath string
+	wasmToolErr  error
+)
+
+// buildWASMTool は testdata/wasmtool を GOOS=wasip1 でビルドする
+func buildWASMTool(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping wasm build in short mode")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	wasmToolOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "wasmtool")
+		if err != nil {
+			wasmToolErr = err
+			return
+		}
+		wasmToolPath = filepath.Join(dir, "wasmtool.wasm")
+		build := exec.Command(goBin, "build", "-o", wasmToolPath, "./testdata/wasmtool")
+		build.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if out, err := build.CombinedOutput(); err != nil {
+			wasmToolErr = err
+			wasmToolPath = string(out)
+		}
+	})
+	if wasmToolErr != nil {
+		t.Fatalf("failed to build wasm tool: %v\n%s", wasmToolErr, wasmToolPath)
+	}
+	return wasmToolPath
+}
+
+func TestWASMRunnerArgsAndStdio(t *testing.T) {
+	path := buildWASMTool(t)
+	runner := NewWASMRunner(NewCommandConfig(&Definition{Runner: "wasm"}, nil))
+
+	cmd := runner.CommandContext(context.Background(), path, "echo", "hello", "world")
+	var stdout bytes.Buffer
+	cmd.SetStdout(&stdout)
+	if exitCode := cmd.Run(0); exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitCode)
+	}
+	if stdout.String() != "hello world\n" {
+		t.Fatalf("unexpected stdout: %q", stdout.String())
+	}
+
+	cmd = runner.CommandContext(context.Background(), path, "cat")
+	stdout.Reset()
+	cmd.SetStdin(strings.NewReader("from stdin"))
+	cmd.SetStdout(&stdout)
+	if exitCode := cmd.Run(0); exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitCode)
+	}
+	if stdout.String() != "from stdin" {
+		t.Fatalf("unexpected stdout: %q", stdout.String())
+	}
+}
+
+func TestWASMRunnerExitCode(t *testing.T) {
+	path := buildWASMTool(t)
+	runner := NewWASMRunner(NewCommandConfig(&Definition{Runner: "wasm"}, nil))
+	cmd := runner.CommandContext(context.Background(), path, "exit", "3")
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+	if exitCode := cmd.Run(0); exitCode != 3 {
+		t.Fatalf("expected exit code 3, got %d", exitCode)
+	}
+	if stderr.String() != "exiting\n" {
+		t.Fatalf("unexpected stderr: %q", stderr.String())
+	}
+}
+
+func TestWASMRunnerTimeout(t *testing.T) {
+	path := buildWASMTool(t)
+	runner := NewWASMRunner(NewCommandConfig(&Definition{Runner: "wasm"}, nil))
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	cmd := runner.CommandContext(ctx, path, "loop")
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+
+	done := make(chan int, 1)
+	go func() { done <- cmd.Run(1) }()
+	select {
+	case exitCode := <-done:
+		if exitCode != 143 {
+			t.Fatalf("expected exit code 143, got %d", exitCode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("wasm module was not stopped")
+	}
+	if !strings.Contains(stderr.String(), "Timeout exceeded") {
+		t.Fatalf("expected timeout message, got %q", stderr.String())
+	}
+}
+
+func TestWASMRunnerMissingModule(t *testing.T) {
+	runner := NewWASMRunner(NewCommandConfig(&Definition{Runner: "wasm"}, nil))
+	cmd := runner.CommandContext(context.Background(), filepath.Join(t.TempDir(), "none.wasm"))
+	var stderr bytes.Buffer
+	cmd.SetStderr(&stderr)
+	if exitCode := cmd.Run(0); exitCode != 127 {
+		t.Fatalf("expected exit code 127, got %d", exitCode)
+	}
+}
+
+func TestCheckWASMModule(t *testing.T) {
+	dir := t.TempDir()
+	if err := CheckWASMModule(filepath.Join(dir, "none.wasm")+" *", 0); err == nil {
+		t.Fatal("expected error for missing module")
+	}
+	broken := filepath.Join(dir, "broken.wasm")
+	if err := os.WriteFile(broken, []byte("not a wasm module"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckWASMModule(broken, 0); err == nil {
+		t.Fatal("expected error for module that cannot be compiled")
+	}
+	if err := CheckWASMModule(buildWASMTool(t)+" echo *", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestWASMRunnerRecompilesChangedModule(t *testing.T) {
+	bin, err := os.ReadFile(buildWASMTool(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "tool.wasm")
+	if err := os.WriteFile(path, bin, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	run := func() int {
+		// 設定の再読み込みと同じく、ランナーを毎回作り直す
+		runner := NewWASMRunner(NewCommandConfig(&Definition{Runner: "wasm"}, nil))
+		return runner.CommandContext(context.Background(), path, "exit", "0").Run(0)
+	}
+	if exitCode := run(); exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitCode)
+	}
+	rt := sharedWASMRuntime(0)
+	first := rt.compiled[rt.files[path].sum]
+	if exitCode := run(); exitCode != 0 || rt.compiled[rt.files[path].sum] != first {
+		t.Fatalf("expected the compiled module to be reused (exit code %d)", exitCode)
+	}
+
+	// ファイルが置き換わったらコンパイルし直し、前のコンパイル結果はどのパスからも使われなくなったら閉じる
+	paths := first.paths
+	empty := []byte("\x00asm\x01\x00\x00\x00") // 何もしないモジュール
+	if err := os.WriteFile(path, empty, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := run(); exitCode != 0 {
+		t.Fatalf("expected exit code 0 for the empty module, got %d", exitCode)
+	}
+	if first.paths != paths-1 || (first.paths == 0) != (rt.compiled[first.sum] == nil) {
+		t.Fatalf("expected the old module to be released: paths=%d", first.paths)
+	}
+	if err := os.WriteFile(path, []byte("not wasm"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := run(); exitCode != 127 {
+		t.Fatalf("expected exit code 127 for the broken module, got %d", exitCode)
+	}
+	// 元に戻した場合も実行できる
+	if err := os.WriteFile(path, bin, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode := run(); exitCode != 0 {
+		t.Fatalf("expected exit code 0 after restoring the module, got %d", exitCode)
+	}
+}
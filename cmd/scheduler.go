@@ -0,0 +1,457 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// 同時実行数の上限に達した場合の動作（Definition.OnLimit）
+const (
+	OnLimitQueue        = "queue"         // 空くまで待つ（デフォルト）
+	OnLimitReject       = "reject"        // メッセージを返して実行しない
+	OnLimitCancelOldest = "cancel_oldest" // 実行中のうち一番古いものをキャンセルしてから実行する
+)
+
+// exitRejected は同時実行数の上限で実行しなかった場合の終了コード（EX_TEMPFAIL）
+const exitRejected = 75
+
+// QueueFullMessage は待っている入力が多すぎて受け付けなかった場合の返信
+const QueueFullMessage = "混み合っているためコマンドを受け付けられませんでした。しばらくしてから再度実行してください"
+
+// exitCancelled は実行前に取り消した場合の終了コード（実行中にキャンセルした場合のRunnerに合わせる）
+const exitCancelled = 143
+
+// Scheduler は commandQueue とワーカーの間に入り、コマンドごとの同時実行数（max_concurrency）と
+// 排他グループ（lock_group）を守って、次に実行する入力をワーカーに渡す。
+// 待機中の入力は priorityQueue の順に実行し、上限に達しているコマンドの入力は他の入力に追い越されて待つ。
+//
+// 待機中の入力は commandQueue の容量（queue_size）までしか持たず、それを超えた入力は QueueFull を出力して受け付けない。
+// commandQueue からはすぐに受け取るので、待っている入力の数は commandQueue の容量を超えない。
+// すぐに実行できない入力には Queued を、待っていた入力の実行が始まるか取り消された時には Dequeued を出力する。
+type Scheduler struct {
+	table *CommandTable
+	wq    chan *CommandOutput
+
+	mu      sync.Mutex
+	pending *priorityQueue
+	running map[*scheduledInput]struct{}
+	counts  map[string]int             // キーワードごとの実行中の数
+	locks   map[string]*scheduledInput // lock_group ごとの実行中の入力
+	changed chan struct{}              // 状態が変わるたびにcloseして作り直す
+	closed  bool                       // commandQueue がcloseされた
+	idle    int                        // 入力を待っているワーカーの数
+	limit   int                        // 待機中の入力の上限（0なら制限しない）
+	now     func() time.Time
+}
+
+// scheduledInput は Scheduler が受け付けた入力
+type scheduledInput struct {
+	input    *CommandInput
+	matchers []*Matcher // 受け付けた時点のコマンド定義
+	uses     []*Matcher // input が実行するコマンド定義（重複なし）
+	ctx      context.Context
+	cancel   context.CancelFunc
+	started  time.Time
+	queued   chan struct{} // Queued を出力したらcloseする（出力しない入力ではnil）
+
+	// 以下は priorityQueue が使う
+	seq        uint64
+	enqueuedAt time.Time
+	priority   int // uses のうち一番高い priority
+}
+
+// NewScheduler returns a Scheduler for the commands in table.
+// Rejection messages are written to wq.
+func NewScheduler(table *CommandTable, wq chan *CommandOutput) *Scheduler {
+	s := &Scheduler{
+		table:   table,
+		wq:      wq,
+		running: map[*scheduledInput]struct{}{},
+		counts:  map[string]int{},
+		locks:   map[string]*scheduledInput{},
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+	s.pending = newPriorityQueue(func() time.Time { return s.now() })
+	return s
+}
+
+// Run は rq から入力を受け付ける。ctxが終了するか rq がcloseされると戻る。
+func (s *Scheduler) Run(ctx context.Context, rq chan *CommandInput) {
+	defer s.close()
+	s.mu.Lock()
+	s.limit = max(cap(rq), 1)
+	s.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case input, ok := <-rq:
+			if !ok {
+				return
+			}
+			if s.full() {
+				s.rejectFull(input)
+				continue
+			}
+			item, rejectedBy, position := s.admit(input)
+			switch {
+			case rejectedBy != nil:
+				s.reject(item, rejectedBy)
+			case position > 0:
+				s.notifyQueued(item, position)
+			}
+		}
+	}
+}
+
+// full は待機中の入力が上限に達しているかを返す
+func (s *Scheduler) full() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit > 0 && s.pending.len() >= s.limit
+}
+
+// rejectFull は待っている入力が多すぎることを返信して、実行せずに終える
+func (s *Scheduler) rejectFull(input *CommandInput) {
+	s.wq <- &CommandOutput{
+		ReplyInfo: input.ReplyInfo,
+		Text:      QueueFullMessage,
+		IsErrOut:  true,
+		QueueFull: true,
+		Context:   input.Context,
+	}
+	if input.Done != nil {
+		input.Done(exitRejected)
+	}
+}
+
+// Len は実行を待っている入力の数を返す
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending.len()
+}
+
+func (s *Scheduler) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.notify()
+}
+
+// notify は待っているワーカーを起こす。s.mu を持った状態で呼ぶ。
+func (s *Scheduler) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// admit は入力を受け付け、上限に達していれば OnLimit に従って処理する。
+// reject で受け付けなかった場合は上限に達したコマンド定義を返す。
+// すぐには実行できない場合は待ち順（1始まり）を返す。
+func (s *Scheduler) admit(input *CommandInput) (*scheduledInput, *Matcher, int) {
+	matchers := s.table.load()
+	item := &scheduledInput{
+		input:    input,
+		matchers: matchers,
+		uses:     usedMatchers(input, matchers),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range item.uses {
+		if !s.limitReached(m) {
+			continue
+		}
+		switch m.cfg.OnLimit {
+		case OnLimitReject:
+			return item, m, 0
+		case OnLimitCancelOldest:
+			s.cancelOldest(m)
+		}
+	}
+	s.pending.push(item)
+	s.notify()
+	if len(item.uses) == 0 || s.startsSoon(item) {
+		return item, nil, 0
+	}
+	return item, nil, s.pending.position(item)
+}
+
+// startsSoon は待機中の入力 item を、空いているワーカーがすぐに実行するかを返す。
+// s.mu を持った状態で呼ぶ。
+func (s *Scheduler) startsSoon(item *scheduledInput) bool {
+	if !s.runnable(item) {
+		return false
+	}
+	// item より先に実行される予定の実行可能な入力から順にワーカーが取っていく
+	ahead := 0
+	for _, p := range s.pending.ahead(item) {
+		if s.runnable(p) {
+			ahead++
+		}
+	}
+	return s.idle > ahead
+}
+
+// notifyQueued はワーカーが空くのを待っていることを待ち順とともに出力する
+func (s *Scheduler) notifyQueued(item *scheduledInput, position int) {
+	s.mu.Lock()
+	if !slices.Contains(s.pending.items, item) {
+		// 既に実行が始まったか取り消された
+		s.mu.Unlock()
+		return
+	}
+	item.queued = make(chan struct{})
+	s.mu.Unlock()
+	s.wq <- &CommandOutput{
+		ReplyInfo:     item.input.ReplyInfo,
+		Queued:        true,
+		QueuePosition: position,
+		Context:       item.input.Context,
+	}
+	close(item.queued)
+}
+
+// notifyDequeued は待っていた入力が待つのをやめたことを出力する。
+// queued は待機中でなくなった時点の item.queued で、nilなら Queued を出力していないので何もしない。
+func (s *Scheduler) notifyDequeued(item *scheduledInput, queued chan struct{}) {
+	if queued == nil {
+		return
+	}
+	// Queued より後に出力する
+	<-queued
+	s.wq <- &CommandOutput{
+		ReplyInfo: item.input.ReplyInfo,
+		Dequeued:  true,
+		Context:   item.input.Context,
+	}
+}
+
+// limitReached は m の実行中と待機中の数が上限に達しているかを返す
+func (s *Scheduler) limitReached(m *Matcher) bool {
+	if m.cfg.MaxConcurrency <= 0 && m.cfg.LockGroup == "" {
+		return false
+	}
+	count := s.counts[m.cfg.Keyword]
+	locked := m.cfg.LockGroup != "" && s.locks[m.cfg.LockGroup] != nil
+	for _, p := range s.pending.items {
+		for _, u := range p.uses {
+			if u.cfg.Keyword == m.cfg.Keyword {
+				count++
+			}
+			if m.cfg.LockGroup != "" && u.cfg.LockGroup == m.cfg.LockGroup {
+				locked = true
+			}
+		}
+	}
+	return locked || (m.cfg.MaxConcurrency > 0 && count >= m.cfg.MaxConcurrency)
+}
+
+// cancelOldest は m と同じキーワードか同じ lock_group の実行中の入力のうち、一番古いものをキャンセルする
+func (s *Scheduler) cancelOldest(m *Matcher) {
+	var oldest *scheduledInput
+	for r := range s.running {
+		if !conflicts(r.uses, m) {
+			continue
+		}
+		if oldest == nil || r.started.Before(oldest.started) {
+			oldest = r
+		}
+	}
+	if oldest != nil {
+		oldest.cancel()
+	}
+}
+
+// reject は上限に達したことを返信して、実行せずに終える
+func (s *Scheduler) reject(item *scheduledInput, m *Matcher) {
+	s.wq <- &CommandOutput{
+		ReplyInfo: item.input.ReplyInfo,
+		Text:      fmt.Sprintf("%s は実行中のため受け付けませんでした", m.cfg.Keyword),
+		IsErrOut:  true,
+		Rejected:  true,
+		Context:   item.input.Context,
+	}
+	if item.input.Done != nil {
+		item.input.Done(exitRejected)
+	}
+}
+
+// Cancel は SourceKey が key の入力を取り消す。実行中の入力はキャンセルし、
+// 待機中の入力は実行せずに終える。取り消した入力の数を返す。
+func (s *Scheduler) Cancel(key string) int {
+	if key == "" {
+		return 0
+	}
+	s.mu.Lock()
+	n := 0
+	for r := range s.running {
+		if r.input.SourceKey == key {
+			r.cancel()
+			n++
+		}
+	}
+	removed := s.pending.remove(func(item *scheduledInput) bool {
+		return item.input.SourceKey == key
+	})
+	queued := make([]chan struct{}, len(removed))
+	for i, item := range removed {
+		queued[i] = item.queued
+	}
+	if len(removed) > 0 {
+		s.notify()
+	}
+	s.mu.Unlock()
+	for i, item := range removed {
+		s.notifyDequeued(item, queued[i])
+		if item.input.Done != nil {
+			item.input.Done(exitCancelled)
+		}
+	}
+	return n + len(removed)
+}
+
+// next は次に実行できる入力を返す。ctxが終了するか、受付が終わって待機中の入力がなくなるとfalseを返す。
+func (s *Scheduler) next(ctx context.Context) (*scheduledInput, bool) {
+	s.mu.Lock()
+	for {
+		if item := s.pick(); item != nil {
+			item.ctx, item.cancel = context.WithCancel(ctx)
+			item.started = s.now()
+			queued := item.queued
+			s.mu.Unlock()
+			s.notifyDequeued(item, queued)
+			return item, true
+		}
+		if s.closed && s.pending.len() == 0 {
+			s.mu.Unlock()
+			return nil, false
+		}
+		changed := s.changed
+		s.idle++
+		s.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.idle--
+			s.mu.Unlock()
+			return nil, false
+		case <-changed:
+		}
+		s.mu.Lock()
+		s.idle--
+	}
+}
+
+// pick は待機中の入力のうち、上限に達していないものを priorityQueue の順に1つ実行中にして返す。
+// s.mu を持った状態で呼ぶ。
+func (s *Scheduler) pick() *scheduledInput {
+	item := s.pending.pop(s.runnable)
+	if item == nil {
+		return nil
+	}
+	s.running[item] = struct{}{}
+	for _, m := range item.uses {
+		s.counts[m.cfg.Keyword]++
+		if m.cfg.LockGroup != "" {
+			s.locks[m.cfg.LockGroup] = item
+		}
+	}
+	return item
+}
+
+func (s *Scheduler) runnable(item *scheduledInput) bool {
+	for _, m := range item.uses {
+		if m.cfg.MaxConcurrency > 0 && s.counts[m.cfg.Keyword] >= m.cfg.MaxConcurrency {
+			return false
+		}
+		if m.cfg.LockGroup != "" && s.locks[m.cfg.LockGroup] != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// done は実行を終えた入力の枠を空ける
+func (s *Scheduler) done(item *scheduledInput) {
+	item.cancel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, item)
+	for _, m := range item.uses {
+		s.counts[m.cfg.Keyword]--
+		if s.counts[m.cfg.Keyword] <= 0 {
+			delete(s.counts, m.cfg.Keyword)
+		}
+		if m.cfg.LockGroup != "" && s.locks[m.cfg.LockGroup] == item {
+			delete(s.locks, m.cfg.LockGroup)
+		}
+	}
+	s.notify()
+}
+
+// ExecutorWithScheduler runs inputs handed out by s until ctx is done or s has
+// no more inputs. Start one per worker.
+func ExecutorWithScheduler(
+	ctx context.Context,
+	s *Scheduler,
+	wq chan *CommandOutput,
+	opts ...ExecutorOption,
+) {
+	var o executorOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	for {
+		item, ok := s.next(ctx)
+		if !ok {
+			return
+		}
+		ret := runInput(item.ctx, item.input, item.matchers, wq, &o)
+		s.done(item)
+		if item.input.Done != nil {
+			item.input.Done(ret)
+		}
+	}
+}
+
+// usedMatchers は input が実行するコマンド定義を重複なしで返す
+func usedMatchers(input *CommandInput, matchers []*Matcher) []*Matcher {
+	matchers = restrictMatchers(matchers, input.AllowedCommands)
+	cmdMsg, _ := splitCommandInput(input.Text)
+	cmds, _ := parseCommands(cmdMsg)
+	var uses []*Matcher
+	for _, c := range cmds {
+		m, _ := findMatchedMatcher(c, matchers)
+		if m == nil || containsMatcher(uses, m) {
+			continue
+		}
+		uses = append(uses, m)
+	}
+	return uses
+}
+
+func containsMatcher(ms []*Matcher, m *Matcher) bool {
+	for _, x := range ms {
+		if x == m {
+			return true
+		}
+	}
+	return false
+}
+
+// conflicts は uses のいずれかが m と同じキーワードか同じ lock_group かを返す
+func conflicts(uses []*Matcher, m *Matcher) bool {
+	for _, u := range uses {
+		if u.cfg.Keyword == m.cfg.Keyword {
+			return true
+		}
+		if m.cfg.LockGroup != "" && u.cfg.LockGroup == m.cfg.LockGroup {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func newTestScheduler(defs ...*Definition) (*Scheduler, chan *CommandOutput) {
+	cfgs := make([]*CommandConfig, 0, len(defs))
+	for _, d := range defs {
+		cfgs = append(cfgs, NewCommandConfig(d, nil))
+	}
+	wq := make(chan *CommandOutput, 10)
+	return NewScheduler(NewCommandTable(cfgs, nil), wq), wq
+}
+
+// tryNext は実行できる入力があれば実行中にして返す。なければnilを返す。
+func tryNext(t *testing.T, s *Scheduler) *scheduledInput {
+	t.Helper()
+	s.mu.Lock()
+	item := s.pick()
+	s.mu.Unlock()
+	if item != nil {
+		item.ctx, item.cancel = context.WithCancel(context.Background())
+		item.started = s.now()
+	}
+	return item
+}
+
+func admitText(t *testing.T, s *Scheduler, text string) *scheduledInput {
+	t.Helper()
+	item, rejected, _ := s.admit(&CommandInput{Text: text})
+	if rejected != nil {
+		t.Fatalf("%q was rejected by %s", text, rejected.cfg.Keyword)
+	}
+	return item
+}
+
+func TestSchedulerMaxConcurrency(t *testing.T) {
+	s, _ := newTestScheduler(
+		&Definition{Keyword: "deploy *", Command: "deploy.sh", MaxConcurrency: 1},
+		&Definition{Keyword: "date", Command: "date"},
+	)
+	admitText(t, s, "deploy web")
+	admitText(t, s, "deploy db")
+	admitText(t, s, "date")
+
+	first := tryNext(t, s)
+	if first == nil || first.input.Text != "deploy web" {
+		t.Fatalf("unexpected first input: %+v", first)
+	}
+	// 2つ目の deploy は上限に達しているので date が追い越す
+	if item := tryNext(t, s); item == nil || item.input.Text != "date" {
+		t.Fatalf("expected date to run, got %+v", item)
+	}
+	if item := tryNext(t, s); item != nil {
+		t.Fatalf("expected no runnable input, got %q", item.input.Text)
+	}
+	s.done(first)
+	if item := tryNext(t, s); item == nil || item.input.Text != "deploy db" {
+		t.Fatalf("expected deploy db to run after the first finished, got %+v", item)
+	}
+}
+
+func TestSchedulerLockGroup(t *testing.T) {
+	s, _ := newTestScheduler(
+		&Definition{Keyword: "deploy", Command: "deploy.sh", LockGroup: "release"},
+		&Definition{Keyword: "rollback", Command: "rollback.sh", LockGroup: "release"},
+		&Definition{Keyword: "date", Command: "date"},
+	)
+	admitText(t, s, "deploy")
+	admitText(t, s, "date && rollback")
+
+	first := tryNext(t, s)
+	if first == nil || first.input.Text != "deploy" {
+		t.Fatalf("unexpected first input: %+v", first)
+	}
+	// チェインの途中のコマンドも lock_group の対象になる
+	if item := tryNext(t, s); item != nil {
+		t.Fatalf("expected rollback to wait for deploy, got %q", item.input.Text)
+	}
+	s.done(first)
+	if item := tryNext(t, s); item == nil || item.input.Text != "date && rollback" {
+		t.Fatalf("expected rollback to run, got %+v", item)
+	}
+}
+
+func TestSchedulerRejectsOnLimit(t *testing.T) {
+	s, wq := newTestScheduler(
+		&Definition{Keyword: "deploy", Command: "deploy.sh", MaxConcurrency: 1, OnLimit: OnLimitReject},
+	)
+	admitText(t, s, "deploy")
+
+	rq := make(chan *CommandInput, 2)
+	exitCode := make(chan int, 1)
+	rq <- &CommandInput{Text: "deploy", Done: func(ret int) { exitCode <- ret }}
+	close(rq)
+	s.Run(context.Background(), rq)
+
+	if ret := <-exitCode; ret != exitRejected {
+		t.Fatalf("unexpected exit code: %d", ret)
+	}
+	out := <-wq
+	if !out.IsErrOut || !out.Rejected || !strings.Contains(out.Text, "deploy") {
+		t.Fatalf("unexpected rejection message: %+v", out)
+	}
+	if s.pending.len() != 1 {
+		t.Fatalf("rejected input must not be queued: %d pending", s.pending.len())
+	}
+}
+
+func TestSchedulerCancelsOldest(t *testing.T) {
+	s, _ := newTestScheduler(
+		&Definition{Keyword: "build", Command: "build.sh", MaxConcurrency: 1, OnLimit: OnLimitCancelOldest},
+	)
+	admitText(t, s, "build")
+	old := tryNext(t, s)
+	if old == nil {
+		t.Fatal("expected build to run")
+	}
+
+	admitText(t, s, "build")
+	if old.ctx.Err() == nil {
+		t.Fatal("expected the older run to be cancelled")
+	}
+	// 古い実行が終わるまで新しい入力は待つ
+	if item := tryNext(t, s); item != nil {
+		t.Fatal("expected the new input to wait for the cancelled run")
+	}
+	s.done(old)
+	if item := tryNext(t, s); item == nil || item.ctx.Err() != nil {
+		t.Fatalf("expected the new input to run: %+v", item)
+	}
+}
+
+func TestSchedulerNotifiesQueuePosition(t *testing.T) {
+	s, wq := newTestScheduler(&Definition{Keyword: "date", Command: "date"})
+	// 空いているワーカーがいればすぐに実行するので待ち順は通知しない
+	s.idle = 1
+	if _, _, position := s.admit(&CommandInput{Text: "date"}); position != 0 {
+		t.Fatalf("expected no queue position, got %d", position)
+	}
+	s.idle = 0
+	rq := make(chan *CommandInput, 4)
+	rq <- &CommandInput{Text: "date"}
+	rq <- &CommandInput{Text: "unknown"} // マッチしないコマンドは通知しない
+	close(rq)
+	s.Run(context.Background(), rq)
+
+	out := <-wq
+	if !out.Queued || out.QueuePosition != 2 || out.Text != "" {
+		t.Fatalf("unexpected queued output: %+v", out)
+	}
+	if len(wq) != 0 {
+		t.Fatalf("unexpected extra output: %+v", <-wq)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var texts []string
+	for range 3 {
+		item, ok := s.next(ctx)
+		if !ok {
+			t.Fatal("expected an input")
+		}
+		texts = append(texts, item.input.Text)
+		s.done(item)
+	}
+	if strings.Join(texts, ",") != "date,date,unknown" {
+		t.Fatalf("unexpected order: %v", texts)
+	}
+	// 待ち順を通知した入力だけ、実行が始まる時に Dequeued を出力する
+	if out := <-wq; !out.Dequeued {
+		t.Fatalf("expected dequeued output, got %+v", out)
+	}
+	if len(wq) != 0 {
+		t.Fatalf("unexpected extra output: %+v", <-wq)
+	}
+}
+
+func TestSchedulerRejectsWhenFull(t *testing.T) {
+	s, wq := newTestScheduler(&Definition{Keyword: "date", Command: "date"})
+	admitText(t, s, "date")
+	rq := make(chan *CommandInput, 2)
+	exitCode := make(chan int, 1)
+	rq <- &CommandInput{Text: "date"}
+	rq <- &CommandInput{Text: "date", Done: func(ret int) { exitCode <- ret }}
+	close(rq)
+	s.Run(context.Background(), rq)
+
+	// 待機中の入力が rq の容量（queue_size）に達したら、それ以上は受け付けない
+	if n := s.Len(); n != 2 {
+		t.Fatalf("unexpected pending count: %d", n)
+	}
+	if ret := <-exitCode; ret != exitRejected {
+		t.Fatalf("unexpected exit code: %d", ret)
+	}
+	if out := <-wq; !out.Queued {
+		t.Fatalf("expected the accepted input to be queued, got %+v", out)
+	}
+	if out := <-wq; !out.QueueFull || !out.IsErrOut || out.Text != QueueFullMessage {
+		t.Fatalf("unexpected output: %+v", out)
+	}
+}
+
+func TestExecutorWithSchedulerStopsWhenClosed(t *testing.T) {
+	s, wq := newTestScheduler(&Definition{Keyword: "date", Command: "date"})
+	rq := make(chan *CommandInput)
+	close(rq)
+	s.Run(context.Background(), rq)
+	done := make(chan struct{})
+	go func() {
+		ExecutorWithScheduler(context.Background(), s, wq)
+		close(done)
+	}()
+	<-done
+}
+
+func TestSchedulerCancelBySourceKey(t *testing.T) {
+	s, wq := newTestScheduler(&Definition{Keyword: "build", Command: "build.sh", MaxConcurrency: 1})
+	s.admit(&CommandInput{Text: "build", SourceKey: "C1/1.0"})
+	running := tryNext(t, s)
+	if running == nil {
+		t.Fatal("expected build to run")
+	}
+	exitCode := make(chan int, 1)
+	waiting, _, position := s.admit(&CommandInput{
+		Text:      "build",
+		SourceKey: "C1/2.0",
+		Done:      func(ret int) { exitCode <- ret },
+	})
+	if position == 0 {
+		t.Fatal("expected the second build to wait")
+	}
+	s.notifyQueued(waiting, position)
+	if out := <-wq; !out.Queued {
+		t.Fatalf("expected queued output, got %+v", out)
+	}
+	s.admit(&CommandInput{Text: "build", SourceKey: "C1/3.0"})
+
+	if n := s.Cancel(""); n != 0 {
+		t.Fatalf("empty key must not cancel anything: %d", n)
+	}
+	// 待機中の入力は実行せずに終える
+	if n := s.Cancel("C1/2.0"); n != 1 {
+		t.Fatalf("unexpected cancelled count: %d", n)
+	}
+	if ret := <-exitCode; ret != exitCancelled {
+		t.Fatalf("unexpected exit code: %d", ret)
+	}
+	// 待ち順の表示を消す
+	if out := <-wq; !out.Dequeued {
+		t.Fatalf("expected dequeued output, got %+v", out)
+	}
+	if len(wq) != 0 {
+		t.Fatalf("unexpected output: %+v", <-wq)
+	}
+	if s.Len() != 1 {
+		t.Fatalf("unexpected pending count: %d", s.Len())
+	}
+	// 実行中の入力はキャンセルする
+	if n := s.Cancel("C1/1.0"); n != 1 || running.ctx.Err() == nil {
+		t.Fatalf("expected the running input to be cancelled: %d", n)
+	}
+}
+
+func TestSchedulerSkipsQueuedNoticeAfterStart(t *testing.T) {
+	s, wq := newTestScheduler(&Definition{Keyword: "date", Command: "date"})
+	item, _, position := s.admit(&CommandInput{Text: "date"})
+	if position == 0 {
+		t.Fatal("expected the input to wait")
+	}
+	// 通知する前にワーカーが実行を始めた場合は、待ち順を表示しない
+	if tryNext(t, s) != item {
+		t.Fatal("expected the input to run")
+	}
+	s.notifyQueued(item, position)
+	if len(wq) != 0 {
+		t.Fatalf("unexpected output: %+v", <-wq)
+	}
+}
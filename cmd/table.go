@@ -0,0 +1,59 @@
+package cmd
+
+import "sync/atomic"
+
+// CommandTable はキーワードとコマンド定義の対応表を保持する。
+// 設定の再読み込み時に Update で丸ごと差し替える。実行中のコマンドは差し替え前の定義のまま終了する。
+type CommandTable struct {
+	runnerFactory RunnerFactory
+	matchers      atomic.Pointer[[]*Matcher]
+}
+
+// NewCommandTable builds a table from cfgs using runners provided by runnerFactory.
+func NewCommandTable(cfgs []*CommandConfig, runnerFactory RunnerFactory) *CommandTable {
+	t := &CommandTable{runnerFactory: normalizeRunnerFactory(runnerFactory)}
+	t.Update(cfgs)
+	return t
+}
+
+// Update atomically replaces the command definitions.
+func (t *CommandTable) Update(cfgs []*CommandConfig) {
+	matchers := buildMatchers(cfgs, t.runnerFactory)
+	t.matchers.Store(&matchers)
+}
+
+func (t *CommandTable) load() []*Matcher {
+	return *t.matchers.Load()
+}
+
+// Keywords returns the keyword of the command definition that each command in text
+// matches, or an empty string for a command that matches none.
+func (t *CommandTable) Keywords(text string) ([]string, error) {
+	cmdMsg, _ := splitCommandInput(text)
+	cmds, err := parseCommands(cmdMsg)
+	if err != nil {
+		return nil, err
+	}
+	matchers := t.load()
+	keywords := make([]string, 0, len(cmds))
+	for _, c := range cmds {
+		m, _ := findMatchedMatcher(c, matchers)
+		if m == nil {
+			keywords = append(keywords, "")
+			continue
+		}
+		keywords = append(keywords, m.cfg.Keyword)
+	}
+	return keywords, nil
+}
+
+// Definitions returns the distinct command definitions that input would run,
+// honoring input.AllowedCommands.
+func (t *CommandTable) Definitions(input *CommandInput) []*Definition {
+	uses := usedMatchers(input, t.load())
+	defs := make([]*Definition, 0, len(uses))
+	for _, m := range uses {
+		defs = append(defs, m.cfg.Definition)
+	}
+	return defs
+}
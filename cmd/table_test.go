@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitTestRunner は release が閉じられるまでブロックしてから引数を出力するテスト用ランナー
+type waitTestRunner struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r *waitTestRunner) CommandContext(_ context.Context, _ string, arg ...string) Cmd {
+	return &waitTestCmd{runner: r, args: arg}
+}
+
+type waitTestCmd struct {
+	runner *waitTestRunner
+	args   []string
+	stdout io.Writer
+}
+
+func (c *waitTestCmd) SetStdin(io.Reader)    {}
+func (c *waitTestCmd) SetStdout(w io.Writer) { c.stdout = w }
+func (c *waitTestCmd) SetStderr(_ io.Writer) {}
+func (c *waitTestCmd) Run(_ int) int {
+	c.runner.started <- struct{}{}
+	<-c.runner.release
+	_, _ = fmt.Fprintln(c.stdout, strings.Join(c.args, " "))
+	return 0
+}
+
+func TestCommandTableUpdateKeepsRunningJob(t *testing.T) {
+	runner := &waitTestRunner{started: make(chan struct{}, 2), release: make(chan struct{})}
+	table := NewCommandTable([]*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "greet", Command: "wait old"}, nil),
+	}, func(*CommandConfig) CommandRunner { return runner })
+
+	rq := make(chan *CommandInput, 2)
+	wq := make(chan *CommandOutput, 20)
+	done := make(chan struct{})
+	go func() {
+		ExecutorWithTable(context.Background(), rq, wq, table)
+		close(done)
+	}()
+	rq <- &CommandInput{Text: "greet"}
+	<-runner.started
+	// 実行中に定義を差し替える
+	table.Update([]*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "greet", Command: "wait new"}, nil),
+	})
+	close(runner.release)
+	rq <- &CommandInput{Text: "greet"}
+	close(rq)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("executor did not finish")
+	}
+	got := collectText(drainOutputs(wq), false)
+	if got != "old\nnew\n" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+}
+
+func TestCommandTableKeywords(t *testing.T) {
+	table := NewCommandTable([]*CommandConfig{
+		NewCommandConfig(&Definition{Keyword: "deploy *", Command: "deploy.sh"}, nil),
+		NewCommandConfig(&Definition{Keyword: "date", Command: "date"}, nil),
+	}, nil)
+
+	got, err := table.Keywords("deploy web && date | unknown\nstdin text")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []string{"deploy *", "date", ""}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestExecutorRestrictsAllowedCommands(t *testing.T) {
+	runner := &fakeRunner{}
+	table := NewCommandTable(testCommandConfigs(), func(*CommandConfig) CommandRunner { return runner })
+	rq := make(chan *CommandInput, 2)
+	wq := make(chan *CommandOutput, 20)
+	done := make(chan struct{})
+	go func() {
+		ExecutorWithTable(context.Background(), rq, wq, table)
+		close(done)
+	}()
+	rq <- &CommandInput{Text: "echo denied", AllowedCommands: []string{"date"}}
+	rq <- &CommandInput{Text: "echo allowed", AllowedCommands: []string{"echo *"}}
+	close(rq)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("executor did not finish")
+	}
+	calls := runner.Calls()
+	if len(calls) != 1 || strings.Join(calls[0].args, " ") != "allowed" {
+		t.Fatalf("expected only the allowed command to run, got %+v", calls)
+	}
+}
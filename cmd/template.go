@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs はテンプレート内で使える関数群
+var templateFuncs = template.FuncMap{
+	// json は値をJSONエンコードした文字列を返す（文字列なら引用符付きでエスケープされる）
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	},
+}
+
+func renderTemplate(name, text string, data interface{}) (string, error) {
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
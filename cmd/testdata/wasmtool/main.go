@@ -0,0 +1,32 @@
+//go:build wasip1
+
+// wasmtool is a small WASI program used by the wasm runner tests.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		return
+	}
+	switch args[0] {
+	case "echo":
+		fmt.Println(strings.Join(args[1:], " "))
+	case "cat":
+		_, _ = io.Copy(os.Stdout, os.Stdin)
+	case "exit":
+		code, _ := strconv.Atoi(args[1])
+		fmt.Fprintln(os.Stderr, "exiting")
+		os.Exit(code)
+	case "loop":
+		for {
+		}
+	}
+}
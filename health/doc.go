@@ -0,0 +1,3 @@
+// Package health tracks the state of the Slack connection and executor workers
+// and serves /healthz and /readyz for liveness and readiness probes.
+package health
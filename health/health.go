@@ -0,0 +1,146 @@
+package health
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultLivenessWindow は liveness_window 未指定時の値
+const DefaultLivenessWindow = 2 * time.Minute
+
+// State はSocket Modeの接続状態とExecutorの稼働状況を保持する。
+// nilの *State に対する呼び出しは何もしない。
+type State struct {
+	mu              sync.Mutex
+	connected       bool
+	authOK          bool
+	lastActivity    time.Time
+	workers         int
+	expectedWorkers int
+	livenessWindow  time.Duration
+	now             func() time.Time
+}
+
+// New returns a State expecting numWorkers executor workers.
+// Liveness fails if no Socket Mode ping or event arrives within livenessWindow.
+func New(numWorkers int, livenessWindow time.Duration) *State {
+	if livenessWindow <= 0 {
+		livenessWindow = DefaultLivenessWindow
+	}
+	s := &State{
+		expectedWorkers: numWorkers,
+		livenessWindow:  livenessWindow,
+		now:             time.Now,
+	}
+	// 起動直後は接続完了までの猶予として起動時刻を最終受信時刻とする
+	s.lastActivity = s.now()
+	return s
+}
+
+// Connected records that the Socket Mode connection was established.
+func (s *State) Connected() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = true
+	s.lastActivity = s.now()
+}
+
+// Disconnected records that the Socket Mode connection was lost.
+func (s *State) Disconnected() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected = false
+}
+
+// AuthTested records the result of AuthTest.
+func (s *State) AuthTested(ok bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authOK = ok
+}
+
+// Activity records that a Socket Mode ping or event arrived.
+func (s *State) Activity() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastActivity = s.now()
+}
+
+// WorkerStarted records that an executor worker started.
+func (s *State) WorkerStarted() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers++
+}
+
+// WorkerStopped records that an executor worker stopped.
+func (s *State) WorkerStopped() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.workers--
+}
+
+// Live returns an error if no ping or event arrived within the liveness window.
+func (s *State) Live() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elapsed := s.now().Sub(s.lastActivity); elapsed > s.livenessWindow {
+		return fmt.Errorf("no Socket Mode activity for %s", elapsed.Truncate(time.Second))
+	}
+	return nil
+}
+
+// Ready returns an error unless the bot is connected, authenticated and
+// all executor workers are running.
+func (s *State) Ready() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case !s.connected:
+		return errors.New("not connected to Slack")
+	case !s.authOK:
+		return errors.New("auth.test has not succeeded")
+	case s.workers < s.expectedWorkers:
+		return fmt.Errorf("%d of %d executor workers running", s.workers, s.expectedWorkers)
+	}
+	return nil
+}
+
+// Register adds /healthz and /readyz handlers to mux.
+func (s *State) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", probeHandler(s.Live))
+	mux.HandleFunc("/readyz", probeHandler(s.Ready))
+}
+
+func probeHandler(check func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := check(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintln(w, err)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+}
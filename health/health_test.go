@@ -0,0 +1,89 @@
+package health
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestState(workers int) (*State, *time.Time) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := New(workers, time.Minute)
+	s.now = func() time.Time { return now }
+	s.lastActivity = now
+	return s, &now
+}
+
+func TestReady(t *testing.T) {
+	s, _ := newTestState(2)
+	if err := s.Ready(); err == nil {
+		t.Fatal("expected not ready before connecting")
+	}
+	s.Connected()
+	if err := s.Ready(); err == nil {
+		t.Fatal("expected not ready before auth.test")
+	}
+	s.AuthTested(true)
+	s.WorkerStarted()
+	if err := s.Ready(); err == nil {
+		t.Fatal("expected not ready while a worker is missing")
+	}
+	s.WorkerStarted()
+	if err := s.Ready(); err != nil {
+		t.Fatalf("expected ready, got %v", err)
+	}
+	s.Disconnected()
+	if err := s.Ready(); err == nil {
+		t.Fatal("expected not ready after disconnect")
+	}
+}
+
+func TestLive(t *testing.T) {
+	s, now := newTestState(1)
+	*now = now.Add(50 * time.Second)
+	if err := s.Live(); err != nil {
+		t.Fatalf("expected live within the window, got %v", err)
+	}
+	*now = now.Add(20 * time.Second)
+	if err := s.Live(); err == nil {
+		t.Fatal("expected liveness failure after the window")
+	}
+	s.Activity()
+	if err := s.Live(); err != nil {
+		t.Fatalf("expected live after activity, got %v", err)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	s, _ := newTestState(0)
+	mux := http.NewServeMux()
+	s.Register(mux)
+
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	if code := get("/healthz"); code != http.StatusOK {
+		t.Fatalf("/healthz = %d", code)
+	}
+	if code := get("/readyz"); code != http.StatusServiceUnavailable {
+		t.Fatalf("/readyz = %d before connecting", code)
+	}
+	s.Connected()
+	s.AuthTested(true)
+	if code := get("/readyz"); code != http.StatusOK {
+		t.Fatalf("/readyz = %d after connecting", code)
+	}
+}
+
+func TestNilStateIsNoop(t *testing.T) {
+	var s *State
+	s.Connected()
+	s.Disconnected()
+	s.AuthTested(true)
+	s.Activity()
+	s.WorkerStarted()
+	s.WorkerStopped()
+}
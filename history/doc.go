@@ -0,0 +1,3 @@
+// Package history stores executed jobs in an embedded bbolt database
+// and provides the built-in history/job commands.
+package history
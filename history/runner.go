@@ -0,0 +1,169 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+const (
+	defaultHistoryCount = 10
+	maxHistoryCount     = 50
+)
+
+// Definitions returns the built-in command definitions served by NewRunner.
+func Definitions() []*cmd.Definition {
+	return []*cmd.Definition{
+		{Keyword: "history *", Command: "history *", Runner: "history"},
+		{Keyword: "job *", Command: "job *", Runner: "history"},
+	}
+}
+
+type runner struct {
+	store *Store
+}
+
+// NewRunner returns a runner implementing the built-in `history [n]`
+// and `job <id>` commands.
+func NewRunner(store *Store) cmd.CommandRunner {
+	return &runner{store: store}
+}
+
+func (r *runner) CommandContext(_ context.Context, name string, arg ...string) cmd.Cmd {
+	return &historyCmd{store: r.store, name: name, args: arg}
+}
+
+type historyCmd struct {
+	store  *Store
+	name   string
+	args   []string
+	input  *cmd.CommandInput
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (c *historyCmd) SetStdin(_ io.Reader) {}
+
+func (c *historyCmd) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *historyCmd) SetStderr(w io.Writer) {
+	c.stderr = w
+}
+
+func (c *historyCmd) SetInput(input *cmd.CommandInput) {
+	c.input = input
+}
+
+func (c *historyCmd) Run(_ int) int {
+	var err error
+	switch c.name {
+	case "history":
+		err = c.runHistory()
+	case "job":
+		err = c.runJob()
+	default:
+		err = fmt.Errorf("unknown command: %s", c.name)
+	}
+	if err != nil {
+		if c.stderr != nil {
+			_, _ = fmt.Fprintf(c.stderr, "%v", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// channelID は参照できるジョブを発言されたチャンネルのものに限定するために使う
+func (c *historyCmd) channelID() string {
+	if c.input == nil {
+		return ""
+	}
+	return c.input.ChannelID
+}
+
+func (c *historyCmd) runHistory() error {
+	n := defaultHistoryCount
+	if len(c.args) > 1 {
+		return errors.New("usage: history [n]")
+	}
+	if len(c.args) == 1 {
+		v, err := strconv.Atoi(c.args[0])
+		if err != nil || v < 1 {
+			return errors.New("usage: history [n]")
+		}
+		n = min(v, maxHistoryCount)
+	}
+	jobs, err := c.store.Recent(n, c.channelID())
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		_, _ = io.WriteString(c.stdout, "No jobs.")
+		return nil
+	}
+	var sb strings.Builder
+	for _, job := range jobs {
+		sb.WriteString(summary(job))
+		sb.WriteString("\n")
+	}
+	_, _ = io.WriteString(c.stdout, sb.String())
+	return nil
+}
+
+func (c *historyCmd) runJob() error {
+	if len(c.args) != 1 {
+		return errors.New("usage: job <id>")
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(c.args[0], "#"), 10, 64)
+	if err != nil {
+		return errors.New("usage: job <id>")
+	}
+	job, err := c.store.Job(id)
+	if err == nil && c.channelID() != "" && job.ChannelID != c.channelID() {
+		err = ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("job #%d: %w", id, err)
+	}
+	var sb strings.Builder
+	sb.WriteString(summary(job))
+	sb.WriteString("\n")
+	fmt.Fprintf(&sb, "keyword: %s (runner: %s)\n", job.Keyword, job.Runner)
+	if job.Output != "" {
+		sb.WriteString(job.Output)
+	}
+	_, _ = io.WriteString(c.stdout, sb.String())
+	return nil
+}
+
+func summary(job *cmd.Job) string {
+	status := "running"
+	if job.Finished {
+		status = fmt.Sprintf("exit %d", job.ExitCode)
+	}
+	user := job.UserID
+	if user != "" {
+		user = "<@" + user + "> "
+	}
+	return fmt.Sprintf("#%d %s %s%s (%s, %s)",
+		job.ID,
+		job.StartedAt.Local().Format("2006-01-02 15:04:05"),
+		user,
+		firstLine(job.Text),
+		status,
+		job.Duration().Round(100*time.Millisecond),
+	)
+}
+
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(text, "\n")
+	return line
+}
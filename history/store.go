@@ -0,0 +1,164 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+var jobsBucket = []byte("jobs")
+
+// ErrNotFound is returned when the requested job does not exist.
+var ErrNotFound = errors.New("job not found")
+
+// RetentionPolicy は古いジョブの記録を削除する条件
+type RetentionPolicy struct {
+	MaxAge  time.Duration // 0なら期間では削除しない
+	MaxJobs int           // 0なら件数では削除しない
+}
+
+// Store はジョブの実行記録をbboltに保存する。cmd.JobStore を実装する。
+type Store struct {
+	db        *bolt.DB
+	retention RetentionPolicy
+	now       func() time.Time
+}
+
+// Open opens (or creates) the history database at path.
+func Open(path string, retention RetentionPolicy) (*Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(jobsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Store{db: db, retention: retention, now: time.Now}, nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// CreateJob stores a new job and assigns job.ID.
+// Old jobs are pruned according to the retention policy.
+func (s *Store) CreateJob(job *cmd.Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(jobsBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		job.ID = id
+		if err := putJob(b, job); err != nil {
+			return err
+		}
+		return s.prune(b)
+	})
+}
+
+// FinishJob updates the job with its result.
+func (s *Store) FinishJob(job *cmd.Job) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putJob(tx.Bucket(jobsBucket), job)
+	})
+}
+
+// Job returns the job with the given ID.
+func (s *Store) Job(id uint64) (*cmd.Job, error) {
+	var job *cmd.Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(jobsBucket).Get(itob(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		job = &cmd.Job{}
+		return json.Unmarshal(data, job)
+	})
+	return job, err
+}
+
+// Recent returns up to n most recent jobs, newest first.
+// If channelID is not empty, only jobs run in that channel are returned.
+func (s *Store) Recent(n int, channelID string) ([]*cmd.Job, error) {
+	jobs := make([]*cmd.Job, 0, n)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(jobsBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(jobs) < n; k, v = c.Prev() {
+			job := &cmd.Job{}
+			if err := json.Unmarshal(v, job); err != nil {
+				return err
+			}
+			if channelID != "" && job.ChannelID != channelID {
+				continue
+			}
+			jobs = append(jobs, job)
+		}
+		return nil
+	})
+	return jobs, err
+}
+
+// prune は保持ポリシーを超えた古いジョブを削除する
+func (s *Store) prune(b *bolt.Bucket) error {
+	c := b.Cursor()
+	excess := 0
+	if s.retention.MaxJobs > 0 {
+		// 削除は常に古い方から行うので、IDは連番のまま保たれる
+		first, _ := c.First()
+		last, _ := c.Last()
+		if first != nil {
+			count := int(binary.BigEndian.Uint64(last) - binary.BigEndian.Uint64(first) + 1)
+			excess = count - s.retention.MaxJobs
+		}
+	}
+	var deadline time.Time
+	if s.retention.MaxAge > 0 {
+		deadline = s.now().Add(-s.retention.MaxAge)
+	}
+	for k, v := c.First(); k != nil; k, v = c.First() {
+		if excess <= 0 {
+			if deadline.IsZero() {
+				return nil
+			}
+			var job cmd.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if !job.StartedAt.Before(deadline) {
+				return nil
+			}
+		}
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		excess--
+	}
+	return nil
+}
+
+func putJob(b *bolt.Bucket, job *cmd.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.Put(itob(job.ID), data)
+}
+
+// itob はキーの順序がIDの順序になるようにビッグエンディアンで符号化する
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,104 @@
+package history
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+func openTestStore(t *testing.T, retention RetentionPolicy) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "history.db"), retention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func TestStoreCreateAndFinish(t *testing.T) {
+	s := openTestStore(t, RetentionPolicy{})
+	job := &cmd.Job{UserID: "U1", ChannelID: "C1", Text: "uptime", StartedAt: time.Now()}
+	if err := s.CreateJob(job); err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != 1 {
+		t.Fatalf("expected ID 1, got %d", job.ID)
+	}
+	job.Finished = true
+	job.ExitCode = 2
+	job.Output = "out"
+	if err := s.FinishJob(job); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Job(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Finished || got.ExitCode != 2 || got.Output != "out" || got.Text != "uptime" {
+		t.Fatalf("unexpected job: %+v", got)
+	}
+	if _, err := s.Job(2); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreRecentFiltersByChannel(t *testing.T) {
+	s := openTestStore(t, RetentionPolicy{})
+	for _, ch := range []string{"C1", "C2", "C1", "C1"} {
+		if err := s.CreateJob(&cmd.Job{ChannelID: ch, StartedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := s.Recent(2, "C1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != 4 || jobs[1].ID != 3 {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+	jobs, err = s.Recent(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 4 {
+		t.Fatalf("expected 4 jobs, got %d", len(jobs))
+	}
+}
+
+func TestStorePrunesByCount(t *testing.T) {
+	s := openTestStore(t, RetentionPolicy{MaxJobs: 2})
+	for i := 0; i < 5; i++ {
+		if err := s.CreateJob(&cmd.Job{StartedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := s.Recent(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 2 || jobs[0].ID != 5 || jobs[1].ID != 4 {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+}
+
+func TestStorePrunesByAge(t *testing.T) {
+	s := openTestStore(t, RetentionPolicy{MaxAge: time.Hour})
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	for _, started := range []time.Time{now.Add(-3 * time.Hour), now.Add(-2 * time.Hour), now.Add(-time.Minute)} {
+		if err := s.CreateJob(&cmd.Job{StartedAt: started}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	jobs, err := s.Recent(10, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].ID != 3 {
+		t.Fatalf("unexpected jobs: %+v", jobs)
+	}
+}
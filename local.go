@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/local"
+)
+
+// runLocal はSlackに接続せず、標準入力から読んだコマンドを実行して結果を標準出力に書き出す。
+// 標準入力がEOFになると、実行中のコマンドの終了を待って戻る。
+func runLocal(
+	numWorkers int,
+	table *cmd.CommandTable,
+	executorOpts []cmd.ExecutorOption,
+	delimiter string,
+	errorf func(template string, args ...interface{}),
+) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	commandQueue := make(chan *cmd.CommandInput, numWorkers)
+	outputQueue := make(chan *cmd.CommandOutput, numWorkers)
+	var executorWG sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		executorWG.Add(1)
+		go func() {
+			defer executorWG.Done()
+			cmd.ExecutorWithTable(ctx, commandQueue, outputQueue, table, executorOpts...)
+		}()
+	}
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		local.Writer(os.Stdout, outputQueue, os.Getenv("NO_COLOR") == "")
+	}()
+	if err := local.Listener(ctx, os.Stdin, commandQueue, delimiter); err != nil &&
+		ctx.Err() == nil {
+		errorf("%v", err)
+	}
+	close(commandQueue)
+	executorWG.Wait()
+	close(outputQueue)
+	<-writerDone
+}
@@ -0,0 +1,3 @@
+// Package local provides a terminal front end that reads commands from stdin
+// and renders their output, so commands can be developed without Slack.
+package local
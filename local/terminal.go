@@ -0,0 +1,148 @@
+package local
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sixel"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// SenderID と ChannelID はローカルモードの入力に設定するID
+const (
+	SenderID  = "local"
+	ChannelID = "local"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorStatus = "\x1b[2m" // 薄い表示
+)
+
+// Reply はローカルモードの入力を識別する cmd.CommandInput.ReplyInfo
+type Reply struct {
+	Seq int64 // 何番目の入力か（1始まり）
+}
+
+// Listener はrから読んだ行を CommandInput として commandQueue に投入する。
+// delimiter だけの行で囲んだ部分は複数行の入力として扱う（1行目がコマンド、2行目以降が標準入力）。
+// rがEOFになるかctxが終了すると戻る。
+func Listener(
+	ctx context.Context,
+	r io.Reader,
+	commandQueue chan *cmd.CommandInput,
+	delimiter string,
+) error {
+	var seq atomic.Int64
+	enqueue := func(text string) bool {
+		if strings.TrimSpace(text) == "" {
+			return true
+		}
+		input := &cmd.CommandInput{
+			ReplyInfo:  &Reply{Seq: seq.Add(1)},
+			Text:       text,
+			SenderID:   SenderID,
+			ChannelID:  ChannelID,
+			EnqueuedAt: time.Now(),
+		}
+		select {
+		case commandQueue <- input:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	var block []string
+	inBlock := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case delimiter != "" && line == delimiter && !inBlock:
+			inBlock = true
+			block = block[:0]
+			continue
+		case delimiter != "" && line == delimiter:
+			inBlock = false
+			line = strings.Join(block, "\n")
+		case inBlock:
+			block = append(block, line)
+			continue
+		}
+		if !enqueue(line) {
+			return ctx.Err()
+		}
+	}
+	return scanner.Err()
+}
+
+// Writer は outputQueue から来たコマンド実行結果をwに書き出す。outputQueueがcloseされると戻る。
+// color が true ならエラー出力と状態表示に色を付ける。
+func Writer(w io.Writer, outputQueue chan *cmd.CommandOutput, color bool) {
+	for output := range outputQueue {
+		writeOutput(w, output, color)
+	}
+}
+
+func writeOutput(w io.Writer, output *cmd.CommandOutput, color bool) {
+	prefix := ""
+	if r, ok := output.ReplyInfo.(*Reply); ok {
+		prefix = fmt.Sprintf("[#%d] ", r.Seq)
+	}
+	switch {
+	case output.Spawned:
+		writeColored(w, color, colorStatus, prefix+"started\n")
+	case output.Finished:
+		status := fmt.Sprintf("%sfinished (exit %d", prefix, output.ExitCode)
+		if output.JobID != 0 {
+			status += fmt.Sprintf(", job #%d", output.JobID)
+		}
+		writeColored(w, color, colorStatus, status+")\n")
+	}
+	if output.Text != "" {
+		text := output.Text
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		if output.IsErrOut {
+			writeColored(w, color, colorRed, text)
+		} else {
+			_, _ = io.WriteString(w, text)
+		}
+	}
+	if output.ImageData != nil {
+		writeImage(w, output.ImageData)
+	}
+	if len(output.Blocks) > 0 {
+		_, _ = fmt.Fprintf(w, "%s\n", output.Blocks)
+	}
+}
+
+func writeColored(w io.Writer, color bool, code, text string) {
+	if !color {
+		_, _ = io.WriteString(w, text)
+		return
+	}
+	_, _ = io.WriteString(w, code+text+colorReset)
+}
+
+// writeImage はPNGに変換されたsixel出力をsixelに戻して端末に出力する
+func writeImage(w io.Writer, pngData []byte) {
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "[image: %v]\n", err)
+		return
+	}
+	if err := sixel.NewEncoder(w).Encode(img); err != nil {
+		_, _ = fmt.Fprintf(w, "[image: %v]\n", err)
+	}
+}
@@ -0,0 +1,48 @@
+package local
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+func TestListenerReadsLinesAndBlocks(t *testing.T) {
+	in := strings.NewReader("echo hi\n\n---\ncat\nline1\nline2\n---\ndate\n")
+	q := make(chan *cmd.CommandInput, 10)
+	if err := Listener(context.Background(), in, q, "---"); err != nil {
+		t.Fatal(err)
+	}
+	close(q)
+	var texts []string
+	for input := range q {
+		texts = append(texts, input.Text)
+		if input.SenderID != SenderID || input.ChannelID != ChannelID {
+			t.Errorf("unexpected ids: %s %s", input.SenderID, input.ChannelID)
+		}
+	}
+	want := []string{"echo hi", "cat\nline1\nline2", "date"}
+	if strings.Join(texts, "|") != strings.Join(want, "|") {
+		t.Fatalf("unexpected inputs: %q", texts)
+	}
+}
+
+func TestWriterRendersOutputs(t *testing.T) {
+	q := make(chan *cmd.CommandOutput, 10)
+	reply := &Reply{Seq: 3}
+	q <- &cmd.CommandOutput{ReplyInfo: reply, Spawned: true}
+	q <- &cmd.CommandOutput{ReplyInfo: reply, Text: "out"}
+	q <- &cmd.CommandOutput{ReplyInfo: reply, Text: "err\n", IsErrOut: true}
+	q <- &cmd.CommandOutput{ReplyInfo: reply, Finished: true, ExitCode: 1, JobID: 7}
+	close(q)
+	var sb strings.Builder
+	Writer(&sb, q, true)
+	want := colorStatus + "[#3] started\n" + colorReset +
+		"out\n" +
+		colorRed + "err\n" + colorReset +
+		colorStatus + "[#3] finished (exit 1, job #7)\n" + colorReset
+	if sb.String() != want {
+		t.Fatalf("unexpected output: %q", sb.String())
+	}
+}
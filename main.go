@@ -6,11 +6,14 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -19,16 +22,57 @@ import (
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/socketmode"
 
+	"github.com/hnw/slack-commander/api"
+	"github.com/hnw/slack-commander/audit"
 	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/health"
+	"github.com/hnw/slack-commander/history"
+	"github.com/hnw/slack-commander/metrics"
 	"github.com/hnw/slack-commander/pubsub"
+	"github.com/hnw/slack-commander/ratelimit"
+	"github.com/hnw/slack-commander/schedule"
+	"github.com/hnw/slack-commander/tracing"
 )
 
 type PubSubConfig = pubsub.Config // TOMLデコード対象のためexportedにする
 
+// transport に指定できる値
+const (
+	transportSlack      = "slack"
+	transportMattermost = "mattermost"
+	transportDiscord    = "discord"
+)
+
+// Slackからイベントを受け取る方法
+const (
+	slackModeSocket = "socket"
+	slackModeHTTP   = "http"
+)
+
+// defaultQueueSize は queue_size を省略した場合に実行を待てるコマンドの数
+const defaultQueueSize = 50
+
 type Config struct {
 	PubSubConfig
-	NumWorkers int `toml:"num_workers"`
-	Commands   []*CommandConfig
+	Transport            string                  `toml:"transport"`
+	Mattermost           pubsub.MattermostConfig `toml:"mattermost"`
+	Discord              pubsub.DiscordConfig    `toml:"discord"`
+	NumWorkers           int                     `toml:"num_workers"`
+	QueueSize            int                     `toml:"queue_size"` // 実行を待てるコマンドの数
+	HistoryFile          string                  `toml:"history_file"`
+	HistoryRetentionDays int                     `toml:"history_retention_days"`
+	HistoryMaxJobs       int                     `toml:"history_max_jobs"`
+	MetricsAddr          string                  `toml:"metrics_addr"`
+	HealthAddr           string                  `toml:"health_addr"`
+	HealthLivenessWindow int                     `toml:"health_liveness_window"`
+	ConfigWatchInterval  int                     `toml:"config_watch_interval"`
+	API                  api.Config              `toml:"api"`
+	RateLimit            ratelimit.Config        `toml:"rate_limit"`
+	Audit                audit.Config            `toml:"audit"`
+	Tracing              tracing.Config          `toml:"tracing"`
+	Workspaces           []*WorkspaceConfig      `toml:"workspaces"`
+	Commands             []*CommandConfig
+	Schedules            []*schedule.Config
 }
 
 type CommandConfig struct {
@@ -37,11 +81,16 @@ type CommandConfig struct {
 }
 
 func main() {
+	if exitCode, ok := runSubcommand(os.Args[1:], os.Stdout, os.Stderr); ok {
+		os.Exit(exitCode)
+	}
 	var (
 		quiet      = flag.Bool("q", false, "Quiet mode")
 		configFile = flag.String("config-file", "config.toml", "Specify configuration file")
 		verbose    = flag.Bool("v", false, "Verbose mode")
 		debug      = flag.Bool("debug", false, "Debug mode") // slack-go/slackのdebug mode
+		localMode  = flag.Bool("local", false, "Read commands from stdin instead of Slack")
+		delimiter  = flag.String("local-delimiter", `"""`, "Line that starts and ends a multi-line input in local mode")
 	)
 	flag.Parse()
 
@@ -76,77 +125,285 @@ func main() {
 		sugar.Errorf("%v", err)
 		return
 	}
-	cfg := Config{NumWorkers: 1}
-	if _, err := toml.DecodeFile(*configFile, &cfg); err != nil {
-		sugar.Errorf("%v", err)
-		return
+	load := loadConfig
+	if *localMode {
+		load = loadLocalConfig
 	}
-	if err := validateConfig(&cfg); err != nil {
+	cfg, err := load(*configFile)
+	if err != nil {
 		sugar.Fatalf("Fatal: %v", err)
 	}
 
-	// 構造体の詰め替え（TOMLライブラリの都合とパッケージ分割の都合）
-	cmdConfig := make([]*cmd.CommandConfig, len(cfg.Commands))
-	for i, c := range cfg.Commands {
-		cmdConfig[i] = cmd.NewCommandConfig(&c.Definition, &c.ReplyConfig)
+	builtins := map[string]cmd.CommandRunner{}
+	var builtinDefs []*cmd.Definition
+	var executorOpts []cmd.ExecutorOption
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		sugar.Fatalf("Fatal: %v", err)
 	}
-
-	api := slack.New(
-		cfg.SlackBotToken,
-		slack.OptionDebug(*debug),
-		slack.OptionLog(stdLogger),
-		slack.OptionAppLevelToken(cfg.SlackAppToken),
-	)
-	smc := socketmode.New(
-		api,
-		socketmode.OptionDebug(*debug),
-		socketmode.OptionLog(stdLogger),
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if shutdownErr := shutdownTracing(shutdownCtx); shutdownErr != nil {
+			sugar.Errorf("%v", shutdownErr)
+		}
+	}()
+	auditLogger, err := audit.New(cfg.Audit, sugar.Errorf)
+	if err != nil {
+		sugar.Fatalf("Fatal: %v", err)
+	}
+	defer func() {
+		if closeErr := auditLogger.Close(); closeErr != nil {
+			sugar.Errorf("%v", closeErr)
+		}
+	}()
+	executorOpts = append(executorOpts, cmd.WithAuditLogger(auditLogger))
+	if cfg.HistoryFile != "" {
+		store, err := history.Open(cfg.HistoryFile, history.RetentionPolicy{
+			MaxAge:  time.Duration(cfg.HistoryRetentionDays) * 24 * time.Hour,
+			MaxJobs: cfg.HistoryMaxJobs,
+		})
+		if err != nil {
+			sugar.Fatalf("Fatal: %v", err)
+		}
+		defer func() {
+			if closeErr := store.Close(); closeErr != nil {
+				sugar.Errorf("%v", closeErr)
+			}
+		}()
+		executorOpts = append(executorOpts, cmd.WithJobStore(store, sugar.Warnf))
+		builtins["history"] = history.NewRunner(store)
+		builtinDefs = append(builtinDefs, history.Definitions()...)
+	}
+	// コマンドごとの rate_limit はその時点のコマンドテーブルで調べる
+	var commandTable *cmd.CommandTable
+	rateLimiter := ratelimit.New(cfg.RateLimit, func(input *cmd.CommandInput) []*cmd.Definition {
+		return commandTable.Definitions(input)
+	})
+	if rateLimitConfigured(cfg) {
+		builtins["limits"] = ratelimit.NewRunner(rateLimiter)
+		builtinDefs = append(builtinDefs, ratelimit.Definitions()...)
+	}
+	commandTable = cmd.NewCommandTable(
+		buildCommandConfigs(cfg, builtinDefs),
+		newRunnerFactory(builtins),
 	)
+	if *localMode {
+		runLocal(cfg.NumWorkers, commandTable, executorOpts, *delimiter, sugar.Errorf)
+		return
+	}
+	listenerConfig := pubsub.NewConfigStore(cfg.PubSubConfig)
+
+	var healthState *health.State
+	if cfg.HealthAddr != "" {
+		healthState = health.New(
+			cfg.NumWorkers,
+			time.Duration(cfg.HealthLivenessWindow)*time.Second,
+		)
+	}
+	newSocketModeClient := func(botToken, appToken string) *socketmode.Client {
+		api := slack.New(
+			botToken,
+			slack.OptionDebug(*debug),
+			slack.OptionLog(stdLogger),
+			slack.OptionAppLevelToken(appToken),
+		)
+		if healthState == nil {
+			return socketmode.New(
+				api,
+				socketmode.OptionDebug(*debug),
+				socketmode.OptionLog(stdLogger),
+			)
+		}
+		// PINGの受信をデバッグログから検出するため、socketmodeのデバッグ出力を常に有効にする
+		return socketmode.New(
+			api,
+			socketmode.OptionDebug(true),
+			socketmode.OptionLog(
+				pubsub.NewPingObservingLogger(stdLogger, *debug, healthState.Activity),
+			),
+		)
+	}
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	// チャンネルの容量を大きめに取る。本来cfg.NumWorkersで問題ないはずだが、
-	// ack返せない問題への暫定対処。
-	commandQueue := make(chan *cmd.CommandInput, 50)
+	// commandQueue が一杯になるとリスナーはコマンドを受け付けずに発言者に知らせる
+	commandQueue := make(chan *cmd.CommandInput, cfg.QueueSize)
 	outputQueue := make(chan *cmd.CommandOutput, cfg.NumWorkers)
-	var composeRunnerOnce sync.Once
-	var composeRunner cmd.CommandRunner
-	runnerFactory := func(cfg *cmd.CommandConfig) cmd.CommandRunner {
-		if cfg.Runner == "compose" {
-			composeRunnerOnce.Do(func() {
-				composeRunner = cmd.NewComposeRunner("")
-			})
-			return composeRunner
-		}
-		if cfg.Runner == "http" {
-			return cmd.NewHTTPRunner(cfg)
+	// commandQueue の入力は max_concurrency と lock_group を守ってワーカーに渡す
+	commandScheduler := cmd.NewScheduler(commandTable, outputQueue)
+	scheduler, err := schedule.New(
+		cfg.Schedules,
+		commandQueue,
+		func(c *schedule.Config) interface{} {
+			// 定期実行の結果はチャンネルに新しいメッセージとしてポストする
+			var reply interface{} = &pubsub.ChannelReply{Channel: c.Channel}
+			if c.Workspace != "" {
+				reply = &pubsub.WorkspaceReply{Workspace: c.Workspace, ReplyInfo: reply}
+			}
+			return reply
+		},
+		sugar.Warnf,
+	)
+	if err != nil {
+		sugar.Fatalf("Fatal: %v", err)
+	}
+	// metrics_addr、health_addr、api.addr が同じ場合は1つのサーバーで提供する
+	muxes := map[string]*http.ServeMux{}
+	muxFor := func(addr string) *http.ServeMux {
+		if muxes[addr] == nil {
+			muxes[addr] = http.NewServeMux()
 		}
-		return cmd.NewExecRunner()
+		return muxes[addr]
+	}
+	if cfg.MetricsAddr != "" {
+		metrics.RegisterQueue("command", func() int { return len(commandQueue) + commandScheduler.Len() })
+		metrics.RegisterQueue("output", func() int { return len(outputQueue) })
+		muxFor(cfg.MetricsAddr).Handle("/metrics", metrics.Handler())
+	}
+	if healthState != nil {
+		healthState.Register(muxFor(cfg.HealthAddr))
+	}
+	var apiServer *api.Server
+	if cfg.API.Enabled() {
+		apiServer = api.New(cfg.API, commandTable, commandQueue, auditLogger, api.WithRateLimiter(rateLimiter))
+		apiServer.Register(muxFor(cfg.API.Addr))
+	}
+	var serverWG sync.WaitGroup
+	for addr, mux := range muxes {
+		serverWG.Add(1)
+		go func() {
+			defer serverWG.Done()
+			serveHTTP(ctx, addr, mux, sugar.Errorf)
+		}()
 	}
 	var executorWG sync.WaitGroup
+	executorWG.Add(1)
+	go func() {
+		defer executorWG.Done()
+		commandScheduler.Run(ctx, commandQueue)
+	}()
 	for i := 0; i < cfg.NumWorkers; i++ {
 		executorWG.Add(1)
 		go func() {
 			defer executorWG.Done()
-			cmd.ExecutorWithRunner(ctx, commandQueue, outputQueue, cmdConfig, runnerFactory)
+			healthState.WorkerStarted()
+			defer healthState.WorkerStopped()
+			cmd.ExecutorWithScheduler(ctx, commandScheduler, outputQueue, executorOpts...)
 		}()
 	}
+	baseListenerOpts := []pubsub.ListenerOption{
+		pubsub.WithAuditLogger(auditLogger),
+		pubsub.WithHealth(healthState),
+		pubsub.WithRateLimiter(rateLimiter),
+		pubsub.WithCanceler(commandScheduler),
+	}
+	newTransport := func(c *Config, opts ...pubsub.ListenerOption) pubsub.Transport {
+		opts = append(append([]pubsub.ListenerOption{}, baseListenerOpts...), opts...)
+		switch c.Transport {
+		case transportMattermost:
+			return pubsub.NewMattermostTransport(
+				c.Mattermost, c.PubSubConfig, sugar.Debugf, opts...)
+		case transportDiscord:
+			return pubsub.NewDiscordTransport(
+				c.Discord, c.PubSubConfig, sugar.Debugf, opts...)
+		}
+		if c.Mode == slackModeHTTP {
+			client := slack.New(
+				c.SlackBotToken,
+				slack.OptionDebug(*debug),
+				slack.OptionLog(stdLogger),
+			)
+			return pubsub.NewSlackHTTPTransport(client, c.PubSubConfig, opts...)
+		}
+		smc := newSocketModeClient(c.SlackBotToken, c.SlackAppToken)
+		return pubsub.NewSlackTransport(smc, c.PubSubConfig, opts...)
+	}
 	var writerWG sync.WaitGroup
-	writerWG.Add(1)
+	var listenerWG sync.WaitGroup
+	listenerWG.Add(1)
 	go func() {
-		defer writerWG.Done()
-		pubsub.SlackWriter(ctx, smc, outputQueue)
+		defer listenerWG.Done()
+		scheduler.Run(ctx)
 	}()
-	var listenerWG sync.WaitGroup
+
+	rl := &reloader{
+		path:             *configFile,
+		cfg:              cfg,
+		builtinDefs:      builtinDefs,
+		table:            commandTable,
+		listenerConfig:   listenerConfig,
+		rateLimiter:      rateLimiter,
+		apiServer:        apiServer,
+		workspaceConfigs: newWorkspaceConfigStores(cfg),
+		reconnect:        make(chan struct{}, 1),
+		infof:            sugar.Infof,
+		warnf:            sugar.Warnf,
+		errorf:           sugar.Errorf,
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
 	listenerWG.Add(1)
 	go func() {
 		defer listenerWG.Done()
-		pubsub.SlackListener(ctx, smc, commandQueue, cfg.PubSubConfig)
+		rl.run(ctx, hup, time.Duration(cfg.ConfigWatchInterval)*time.Second)
 	}()
 
-	if err := smc.RunContext(ctx); err != nil && !errors.Is(err, context.Canceled) {
-		sugar.Errorf("Socket Mode error: %v", err)
+	if len(cfg.Workspaces) > 0 {
+		ws := &workspaceSet{
+			cfg:          cfg,
+			configs:      rl.workspaceConfigs,
+			newTransport: newTransport,
+			logf:         sugar.Warnf,
+			errorf:       sugar.Errorf,
+		}
+		ws.run(ctx, commandQueue, outputQueue, &writerWG)
+	} else {
+		// 接続し直してもメッセージの重複判定とbotの返信の記録を引き継ぐ
+		messageState := pubsub.NewMessageState()
+		var currentTransport atomic.Value // pubsub.Transport
+		currentTransport.Store(newTransport(cfg,
+			pubsub.WithConfigStore(listenerConfig),
+			pubsub.WithMessageState(messageState),
+		))
+		writerWG.Add(1)
+		go func() {
+			defer writerWG.Done()
+			pubsub.Writer(ctx, func() pubsub.Transport {
+				return currentTransport.Load().(pubsub.Transport)
+			}, outputQueue)
+		}()
+		var backoff sessionBackoff
+		for {
+			t := currentTransport.Load().(pubsub.Transport)
+			startedAt := time.Now()
+			reconnect := runTransportSession(ctx, t, commandQueue, rl.reconnect, sugar.Errorf)
+			if ctx.Err() != nil {
+				break
+			}
+			if !reconnect {
+				// 認証エラーなどで終了した場合にすぐ接続し直すと空回りするので、間隔を空ける
+				delay := backoff.next(time.Since(startedAt))
+				sugar.Warnf("%s: session ended; reconnecting in %s", t.Name(), delay)
+				select {
+				case <-ctx.Done():
+				case <-time.After(delay):
+				case <-rl.reconnect:
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			} else {
+				// 接続先かトークンが変わったので接続し直す
+				sugar.Infof("Reconnecting with the new connection settings")
+			}
+			currentTransport.Store(newTransport(rl.current(),
+				pubsub.WithConfigStore(listenerConfig),
+				pubsub.WithMessageState(messageState),
+			))
+		}
 	}
 	stop()
 	listenerWG.Wait()
@@ -154,19 +411,190 @@ func main() {
 	executorWG.Wait()
 	close(outputQueue)
 	writerWG.Wait()
+	serverWG.Wait()
+}
+
+// runTransportSession はctxが終了するかreconnectを受け取るまで t で接続してコマンドを受け付ける。
+// reconnectを受け取って終了した場合はtrueを返す。
+func runTransportSession(
+	ctx context.Context,
+	t pubsub.Transport,
+	commandQueue chan *cmd.CommandInput,
+	reconnect <-chan struct{},
+	errorf func(template string, args ...interface{}),
+) bool {
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var requested atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-reconnect:
+			requested.Store(true)
+			cancel()
+		case <-sessionCtx.Done():
+		}
+	}()
+	if err := t.Listen(sessionCtx, commandQueue); err != nil && !errors.Is(err, context.Canceled) {
+		errorf("%s: %v", t.Name(), err)
+	}
+	cancel()
+	<-done
+	return requested.Load()
+}
+
+// sessionRetryInterval は接続が切れてから接続し直すまでの最初の間隔
+const sessionRetryInterval = 5 * time.Second
+
+// maxSessionRetryInterval は接続し直すまでの間隔の上限
+const maxSessionRetryInterval = 5 * time.Minute
+
+// sessionBackoff は接続が続けて失敗した場合に、接続し直すまでの間隔を sessionRetryInterval から
+// maxSessionRetryInterval まで倍々に延ばす
+type sessionBackoff struct {
+	delay time.Duration
+}
+
+// next は lasted の間続いた接続が切れた後、接続し直すまでの間隔を返す
+func (b *sessionBackoff) next(lasted time.Duration) time.Duration {
+	if b.delay == 0 || lasted >= maxSessionRetryInterval {
+		// 長く続いた接続が切れた場合は最初の間隔に戻す
+		b.delay = sessionRetryInterval
+	} else {
+		b.delay = min(b.delay*2, maxSessionRetryInterval)
+	}
+	return b.delay
+}
+
+// loadConfig は設定ファイルを読み込んで検証する
+func loadConfig(path string) (*Config, error) {
+	return loadConfigWith(path, validateConfig)
+}
+
+// loadLocalConfig は -local で動かす場合の設定ファイルを読み込む。
+// トークンや allowed_user_ids などのチャットサービス向けの設定は検証しない。
+func loadLocalConfig(path string) (*Config, error) {
+	return loadConfigWith(path, validateLocalConfig)
+}
+
+func loadConfigWith(path string, validate func(cfg *Config) error) (*Config, error) {
+	cfg := &Config{NumWorkers: 1}
+	if _, err := toml.DecodeFile(path, cfg); err != nil {
+		return nil, err
+	}
+	if err := validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// rateLimitConfigured は [rate_limit] かコマンドごとの rate_limit が設定されているかを返す
+func rateLimitConfigured(cfg *Config) bool {
+	if cfg.RateLimit.Enabled() {
+		return true
+	}
+	for _, c := range cfg.Commands {
+		if c.RateLimit != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// buildCommandConfigs は設定ファイルのコマンド定義と組み込みコマンドの定義をまとめる
+func buildCommandConfigs(cfg *Config, builtinDefs []*cmd.Definition) []*cmd.CommandConfig {
+	// 構造体の詰め替え（TOMLライブラリの都合とパッケージ分割の都合）
+	cmdConfig := make([]*cmd.CommandConfig, 0, len(cfg.Commands)+len(builtinDefs))
+	for _, c := range cfg.Commands {
+		cmdConfig = append(cmdConfig, cmd.NewCommandConfig(&c.Definition, &c.ReplyConfig))
+	}
+	// 組み込みコマンドは設定ファイルのコマンドより後に置き、同じキーワードなら設定ファイルを優先する
+	for _, def := range builtinDefs {
+		cmdConfig = append(cmdConfig, cmd.NewCommandConfig(def, nil))
+	}
+	return cmdConfig
+}
+
+// serveHTTP はctxが終了するまでaddrでhandlerを提供する
+func serveHTTP(
+	ctx context.Context,
+	addr string,
+	handler http.Handler,
+	errorf func(template string, args ...interface{}),
+) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errorf("HTTP server on %s: %v", addr, err)
+	}
 }
 
+// newRunnerFactory はコマンド設定の runner に応じたランナーを返す関数を作る。
+// builtins は組み込みコマンド用のランナー（runner名をキーとする）。
+func newRunnerFactory(builtins map[string]cmd.CommandRunner) cmd.RunnerFactory {
+	var composeRunnerOnce sync.Once
+	var composeRunner cmd.CommandRunner
+	return func(cfg *cmd.CommandConfig) cmd.CommandRunner {
+		if r, ok := builtins[cfg.Runner]; ok {
+			return r
+		}
+		switch cfg.Runner {
+		case "compose":
+			composeRunnerOnce.Do(func() {
+				composeRunner = cmd.NewComposeRunner("")
+			})
+			return composeRunner
+		case "http":
+			return cmd.NewHTTPRunner(cfg)
+		case "grpc":
+			return cmd.NewGRPCRunner(cfg)
+		case "script":
+			return cmd.NewScriptRunner(cfg)
+		case "wasm":
+			return cmd.NewWASMRunner(cfg)
+		}
+		return cmd.NewExecRunner()
+	}
+}
+
+// validateConfig はチャットサービスに接続して動かす場合の設定を検証する
 func validateConfig(cfg *Config) error {
+	if err := validateLocalConfig(cfg); err != nil {
+		return err
+	}
+	// [[workspaces]] を使う場合はワークスペースごとに確認する
+	if len(cfg.Workspaces) == 0 {
+		if err := checkOpenAccess(&cfg.PubSubConfig, cfg.Discord.AllowedRoleIDs); err != nil {
+			return err
+		}
+	}
+	if err := validateTransport(cfg); err != nil {
+		return err
+	}
+	return validateWorkspaces(cfg)
+}
+
+// validateLocalConfig はチャットサービスへの接続に関係しない設定を検証する。
+// -local で動かす場合はこれだけを検証する。
+func validateLocalConfig(cfg *Config) error {
 	if cfg.NumWorkers < 1 {
 		return fmt.Errorf("num_workers must be >= 1 (got %d)", cfg.NumWorkers)
 	}
-	if len(cfg.AllowedUserIDs) == 0 &&
-		len(cfg.AllowedChannelIDs) == 0 &&
-		!cfg.AllowUnsafeOpenAccess {
-		return errors.New(
-			"open access is disabled by default: set allowed_user_ids and/or allowed_channel_ids, " +
-				"or set allow_unsafe_open_access=true to keep old behavior",
-		)
+	switch {
+	case cfg.QueueSize < 0:
+		return fmt.Errorf("queue_size must be >= 0 (got %d)", cfg.QueueSize)
+	case cfg.QueueSize == 0:
+		cfg.QueueSize = defaultQueueSize
 	}
 
 	for _, c := range cfg.Commands {
@@ -174,25 +602,213 @@ func validateConfig(cfg *Config) error {
 		if runner == "" {
 			runner = "exec"
 		}
+		var err error
 		switch runner {
-		case "exec", "compose", "http":
-			c.Runner = runner
+		case "exec", "compose":
+			err = validateExecCommand(c)
+		case "wasm":
+			err = validateWASMCommand(c)
+		case "http":
+			err = validateHTTPCommand(c)
+		case "grpc":
+			err = validateGRPCCommand(c)
+		case "script":
+			err = validateScriptCommand(c)
 		default:
 			return fmt.Errorf("unknown runner '%s' for keyword '%s'", c.Runner, c.Keyword)
 		}
-		if runner != "http" {
-			if strings.HasPrefix(c.Command, "*") {
-				return fmt.Errorf("command field must not start with '*': %s", c.Command)
-			}
-		} else {
-			c.Method = strings.ToUpper(strings.TrimSpace(c.Method))
-			if c.Method == "" {
-				c.Method = "POST"
-			}
-			if strings.TrimSpace(c.URL) == "" {
-				return fmt.Errorf("url is required for http runner (keyword '%s')", c.Keyword)
-			}
+		if err != nil {
+			return err
+		}
+		if err := validateCommandLimits(c); err != nil {
+			return err
+		}
+		c.Runner = runner
+	}
+	if cfg.HistoryRetentionDays < 0 || cfg.HistoryMaxJobs < 0 {
+		return errors.New("history_retention_days and history_max_jobs must be >= 0")
+	}
+	if cfg.HealthLivenessWindow < 0 {
+		return errors.New("health_liveness_window must be >= 0")
+	}
+	if cfg.ConfigWatchInterval < 0 {
+		return errors.New("config_watch_interval must be >= 0")
+	}
+	if cfg.EditWindow < 0 {
+		return errors.New("edit_window must be >= 0")
+	}
+	if err := cfg.API.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.RateLimit.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Audit.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.Tracing.Validate(); err != nil {
+		return err
+	}
+	for _, s := range cfg.Schedules {
+		if err := s.Validate(); err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+func validateTransport(cfg *Config) error {
+	cfg.Transport = strings.ToLower(strings.TrimSpace(cfg.Transport))
+	switch cfg.Transport {
+	case "":
+		cfg.Transport = transportSlack
+		return validateSlackConfig(&cfg.PubSubConfig)
+	case transportSlack:
+		return validateSlackConfig(&cfg.PubSubConfig)
+	case transportMattermost:
+		if strings.TrimSpace(cfg.Mattermost.URL) == "" || cfg.Mattermost.Token == "" {
+			return errors.New("mattermost.url and mattermost.token are required for mattermost transport")
+		}
+	case transportDiscord:
+		if cfg.Discord.Token == "" {
+			return errors.New("discord.token is required for discord transport")
+		}
+	default:
+		return fmt.Errorf("unknown transport '%s'", cfg.Transport)
+	}
+	return nil
+}
+
+func checkOpenAccess(c *PubSubConfig, roleIDs []string) error {
+	if len(c.AllowedUserIDs) == 0 &&
+		len(c.AllowedChannelIDs) == 0 &&
+		len(roleIDs) == 0 &&
+		!c.AllowUnsafeOpenAccess {
+		return errors.New(
+			"open access is disabled by default: set allowed_user_ids and/or allowed_channel_ids, " +
+				"or set allow_unsafe_open_access=true to keep old behavior",
+		)
+	}
+	return nil
+}
+
+// validateSlackConfig はSlackに接続する場合の mode と prefer_event を確認する
+func validateSlackConfig(cfg *PubSubConfig) error {
+	if err := validateSlackMode(cfg); err != nil {
+		return err
+	}
+	cfg.PreferEvent = strings.ToLower(strings.TrimSpace(cfg.PreferEvent))
+	switch cfg.PreferEvent {
+	case "", pubsub.PreferMessage, pubsub.PreferAppMention:
+		return nil
+	}
+	return fmt.Errorf("unknown prefer_event '%s'", cfg.PreferEvent)
+}
+
+func validateSlackMode(cfg *PubSubConfig) error {
+	cfg.Mode = strings.ToLower(strings.TrimSpace(cfg.Mode))
+	switch cfg.Mode {
+	case "":
+		cfg.Mode = slackModeSocket
+	case slackModeSocket:
+	case slackModeHTTP:
+		if strings.TrimSpace(cfg.HTTPListenAddr) == "" || cfg.SlackSigningSecret == "" {
+			return errors.New("http_listen_addr and slack_signing_secret are required for mode = \"http\"")
+		}
+	default:
+		return fmt.Errorf("unknown mode '%s'", cfg.Mode)
+	}
+	return nil
+}
+
+// validateCommandLimits は max_concurrency、lock_group、on_limit を確認する
+func validateCommandLimits(c *CommandConfig) error {
+	if c.MaxConcurrency < 0 {
+		return fmt.Errorf("max_concurrency must be >= 0 (keyword '%s')", c.Keyword)
+	}
+	if c.RateLimit != "" {
+		if _, err := ratelimit.ParseRate(c.RateLimit); err != nil {
+			return fmt.Errorf("rate_limit for keyword '%s': %w", c.Keyword, err)
+		}
+	}
+	c.OnLimit = strings.ToLower(strings.TrimSpace(c.OnLimit))
+	switch c.OnLimit {
+	case "":
+		c.OnLimit = cmd.OnLimitQueue
+	case cmd.OnLimitQueue, cmd.OnLimitReject, cmd.OnLimitCancelOldest:
+	default:
+		return fmt.Errorf("unknown on_limit '%s' for keyword '%s'", c.OnLimit, c.Keyword)
+	}
+	return nil
+}
+
+func validateExecCommand(c *CommandConfig) error {
+	if strings.HasPrefix(c.Command, "*") {
+		return fmt.Errorf("command field must not start with '*': %s", c.Command)
+	}
+	if strings.TrimSpace(c.BodyFrom) != "" {
+		return fmt.Errorf("body_from is only available for http runner (keyword '%s')", c.Keyword)
+	}
+	return nil
+}
+
+// validateWASMCommand は実行時に初めて失敗しないよう、モジュールを読んでコンパイルしておく
+func validateWASMCommand(c *CommandConfig) error {
+	if err := validateExecCommand(c); err != nil {
+		return err
+	}
+	if err := cmd.CheckWASMModule(c.Command, c.MaxMemoryPages); err != nil {
+		return fmt.Errorf("cannot load wasm module for keyword '%s': %w", c.Keyword, err)
+	}
+	return nil
+}
+
+func validateHTTPCommand(c *CommandConfig) error {
+	c.Method = strings.ToUpper(strings.TrimSpace(c.Method))
+	if c.Method == "" {
+		c.Method = "POST"
+	}
+	if strings.TrimSpace(c.URL) == "" {
+		return fmt.Errorf("url is required for http runner (keyword '%s')", c.Keyword)
+	}
+	c.BodyFrom = strings.ToLower(strings.TrimSpace(c.BodyFrom))
+	switch c.BodyFrom {
+	case "", "stdin", "template", "multipart":
+	default:
+		return fmt.Errorf("unknown body_from '%s' for keyword '%s'", c.BodyFrom, c.Keyword)
+	}
+	return nil
+}
+
+func validateGRPCCommand(c *CommandConfig) error {
+	if strings.TrimSpace(c.Target) == "" {
+		return fmt.Errorf("target is required for grpc runner (keyword '%s')", c.Keyword)
+	}
+	c.Method = strings.TrimSpace(c.Method)
+	if c.Method == "" {
+		return fmt.Errorf("method is required for grpc runner (keyword '%s')", c.Keyword)
+	}
+	// 以前は body 内の * を置換していたので、置換されることを期待した設定を黙って通さない
+	if strings.Contains(c.Body, "*") {
+		return fmt.Errorf(
+			"body of grpc runner must not contain '*'; embed the matched text with {{json .Args}} instead (keyword '%s')",
+			c.Keyword,
+		)
+	}
+	return nil
+}
+
+func validateScriptCommand(c *CommandConfig) error {
+	hasScript := strings.TrimSpace(c.Script) != ""
+	hasScriptFile := strings.TrimSpace(c.ScriptFile) != ""
+	if hasScript == hasScriptFile {
+		return fmt.Errorf(
+			"exactly one of script or script_file is required for script runner (keyword '%s')",
+			c.Keyword,
+		)
+	}
+	if c.MaxSteps < 0 {
+		return fmt.Errorf("max_steps must be >= 0 (keyword '%s')", c.Keyword)
+	}
+	return nil
+}
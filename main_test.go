@@ -1,9 +1,18 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hnw/slack-commander/api"
 	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/pubsub"
+	"github.com/hnw/slack-commander/ratelimit"
 )
 
 func TestValidateConfigRejectsOpenAccessByDefault(t *testing.T) {
@@ -99,3 +108,422 @@ func TestValidateConfigRejectsHTTPRunnerWithoutURL(t *testing.T) {
 		t.Fatalf("expected error for http runner without url")
 	}
 }
+
+func TestValidateConfigRejectsUnknownBodyFrom(t *testing.T) {
+	cfg := &Config{
+		PubSubConfig: PubSubConfig{
+			AllowedUserIDs: []string{"U123"},
+		},
+		NumWorkers: 1,
+		Commands: []*CommandConfig{
+			{
+				Definition: cmd.Definition{
+					Keyword:  "notify *",
+					Runner:   "http",
+					URL:      "http://example.com/hook",
+					BodyFrom: "file",
+				},
+			},
+		},
+	}
+
+	if err := validateConfig(cfg); err == nil {
+		t.Fatalf("expected error for unknown body_from")
+	}
+}
+
+func TestValidateConfigCommandLimits(t *testing.T) {
+	newCfg := func(def cmd.Definition) *Config {
+		def.Keyword = "deploy"
+		def.Command = "deploy.sh"
+		return &Config{
+			PubSubConfig: PubSubConfig{AllowedUserIDs: []string{"U123"}},
+			NumWorkers:   1,
+			Commands:     []*CommandConfig{{Definition: def}},
+		}
+	}
+
+	cfg := newCfg(cmd.Definition{MaxConcurrency: 1})
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Commands[0].OnLimit != cmd.OnLimitQueue {
+		t.Fatalf("on_limit should default to queue, got %q", cfg.Commands[0].OnLimit)
+	}
+	if err := validateConfig(newCfg(cmd.Definition{LockGroup: "release", OnLimit: "Reject"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateConfig(newCfg(cmd.Definition{MaxConcurrency: -1})); err == nil {
+		t.Fatalf("expected error for negative max_concurrency")
+	}
+	if err := validateConfig(newCfg(cmd.Definition{OnLimit: "drop"})); err == nil {
+		t.Fatalf("expected error for unknown on_limit")
+	}
+}
+
+func TestValidateConfigRateLimit(t *testing.T) {
+	newCfg := func(rl ratelimit.Config, perCommand string) *Config {
+		return &Config{
+			PubSubConfig: PubSubConfig{AllowedUserIDs: []string{"U123"}},
+			NumWorkers:   1,
+			RateLimit:    rl,
+			Commands: []*CommandConfig{
+				{Definition: cmd.Definition{Keyword: "deploy", Command: "deploy.sh", RateLimit: perCommand}},
+			},
+		}
+	}
+	cfg := newCfg(ratelimit.Config{PerUser: "10/min", PerChannel: "1/30s"}, "5/hour")
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !rateLimitConfigured(cfg) || rateLimitConfigured(newCfg(ratelimit.Config{}, "")) {
+		t.Fatalf("unexpected rateLimitConfigured result")
+	}
+	if err := validateConfig(newCfg(ratelimit.Config{PerUser: "ten/min"}, "")); err == nil {
+		t.Fatalf("expected error for invalid per_user")
+	}
+	if err := validateConfig(newCfg(ratelimit.Config{}, "5/week")); err == nil {
+		t.Fatalf("expected error for invalid rate_limit")
+	}
+}
+
+func TestValidateConfigPreferEvent(t *testing.T) {
+	newCfg := func(prefer string) *Config {
+		return &Config{
+			PubSubConfig: PubSubConfig{AllowedUserIDs: []string{"U123"}, PreferEvent: prefer},
+			NumWorkers:   1,
+		}
+	}
+	cfg := newCfg(" App_Mention ")
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.PreferEvent != pubsub.PreferAppMention {
+		t.Fatalf("prefer_event must be normalized: %q", cfg.PreferEvent)
+	}
+	if err := validateConfig(newCfg("mention")); err == nil {
+		t.Fatalf("expected error for unknown prefer_event")
+	}
+}
+
+func TestValidateConfigScriptRunnerRequiresExactlyOneSource(t *testing.T) {
+	newCfg := func(def cmd.Definition) *Config {
+		def.Keyword = "hello"
+		def.Runner = "script"
+		return &Config{
+			PubSubConfig: PubSubConfig{AllowedUserIDs: []string{"U123"}},
+			NumWorkers:   1,
+			Commands:     []*CommandConfig{{Definition: def}},
+		}
+	}
+
+	if err := validateConfig(newCfg(cmd.Definition{Script: `print("hi")`})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validateConfig(newCfg(cmd.Definition{})); err == nil {
+		t.Fatalf("expected error for script runner without script")
+	}
+	if err := validateConfig(newCfg(cmd.Definition{
+		Script:     `print("hi")`,
+		ScriptFile: "hello.star",
+	})); err == nil {
+		t.Fatalf("expected error for script runner with both script and script_file")
+	}
+}
+
+func TestValidateConfigGRPCRunnerRejectsWildcardInBody(t *testing.T) {
+	newCfg := func(body string) *Config {
+		return &Config{
+			PubSubConfig: PubSubConfig{AllowedUserIDs: []string{"U123"}},
+			NumWorkers:   1,
+			Commands: []*CommandConfig{{Definition: cmd.Definition{
+				Keyword: "health *",
+				Runner:  "grpc",
+				Target:  "localhost:50051",
+				Method:  "grpc.health.v1.Health/Check",
+				Body:    body,
+			}}},
+		}
+	}
+
+	if err := validateConfig(newCfg(`{"service":{{json .Args}}}`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := validateConfig(newCfg(`{"service":"*"}`))
+	if err == nil || !strings.Contains(err.Error(), "{{json .Args}}") {
+		t.Fatalf("expected error pointing to {{json .Args}}, got %v", err)
+	}
+}
+
+func TestValidateConfigWASMRunnerRequiresModule(t *testing.T) {
+	cfg := &Config{
+		PubSubConfig: PubSubConfig{AllowedUserIDs: []string{"U123"}},
+		NumWorkers:   1,
+		Commands: []*CommandConfig{{Definition: cmd.Definition{
+			Keyword: "tool *",
+			Runner:  "wasm",
+			Command: filepath.Join(t.TempDir(), "none.wasm") + " *",
+		}}},
+	}
+
+	err := validateConfig(cfg)
+	if err == nil || !strings.Contains(err.Error(), "none.wasm") {
+		t.Fatalf("expected error for missing wasm module, got %v", err)
+	}
+}
+
+func newTestReloader(t *testing.T, content string) (*reloader, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nop := func(string, ...interface{}) {}
+	return &reloader{
+		path:           path,
+		cfg:            cfg,
+		table:          cmd.NewCommandTable(buildCommandConfigs(cfg, nil), nil),
+		listenerConfig: pubsub.NewConfigStore(cfg.PubSubConfig),
+		reconnect:      make(chan struct{}, 1),
+		infof:          nop,
+		warnf:          nop,
+		errorf:         nop,
+	}, path
+}
+
+const reloadTestConfig = `
+slack_bot_token = "xoxb-old"
+slack_app_token = "xapp-old"
+allowed_user_ids = ["U1"]
+
+[[commands]]
+keyword = "date"
+command = "date"
+`
+
+func TestReloaderKeepsConfigOnError(t *testing.T) {
+	rl, path := newTestReloader(t, reloadTestConfig)
+	// allowed_user_ids を消すと検証に失敗する
+	broken := strings.Replace(reloadTestConfig, `allowed_user_ids = ["U1"]`, "", 1)
+	if err := os.WriteFile(path, []byte(broken), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.reload(); err == nil {
+		t.Fatal("expected reload error")
+	}
+	if got := rl.listenerConfig.Load().AllowedUserIDs; len(got) != 1 || got[0] != "U1" {
+		t.Fatalf("listener config was replaced: %v", got)
+	}
+	select {
+	case <-rl.reconnect:
+		t.Fatal("unexpected reconnect")
+	default:
+	}
+}
+
+func TestReloaderUpdatesConfigAndRequestsReconnect(t *testing.T) {
+	rl, path := newTestReloader(t, reloadTestConfig)
+	updated := strings.NewReplacer(`"U1"`, `"U2"`, "xoxb-old", "xoxb-new").Replace(reloadTestConfig)
+	if err := os.WriteFile(path, []byte(updated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := rl.listenerConfig.Load().AllowedUserIDs; len(got) != 1 || got[0] != "U2" {
+		t.Fatalf("listener config was not replaced: %v", got)
+	}
+	select {
+	case <-rl.reconnect:
+	default:
+		t.Fatal("expected reconnect request")
+	}
+	if bot := rl.current().SlackBotToken; bot != "xoxb-new" {
+		t.Fatalf("unexpected bot token: %s", bot)
+	}
+}
+
+func TestLoadLocalConfigSkipsChatSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	// トークンも allowed_user_ids もない、-local で試すだけの設定
+	content := `
+[[commands]]
+keyword = "date"
+command = "date"
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(path); err == nil {
+		t.Fatal("expected the open access check to fail without -local")
+	}
+	cfg, err := loadLocalConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Commands) != 1 || cfg.Commands[0].Runner != "exec" {
+		t.Fatalf("commands were not validated: %+v", cfg.Commands)
+	}
+	// コマンドの定義は -local でも検証する
+	if err := os.WriteFile(path, []byte(content+"runner = \"nope\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadLocalConfig(path); err == nil {
+		t.Fatal("expected an unknown runner to be rejected")
+	}
+}
+
+func TestReloaderUpdatesAPITokens(t *testing.T) {
+	withAPI := reloadTestConfig + `
+[api]
+addr = "127.0.0.1:0"
+
+[[api.tokens]]
+name = "ci"
+token = "old-token"
+`
+	rl, path := newTestReloader(t, withAPI)
+	q := make(chan *cmd.CommandInput, 1)
+	rl.apiServer = api.New(rl.cfg.API, rl.table, q, nil)
+	mux := http.NewServeMux()
+	rl.apiServer.Register(mux)
+	run := func(token string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/run", strings.NewReader(`{"text":"date","channel":"C1"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Replace(withAPI, "old-token", "new-token", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// トークンの変更は再起動せずに反映する
+	if code := run("old-token"); code != http.StatusUnauthorized {
+		t.Fatalf("expected the old token to be rejected, got %d", code)
+	}
+	if code := run("new-token"); code != http.StatusAccepted {
+		t.Fatalf("expected the new token to be accepted, got %d", code)
+	}
+}
+
+func TestRestartRequiredChanges(t *testing.T) {
+	oldCfg := &Config{NumWorkers: 1, MetricsAddr: ":9090"}
+	newCfg := &Config{NumWorkers: 2, MetricsAddr: ":9090"}
+	got := restartRequiredChanges(oldCfg, newCfg)
+	if len(got) != 1 || got[0] != "num_workers" {
+		t.Fatalf("unexpected changes: %v", got)
+	}
+}
+
+func TestRunSubcommandCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(reloadTestConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr strings.Builder
+	code, ok := runSubcommand([]string{"check", "-config-file", path}, &stdout, &stderr)
+	if !ok || code != 0 {
+		t.Fatalf("check failed: code=%d stderr=%s", code, stderr.String())
+	}
+	code, _ = runSubcommand([]string{"match", "-config-file", path, "nope"}, &stdout, &stderr)
+	if code != 1 || !strings.Contains(stdout.String(), "no matching command") {
+		t.Fatalf("unexpected match result: code=%d stdout=%s", code, stdout.String())
+	}
+	if _, ok := runSubcommand([]string{"-v"}, &stdout, &stderr); ok {
+		t.Fatal("flags must not be treated as a subcommand")
+	}
+}
+
+const workspacesTestConfig = `
+[[workspaces]]
+name = "main"
+slack_bot_token = "xoxb-main"
+slack_app_token = "xapp-main"
+allowed_user_ids = ["U1"]
+
+[[workspaces]]
+name = "partner"
+slack_bot_token = "xoxb-partner"
+slack_app_token = "xapp-partner"
+allowed_channel_ids = ["C1"]
+allowed_commands = ["date"]
+
+[[commands]]
+keyword = "date"
+command = "date"
+`
+
+func TestValidateConfigWorkspaces(t *testing.T) {
+	rl, path := newTestReloader(t, workspacesTestConfig)
+	if got := rl.cfg.Workspaces[1].AllowedCommands; len(got) != 1 || got[0] != "date" {
+		t.Fatalf("unexpected allowed_commands: %v", got)
+	}
+
+	tests := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{"top-level token", "[[workspaces]]", "slack_bot_token = \"xoxb\"\n[[workspaces]]"},
+		{"duplicate name", `name = "partner"`, `name = "main"`},
+		{"open access", `allowed_channel_ids = ["C1"]`, ""},
+		{"missing app token", `slack_app_token = "xapp-partner"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broken := strings.Replace(workspacesTestConfig, tt.old, tt.new, 1)
+			if err := os.WriteFile(path, []byte(broken), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadConfig(path); err == nil {
+				t.Fatal("expected validation error")
+			}
+		})
+	}
+}
+
+func TestReloaderUpdatesWorkspaceConfigs(t *testing.T) {
+	rl, path := newTestReloader(t, workspacesTestConfig)
+	rl.workspaceConfigs = newWorkspaceConfigStores(rl.cfg)
+	var warnings []string
+	rl.warnf = func(template string, args ...interface{}) {
+		warnings = append(warnings, template)
+	}
+	updated := strings.NewReplacer(`"C1"`, `"C2"`, "xoxb-main", "xoxb-new").Replace(workspacesTestConfig)
+	if err := os.WriteFile(path, []byte(updated), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rl.reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := rl.workspaceConfigs["partner"].Load().AllowedChannelIDs; len(got) != 1 || got[0] != "C2" {
+		t.Fatalf("workspace config was not replaced: %v", got)
+	}
+	// トークンの変更は再起動するまで反映しない
+	if len(warnings) != 1 {
+		t.Fatalf("expected a restart warning for the token change, got %v", warnings)
+	}
+}
+
+func TestSessionBackoff(t *testing.T) {
+	var b sessionBackoff
+	var got []time.Duration
+	for range 8 {
+		got = append(got, b.next(time.Second))
+	}
+	if got[0] != sessionRetryInterval || got[1] != 2*sessionRetryInterval || got[7] != maxSessionRetryInterval {
+		t.Fatalf("unexpected delays: %v", got)
+	}
+	// 長く続いた接続が切れた場合は最初の間隔に戻す
+	if d := b.next(maxSessionRetryInterval); d != sessionRetryInterval {
+		t.Fatalf("expected the delay to be reset, got %s", d)
+	}
+}
@@ -0,0 +1,2 @@
+// Package metrics defines the Prometheus metrics exported by slack-commander.
+package metrics
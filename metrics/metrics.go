@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "slack_commander"
+
+// Socket Modeの接続状態（SetSocketModeState に渡す値）
+const (
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+	StateDisconnected = "disconnected"
+)
+
+var socketModeStates = []string{StateConnecting, StateConnected, StateDisconnected}
+
+// registry はこのパッケージのメトリクスだけを登録する（他のライブラリが
+// DefaultRegisterer に登録したものを /metrics に出さないため）
+var registry = prometheus.NewRegistry()
+
+var (
+	commandsReceived = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_received_total",
+		Help:      "Number of messages accepted as commands and put into the command queue.",
+	})
+	commandsMatched = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_matched_total",
+		Help:      "Number of commands matched, by keyword.",
+	}, []string{"keyword"})
+	commandsUnmatched = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_unmatched_total",
+		Help:      "Number of commands that matched no keyword.",
+	})
+	commandDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "command_duration_seconds",
+		Help:      "Command execution duration, by keyword and runner.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+	}, []string{"keyword", "runner"})
+	commandExitCodes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "command_exit_codes_total",
+		Help:      "Number of finished commands, by keyword and exit code.",
+	}, []string{"keyword", "exit_code"})
+	queueDrops = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_drops_total",
+		Help:      "Number of items dropped because the queue was full.",
+	}, []string{"queue"})
+	slackAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "slack_api_errors_total",
+		Help:      "Number of failed Slack API calls, by operation.",
+	}, []string{"operation"})
+	socketModeState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "socket_mode_state",
+		Help:      "Current Socket Mode connection state (1 for the current state, 0 otherwise).",
+	}, []string{"state"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		commandsReceived,
+		commandsMatched,
+		commandsUnmatched,
+		commandDuration,
+		commandExitCodes,
+		queueDrops,
+		slackAPIErrors,
+		socketModeState,
+	)
+	SetSocketModeState(StateDisconnected)
+}
+
+// Handler returns an http.Handler serving the metrics.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterQueue exports the current length of a queue as queue_depth{queue=name}.
+func RegisterQueue(name string, length func() int) {
+	registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "queue_depth",
+		Help:        "Number of items waiting in the queue.",
+		ConstLabels: prometheus.Labels{"queue": name},
+	}, func() float64 {
+		return float64(length())
+	}))
+}
+
+// CommandReceived counts a message put into the command queue.
+func CommandReceived() {
+	commandsReceived.Inc()
+}
+
+// CommandMatched counts a command matched to keyword.
+func CommandMatched(keyword string) {
+	commandsMatched.WithLabelValues(keyword).Inc()
+}
+
+// CommandUnmatched counts a command that matched no keyword.
+func CommandUnmatched() {
+	commandsUnmatched.Inc()
+}
+
+// CommandFinished records the duration and exit code of a command.
+func CommandFinished(keyword, runner string, seconds float64, exitCode int) {
+	if runner == "" {
+		runner = "exec"
+	}
+	commandDuration.WithLabelValues(keyword, runner).Observe(seconds)
+	commandExitCodes.WithLabelValues(keyword, strconv.Itoa(exitCode)).Inc()
+}
+
+// QueueDropped counts an item dropped because the queue was full.
+func QueueDropped(queue string) {
+	queueDrops.WithLabelValues(queue).Inc()
+}
+
+// SlackAPIError counts a failed Slack API call.
+func SlackAPIError(operation string) {
+	slackAPIErrors.WithLabelValues(operation).Inc()
+}
+
+// SetSocketModeState sets the current Socket Mode connection state.
+func SetSocketModeState(state string) {
+	for _, s := range socketModeStates {
+		v := 0.0
+		if s == state {
+			v = 1
+		}
+		socketModeState.WithLabelValues(s).Set(v)
+	}
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	data, err := io.ReadAll(rec.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestHandlerExportsMetrics(t *testing.T) {
+	queue := make(chan int, 5)
+	queue <- 1
+	queue <- 2
+	RegisterQueue("test", func() int { return len(queue) })
+	CommandReceived()
+	CommandMatched("uptime")
+	CommandUnmatched()
+	CommandFinished("uptime", "", 0.2, 1)
+	QueueDropped("command")
+	SlackAPIError("postMessage")
+	SetSocketModeState(StateConnected)
+
+	body := scrape(t)
+	for _, want := range []string{
+		`slack_commander_commands_received_total 1`,
+		`slack_commander_commands_matched_total{keyword="uptime"} 1`,
+		`slack_commander_commands_unmatched_total 1`,
+		`slack_commander_command_duration_seconds_count{keyword="uptime",runner="exec"} 1`,
+		`slack_commander_command_exit_codes_total{exit_code="1",keyword="uptime"} 1`,
+		`slack_commander_queue_depth{queue="test"} 2`,
+		`slack_commander_queue_drops_total{queue="command"} 1`,
+		`slack_commander_slack_api_errors_total{operation="postMessage"} 1`,
+		`slack_commander_socket_mode_state{state="connected"} 1`,
+		`slack_commander_socket_mode_state{state="disconnected"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics output does not contain %q", want)
+		}
+	}
+}
@@ -1,16 +1,41 @@
 package pubsub
 
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
 // Config defines Slack pub/sub settings.
 type Config struct {
 	ReplyConfig
 	SlackBotToken         string   `toml:"slack_bot_token"`
 	SlackAppToken         string   `toml:"slack_app_token"`
+	Mode                  string   `toml:"mode"`                 // "socket"（既定）または "http"
+	HTTPListenAddr        string   `toml:"http_listen_addr"`     // mode = "http" のときの待ち受けアドレス
+	SlackSigningSecret    string   `toml:"slack_signing_secret"` // mode = "http" のときの署名検証用シークレット
 	AllowUnsafeOpenAccess bool     `toml:"allow_unsafe_open_access"`
 	AcceptReminder        bool     `toml:"accept_reminder"`
 	AcceptBotMessage      bool     `toml:"accept_bot_message"`
 	AcceptThreadMessage   bool     `toml:"accept_thread_message"`
 	AllowedUserIDs        []string `toml:"allowed_user_ids"`
 	AllowedChannelIDs     []string `toml:"allowed_channel_ids"`
+	AllowedCommands       []string `toml:"allowed_commands"`         // 実行できるコマンドのキーワード（空なら制限しない）
+	RerunOnEdit           bool     `toml:"rerun_on_edit"`            // コマンドのメッセージが編集されたら実行し直す
+	EditWindow            int      `toml:"edit_window"`              // rerun_on_edit で実行し直す、投稿から編集までの秒数（0なら300秒）
+	DeleteRepliesOnDelete bool     `toml:"delete_replies_on_delete"` // コマンドのメッセージが削除されたらbotの返信も削除する
+	PreferEvent           string   `toml:"prefer_event"`             // "message" か "app_mention"（空なら先に届いた方）
+}
+
+// defaultEditWindow は EditWindow を指定しなかった場合に実行し直す、投稿から編集までの時間
+const defaultEditWindow = 5 * time.Minute
+
+func (c Config) editWindow() time.Duration {
+	if c.EditWindow <= 0 {
+		return defaultEditWindow
+	}
+	return time.Duration(c.EditWindow) * time.Second
 }
 
 // ReplyConfig defines reply formatting options.
@@ -22,3 +47,72 @@ type ReplyConfig struct {
 	AlwaysBroadcast bool   `toml:"always_broadcast"`
 	Monospaced      bool
 }
+
+// MattermostConfig defines the Mattermost server to connect to.
+type MattermostConfig struct {
+	URL   string `toml:"url"`   // 例: https://mattermost.example.com
+	Token string `toml:"token"` // botアカウントのアクセストークン
+}
+
+// DiscordConfig defines the Discord bot to connect as.
+type DiscordConfig struct {
+	Token          string   `toml:"token"`            // botトークン
+	AllowedRoleIDs []string `toml:"allowed_role_ids"` // コマンドを実行できるロールのID
+	APIURL         string   `toml:"api_url"`          // 通常は指定不要（テスト用）
+	GatewayURL     string   `toml:"gateway_url"`      // 通常は指定不要（テスト用）
+}
+
+// ChannelReply は元メッセージを持たないコマンド（定期実行など）の返信先を表す。
+// cmd.CommandInput.ReplyInfo として使う。
+type ChannelReply struct {
+	Channel         string
+	ThreadTimeStamp string // 空ならチャンネルに新しいメッセージとしてポストする
+}
+
+// APIReply はHTTP APIから実行されたコマンドの返信先を表す。
+// cmd.CommandInput.ReplyInfo として使う。
+type APIReply struct {
+	ChannelReply
+	// Observe はWriterが出力を投稿する前に呼ばれる（nilでもよい）。APIでジョブの状態を記録するのに使う。
+	Observe func(output *cmd.CommandOutput)
+}
+
+// WorkspaceReply は複数のワークスペースに接続している場合に、返信先のワークスペースを ReplyInfo に付け加える。
+// DispatchByWorkspace がワークスペースごとのWriterに振り分けるときに外す。
+type WorkspaceReply struct {
+	Workspace string
+	ReplyInfo interface{}
+}
+
+// channelReplyOf は元メッセージを持たない返信先（ChannelReply, APIReply）を返す
+func channelReplyOf(output *cmd.CommandOutput) (*ChannelReply, bool) {
+	switch r := output.ReplyInfo.(type) {
+	case *ChannelReply:
+		return r, true
+	case *APIReply:
+		return &r.ChannelReply, true
+	}
+	return nil, false
+}
+
+// ConfigStore は設定の再読み込みに備えて Config を差し替え可能な形で保持する
+type ConfigStore struct {
+	v atomic.Pointer[Config]
+}
+
+// NewConfigStore returns a store holding cfg.
+func NewConfigStore(cfg Config) *ConfigStore {
+	s := &ConfigStore{}
+	s.Store(cfg)
+	return s
+}
+
+// Load returns the current config.
+func (s *ConfigStore) Load() Config {
+	return *s.v.Load()
+}
+
+// Store atomically replaces the config.
+func (s *ConfigStore) Store(cfg Config) {
+	s.v.Store(&cfg)
+}
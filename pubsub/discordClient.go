@@ -0,0 +1,204 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDiscordAPIURL     = "https://discord.com/api/v10"
+	defaultDiscordGatewayURL = "wss://gateway.discord.gg/?v=10&encoding=json"
+)
+
+// discordClient はDiscordのREST APIを呼び出す
+type discordClient struct {
+	baseURL string // 末尾の / は含まない
+	token   string
+	http    *http.Client
+}
+
+func newDiscordClient(cfg DiscordConfig) *discordClient {
+	baseURL := cfg.APIURL
+	if baseURL == "" {
+		baseURL = defaultDiscordAPIURL
+	}
+	return &discordClient{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   cfg.Token,
+		http:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// do はAPIを呼び出す。429が返った場合は retry_after だけ待って最大3回まで再試行する。
+func (c *discordClient) do(
+	ctx context.Context,
+	method, path string,
+	body []byte,
+	contentType string,
+) error {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bot "+c.token)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return err
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < 3 {
+			if err := sleepContext(ctx, discordRetryAfter(data)); err != nil {
+				return err
+			}
+			continue
+		}
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+}
+
+func discordRetryAfter(body []byte) time.Duration {
+	var v struct {
+		RetryAfter float64 `json:"retry_after"` // 秒
+	}
+	if err := json.Unmarshal(body, &v); err != nil || v.RetryAfter <= 0 {
+		return time.Second
+	}
+	return time.Duration(v.RetryAfter * float64(time.Second))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// discordOutgoingMessage は投稿するメッセージ
+type discordOutgoingMessage struct {
+	Content          string                   `json:"content,omitempty"`
+	Embeds           []discordEmbed           `json:"embeds,omitempty"`
+	MessageReference *discordMessageReference `json:"message_reference,omitempty"`
+	Attachments      []discordAttachment      `json:"attachments,omitempty"`
+}
+
+type discordEmbed struct {
+	Description string              `json:"description,omitempty"`
+	Color       int                 `json:"color,omitempty"`
+	Footer      *discordEmbedFooter `json:"footer,omitempty"`
+}
+
+type discordEmbedFooter struct {
+	Text string `json:"text"`
+}
+
+type discordMessageReference struct {
+	MessageID string `json:"message_id"`
+}
+
+type discordAttachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type,omitempty"`
+	URL         string `json:"url,omitempty"`
+}
+
+func (c *discordClient) createMessage(
+	ctx context.Context,
+	channelID string,
+	msg *discordOutgoingMessage,
+) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	path := "/channels/" + url.PathEscape(channelID) + "/messages"
+	return c.do(ctx, http.MethodPost, path, data, "application/json")
+}
+
+// createMessageWithFile はファイルを添付したメッセージを投稿する
+func (c *discordClient) createMessageWithFile(
+	ctx context.Context,
+	channelID string,
+	msg *discordOutgoingMessage,
+	filename, contentType string,
+	file []byte,
+) error {
+	msg.Attachments = []discordAttachment{{ID: "0", Filename: filename}}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("payload_json", string(payload)); err != nil {
+		return err
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="files[0]"; filename="%s"`, filename))
+	h.Set("Content-Type", contentType)
+	part, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(file); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	path := "/channels/" + url.PathEscape(channelID) + "/messages"
+	return c.do(ctx, http.MethodPost, path, buf.Bytes(), mw.FormDataContentType())
+}
+
+func (c *discordClient) reactionPath(channelID, messageID, emoji string) string {
+	return fmt.Sprintf("/channels/%s/messages/%s/reactions/%s/@me",
+		url.PathEscape(channelID), url.PathEscape(messageID), url.PathEscape(emoji))
+}
+
+func (c *discordClient) addReaction(ctx context.Context, channelID, messageID, emoji string) error {
+	return c.do(ctx, http.MethodPut, c.reactionPath(channelID, messageID, emoji), nil, "")
+}
+
+func (c *discordClient) removeReaction(ctx context.Context, channelID, messageID, emoji string) error {
+	return c.do(ctx, http.MethodDelete, c.reactionPath(channelID, messageID, emoji), nil, "")
+}
+
+// openAttachment は添付ファイルをダウンロードする（添付ファイルのURLに認証は不要）
+func (c *discordClient) openAttachment(ctx context.Context, fileURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", fileURL, resp.Status)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
@@ -0,0 +1,380 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// Gatewayのopcode
+const (
+	discordOpDispatch       = 0
+	discordOpHeartbeat      = 1
+	discordOpIdentify       = 2
+	discordOpReconnect      = 7
+	discordOpInvalidSession = 9
+	discordOpHello          = 10
+	discordOpHeartbeatACK   = 11
+)
+
+// GUILD_MESSAGES | DIRECT_MESSAGES | MESSAGE_CONTENT
+const discordIntents = 1<<9 | 1<<12 | 1<<15
+
+// メッセージの type（返信）
+const discordMessageTypeReply = 19
+
+// DiscordMessage はDiscordのメッセージ。コマンドの起動元になったメッセージは
+// cmd.CommandInput.ReplyInfo として使う。
+type DiscordMessage struct {
+	ID        string `json:"id"`
+	ChannelID string `json:"channel_id"`
+	GuildID   string `json:"guild_id"`
+	Type      int    `json:"type"`
+	Content   string `json:"content"`
+	Author    struct {
+		ID  string `json:"id"`
+		Bot bool   `json:"bot"`
+	} `json:"author"`
+	Member *struct {
+		Roles []string `json:"roles"`
+	} `json:"member"`
+	Attachments []discordAttachment `json:"attachments"`
+}
+
+type discordPayload struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+	S  *int64          `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+// DiscordTransport はDiscordのGateway (WebSocket) とREST APIを使うTransport
+type DiscordTransport struct {
+	client     *discordClient
+	gatewayURL string
+	roleIDs    []string
+	cfg        Config
+	o          *listenerOptions
+	logf       func(format string, v ...interface{})
+}
+
+// NewDiscordTransport returns a Transport for the Discord bot in dCfg.
+// cfg holds the allow-lists and reply settings shared with Slack.
+// Debug logs are written with logf (nil to discard them).
+func NewDiscordTransport(
+	dCfg DiscordConfig,
+	cfg Config,
+	logf func(format string, v ...interface{}),
+	opts ...ListenerOption,
+) *DiscordTransport {
+	o := &listenerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	gatewayURL := dCfg.GatewayURL
+	if gatewayURL == "" {
+		gatewayURL = defaultDiscordGatewayURL
+	}
+	t := &DiscordTransport{
+		client:     newDiscordClient(dCfg),
+		gatewayURL: gatewayURL,
+		roleIDs:    dCfg.AllowedRoleIDs,
+		cfg:        cfg,
+		o:          o,
+		logf:       logf,
+	}
+	o.feedback = t
+	return t
+}
+
+// Name returns "discord".
+func (t *DiscordTransport) Name() string { return "discord" }
+
+// Debugf writes a debug log.
+func (t *DiscordTransport) Debugf(format string, v ...interface{}) {
+	t.logf(format, v...)
+}
+
+// Listen はGatewayに接続し、ctxが終了するまでメッセージを監視する。
+// 接続が切れた場合は間隔を空けて再接続する。
+func (t *DiscordTransport) Listen(ctx context.Context, commandQueue chan *cmd.CommandInput) error {
+	delay := time.Second
+	for {
+		err := t.listenOnce(ctx, commandQueue)
+		t.o.health.Disconnected()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		t.Debugf("[INFO] Connection to Discord failed. Retrying in %v: %v", delay, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+		if delay < 30*time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// discordSession はGatewayとの1回分の接続
+type discordSession struct {
+	conn   *websocket.Conn
+	mu     sync.Mutex // 書き込みは同時に1つだけ
+	seq    atomic.Int64
+	selfID string
+
+	awaitingACK atomic.Bool // 定期的に送ったheartbeatへのACKを待っている
+}
+
+func (s *discordSession) send(op int, d interface{}) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn.WriteJSON(discordPayload{Op: op, D: data})
+}
+
+func (s *discordSession) heartbeat() error {
+	var d interface{} // 最初のイベントを受信するまではnull
+	if seq := s.seq.Load(); seq > 0 {
+		d = seq
+	}
+	return s.send(discordOpHeartbeat, d)
+}
+
+func (t *DiscordTransport) listenOnce(ctx context.Context, commandQueue chan *cmd.CommandInput) error {
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, t.gatewayURL, nil)
+	if err != nil {
+		return err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer func() { _ = conn.Close() }()
+	s := &discordSession{conn: conn}
+
+	var hello discordPayload
+	if err := conn.ReadJSON(&hello); err != nil {
+		return err
+	}
+	var helloData struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"` // ミリ秒
+	}
+	if err := json.Unmarshal(hello.D, &helloData); hello.Op != discordOpHello || err != nil {
+		return fmt.Errorf("unexpected first gateway payload: op=%d", hello.Op)
+	}
+	err = s.send(discordOpIdentify, map[string]interface{}{
+		"token":   t.client.token,
+		"intents": discordIntents,
+		"properties": map[string]string{
+			"os":      "linux",
+			"browser": "slack-commander",
+			"device":  "slack-commander",
+		},
+	})
+	if err != nil {
+		return err
+	}
+	hbCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go t.runHeartbeat(hbCtx, s, time.Duration(helloData.HeartbeatInterval)*time.Millisecond)
+
+	for {
+		var p discordPayload
+		if err := conn.ReadJSON(&p); err != nil {
+			return err
+		}
+		t.o.health.Activity()
+		if p.S != nil {
+			s.seq.Store(*p.S)
+		}
+		if err := t.handlePayload(s, &p, commandQueue); err != nil {
+			return err
+		}
+	}
+}
+
+func (t *DiscordTransport) runHeartbeat(ctx context.Context, s *discordSession, interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// 前回のheartbeatにACKがなければ接続が死んでいるので、切断して接続し直す
+			if s.awaitingACK.Swap(true) {
+				t.Debugf("[WARN] Discord heartbeat was not acknowledged; reconnecting")
+				_ = s.conn.Close()
+				return
+			}
+			if err := s.heartbeat(); err != nil {
+				t.Debugf("[WARN] Discord heartbeat failed: %v", err)
+				return
+			}
+		}
+	}
+}
+
+func (t *DiscordTransport) handlePayload(
+	s *discordSession,
+	p *discordPayload,
+	commandQueue chan *cmd.CommandInput,
+) error {
+	switch p.Op {
+	case discordOpHeartbeat:
+		return s.heartbeat()
+	case discordOpHeartbeatACK:
+		s.awaitingACK.Store(false)
+		return nil
+	case discordOpReconnect:
+		return errors.New("gateway requested reconnect")
+	case discordOpInvalidSession:
+		return errors.New("gateway invalidated the session")
+	case discordOpDispatch:
+	default:
+		return nil
+	}
+	switch p.T {
+	case "READY":
+		var ready struct {
+			User struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		}
+		err := json.Unmarshal(p.D, &ready)
+		t.o.health.AuthTested(err == nil)
+		if err != nil {
+			return err
+		}
+		s.selfID = ready.User.ID
+		t.o.health.Connected()
+		t.Debugf("[INFO] Connected to Discord.")
+	case "MESSAGE_CREATE":
+		var msg DiscordMessage
+		if err := json.Unmarshal(p.D, &msg); err != nil {
+			t.Debugf("[WARN] Ignored malformed MESSAGE_CREATE: %v", err)
+			return nil
+		}
+		current := t.cfg
+		if t.o.configStore != nil {
+			current = t.o.configStore.Load()
+		}
+		t.onMessageCreate(&msg, s.selfID, commandQueue, current)
+	}
+	return nil
+}
+
+func shouldIgnoreDiscordMessage(msg *DiscordMessage, selfID string, cfg Config) bool {
+	if msg.Author.ID == selfID {
+		return true
+	}
+	if msg.Author.Bot && !cfg.AcceptBotMessage {
+		return true
+	}
+	if msg.Type == discordMessageTypeReply && !cfg.AcceptThreadMessage {
+		return true
+	}
+	return false
+}
+
+func (t *DiscordTransport) onMessageCreate(
+	msg *DiscordMessage,
+	selfID string,
+	commandQueue chan *cmd.CommandInput,
+	cfg Config,
+) {
+	if shouldIgnoreDiscordMessage(msg, selfID, cfg) {
+		return
+	}
+	ctx, span := startReceiveSpan(t.Name(), "message_create", msg.Author.ID, msg.ChannelID)
+	defer span.End()
+	userAllowed := isAllowedDiscordUser(cfg, t.roleIDs, msg)
+	channelAllowed := isAllowedChannel(cfg, msg.ChannelID)
+	if !checkAllowedWith(t.Debugf, t.o, userAllowed, channelAllowed, msg.Author.ID, msg.ChannelID) {
+		return
+	}
+	text := normalizeQuotes(strings.TrimSpace(removeMentionTarget(msg.Content)))
+	if text == "" {
+		return
+	}
+	input := &cmd.CommandInput{
+		ReplyInfo: msg,
+		Text:      text,
+		Files:     t.inputFiles(msg),
+		SenderID:  msg.Author.ID,
+		ChannelID: msg.ChannelID,
+		Context:   ctx,
+	}
+	applyListenerConfig(input, cfg, t.o)
+	if !checkRateLimit(t.Debugf, t.o, input) {
+		return
+	}
+	// 実行の記録より先に残るよう、キューに入れる前に記録する
+	auditAccepted(t.o, input)
+	if !enqueueCommand(commandQueue, input) {
+		t.Debugf("[WARN] command queue is full; dropping message_create command")
+		t.o.queueFull(input)
+		return
+	}
+	t.Debugf("[DEBUG]: command = '%s'", text)
+}
+
+// isAllowedDiscordUser は発言者が allowed_user_ids に含まれるか、
+// allowed_role_ids のロールを持っているかを返す。どちらも空なら制限しない。
+func isAllowedDiscordUser(cfg Config, roleIDs []string, msg *DiscordMessage) bool {
+	if len(cfg.AllowedUserIDs) == 0 && len(roleIDs) == 0 {
+		return true
+	}
+	for _, id := range cfg.AllowedUserIDs {
+		if id == msg.Author.ID {
+			return true
+		}
+	}
+	if msg.Member == nil {
+		return false
+	}
+	for _, role := range msg.Member.Roles {
+		for _, allowed := range roleIDs {
+			if role == allowed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// inputFiles はメッセージに添付されたファイルをcmd.InputFileに変換する。
+// ファイル本体は実際に必要になった時点でダウンロードする。
+func (t *DiscordTransport) inputFiles(msg *DiscordMessage) []*cmd.InputFile {
+	if len(msg.Attachments) == 0 {
+		return nil
+	}
+	files := make([]*cmd.InputFile, 0, len(msg.Attachments))
+	for _, a := range msg.Attachments {
+		files = append(files, &cmd.InputFile{
+			Name:     a.Filename,
+			MimeType: a.ContentType,
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				return t.client.openAttachment(ctx, a.URL)
+			},
+		})
+	}
+	return files
+}
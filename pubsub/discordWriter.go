@@ -0,0 +1,125 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// Discordのメッセージとembedの長さの上限
+const (
+	discordMaxContent     = 2000
+	discordMaxDescription = 4096
+)
+
+// Slackのattachmentと同じ色を使う
+var discordColors = map[string]int{
+	"good":   0x2eb886,
+	"danger": 0xa30200,
+}
+
+// Slackのリアクション名に対応するUnicode絵文字
+var discordEmojis = map[string]string{
+	"eyes":             "\U0001F440",
+	"white_check_mark": "✅",
+	"x":                "❌",
+	"hourglass":        "⌛",
+	"warning":          "⚠️",
+	"no_entry":         "⛔",
+}
+
+// PostMessage はテキスト出力をembedとして投稿する
+func (t *DiscordTransport) PostMessage(output *cmd.CommandOutput) error {
+	if !hasMeaningfulText(output) {
+		return nil
+	}
+	channelID, msg := t.newReplyMessage(output)
+	if channelID == "" {
+		return errors.New("discord: unknown reply target")
+	}
+	embed := discordEmbed{
+		Description: truncateRunes(getText(output), discordMaxDescription),
+		Color:       discordColors[getColor(output)],
+	}
+	if footer := getFooter(output); footer != "" {
+		embed.Footer = &discordEmbedFooter{Text: footer}
+	}
+	msg.Embeds = []discordEmbed{embed}
+	return t.client.createMessage(context.Background(), channelID, msg)
+}
+
+// PostBlocks はDiscordがBlock Kitに対応していないので、JSONをコードブロックとして投稿する
+func (t *DiscordTransport) PostBlocks(output *cmd.CommandOutput) error {
+	channelID, msg := t.newReplyMessage(output)
+	if channelID == "" {
+		return errors.New("discord: unknown reply target")
+	}
+	msg.Content = truncateRunes(fmt.Sprintf("```json\n%s\n```", output.Blocks), discordMaxContent)
+	return t.client.createMessage(context.Background(), channelID, msg)
+}
+
+// UploadImage は画像を添付して投稿する
+func (t *DiscordTransport) UploadImage(output *cmd.CommandOutput) error {
+	channelID, msg := t.newReplyMessage(output)
+	if channelID == "" {
+		return errors.New("discord: unknown reply target")
+	}
+	if hasMeaningfulText(output) {
+		msg.Content = truncateRunes(getText(output), discordMaxContent)
+	}
+	return t.client.createMessageWithFile(
+		context.Background(), channelID, msg, "output.png", "image/png", output.ImageData)
+}
+
+// AddReaction はコマンドの起動元のメッセージにリアクションを付ける
+func (t *DiscordTransport) AddReaction(output *cmd.CommandOutput, name string) error {
+	src, ok := output.ReplyInfo.(*DiscordMessage)
+	if !ok {
+		// リアクションを付ける元のメッセージがない
+		return nil
+	}
+	return t.client.addReaction(context.Background(), src.ChannelID, src.ID, discordEmoji(name))
+}
+
+// RemoveReaction はコマンドの起動元のメッセージからリアクションを外す
+func (t *DiscordTransport) RemoveReaction(output *cmd.CommandOutput, name string) error {
+	src, ok := output.ReplyInfo.(*DiscordMessage)
+	if !ok {
+		return nil
+	}
+	return t.client.removeReaction(context.Background(), src.ChannelID, src.ID, discordEmoji(name))
+}
+
+// newReplyMessage は返信先のチャンネルIDと、返信の設定を反映したメッセージを返す。
+// 返信先が不明ならチャンネルIDに空文字列を返す。
+// botの投稿者名とアイコンはDiscordでは上書きできないので username などは使わない。
+func (t *DiscordTransport) newReplyMessage(output *cmd.CommandOutput) (string, *discordOutgoingMessage) {
+	msg := &discordOutgoingMessage{}
+	if r, ok := channelReplyOf(output); ok {
+		return r.Channel, msg
+	}
+	if src, ok := output.ReplyInfo.(*DiscordMessage); ok {
+		if getConfig(output).PostAsReply {
+			msg.MessageReference = &discordMessageReference{MessageID: src.ID}
+		}
+		return src.ChannelID, msg
+	}
+	return "", msg
+}
+
+func discordEmoji(name string) string {
+	if e, ok := discordEmojis[name]; ok {
+		return e
+	}
+	return name
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
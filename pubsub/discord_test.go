@@ -0,0 +1,204 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// fakeDiscordGateway はHello, READY, MESSAGE_CREATEを送るテスト用のGateway
+func fakeDiscordGateway(t *testing.T, messages ...DiscordMessage) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.WriteJSON(map[string]interface{}{"op": 10, "d": map[string]int{"heartbeat_interval": 45000}})
+		var identify discordPayload
+		if err := conn.ReadJSON(&identify); err != nil || identify.Op != discordOpIdentify {
+			return
+		}
+		_ = conn.WriteJSON(map[string]interface{}{
+			"op": 0, "s": 1, "t": "READY", "d": map[string]interface{}{"user": map[string]string{"id": "BOT"}},
+		})
+		for i, m := range messages {
+			_ = conn.WriteJSON(map[string]interface{}{"op": 0, "s": i + 2, "t": "MESSAGE_CREATE", "d": m})
+		}
+		_, _, _ = conn.ReadMessage()
+	}))
+}
+
+func discordMessage(id, user, channel, content string, roles ...string) DiscordMessage {
+	m := DiscordMessage{ID: id, ChannelID: channel, Content: content}
+	m.Author.ID = user
+	if roles != nil {
+		m.Member = &struct {
+			Roles []string `json:"roles"`
+		}{Roles: roles}
+	}
+	return m
+}
+
+func TestDiscordListenEnqueuesMessages(t *testing.T) {
+	gw := fakeDiscordGateway(t,
+		discordMessage("M0", "BOT", "C1", "self"),
+		discordMessage("M1", "U2", "C1", "denied", "R9"),
+		discordMessage("M2", "U3", "C1", "<@BOT> date", "R1"),
+		discordMessage("M3", "U1", "C1", "uptime"),
+	)
+	defer gw.Close()
+
+	tr := NewDiscordTransport(
+		DiscordConfig{
+			Token:          "test-token",
+			AllowedRoleIDs: []string{"R1"},
+			GatewayURL:     "ws" + strings.TrimPrefix(gw.URL, "http"),
+		},
+		Config{AllowedUserIDs: []string{"U1"}},
+		nil,
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := make(chan *cmd.CommandInput, 10)
+	done := make(chan error, 1)
+	go func() { done <- tr.Listen(ctx, q) }()
+
+	var texts []string
+	for len(texts) < 2 {
+		select {
+		case input := <-q:
+			texts = append(texts, input.SenderID+":"+input.Text)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("commands not received: %q", texts)
+		}
+	}
+	if strings.Join(texts, ",") != "U3:date,U1:uptime" {
+		t.Fatalf("unexpected inputs: %q", texts)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not return")
+	}
+}
+
+func TestDiscordWriter(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bot test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.EscapedPath()+" "+string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer api.Close()
+	tr := NewDiscordTransport(DiscordConfig{Token: "test-token", APIURL: api.URL}, Config{}, nil)
+
+	src := &DiscordMessage{ID: "M1", ChannelID: "C1"}
+	q := make(chan *cmd.CommandOutput, 10)
+	q <- &cmd.CommandOutput{ReplyInfo: src, Spawned: true}
+	q <- &cmd.CommandOutput{
+		ReplyInfo:   src,
+		ReplyConfig: &ReplyConfig{PostAsReply: true},
+		Text:        "boom",
+		IsErrOut:    true,
+	}
+	q <- &cmd.CommandOutput{ReplyInfo: src, Finished: true, ExitCode: 1}
+	close(q)
+	Writer(context.Background(), func() Transport { return tr }, q)
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"PUT /channels/C1/messages/M1/reactions/%F0%9F%91%80/@me ",
+		"POST /channels/C1/messages ",
+		"PUT /channels/C1/messages/M1/reactions/%E2%9D%8C/@me ",
+		"DELETE /channels/C1/messages/M1/reactions/%F0%9F%91%80/@me ",
+	}
+	if len(requests) != len(want) {
+		t.Fatalf("unexpected requests: %q", requests)
+	}
+	for i := range want {
+		if !strings.HasPrefix(requests[i], want[i]) {
+			t.Errorf("request %d = %q, want prefix %q", i, requests[i], want[i])
+		}
+	}
+	var msg discordOutgoingMessage
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(requests[1], want[1])), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MessageReference == nil || msg.MessageReference.MessageID != "M1" ||
+		len(msg.Embeds) != 1 || msg.Embeds[0].Description != "boom" ||
+		msg.Embeds[0].Color != discordColors["danger"] {
+		t.Fatalf("unexpected message: %+v", msg)
+	}
+}
+
+// fakeDiscordHeartbeatGateway は短い間隔でheartbeatを要求するテスト用のGateway。
+// ack がfalseならheartbeatにACKを返さない。
+func fakeDiscordHeartbeatGateway(t *testing.T, ack bool) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_ = conn.WriteJSON(map[string]interface{}{"op": 10, "d": map[string]int{"heartbeat_interval": 20}})
+		for {
+			var p discordPayload
+			if err := conn.ReadJSON(&p); err != nil {
+				return
+			}
+			if p.Op == discordOpHeartbeat && ack {
+				_ = conn.WriteJSON(map[string]interface{}{"op": discordOpHeartbeatACK})
+			}
+		}
+	}))
+}
+
+func TestDiscordClosesConnectionWithoutHeartbeatACK(t *testing.T) {
+	for _, ack := range []bool{true, false} {
+		gw := fakeDiscordHeartbeatGateway(t, ack)
+		tr := NewDiscordTransport(
+			DiscordConfig{Token: "test-token", GatewayURL: "ws" + strings.TrimPrefix(gw.URL, "http")},
+			Config{},
+			nil,
+		)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- tr.listenOnce(ctx, make(chan *cmd.CommandInput)) }()
+		select {
+		case err := <-done:
+			if ack {
+				t.Fatalf("connection closed although heartbeats were acknowledged: %v", err)
+			}
+		case <-time.After(500 * time.Millisecond):
+			if !ack {
+				t.Fatal("connection was kept without heartbeat ACK")
+			}
+			cancel()
+			<-done
+		}
+		cancel()
+		gw.Close()
+	}
+}
@@ -1,2 +1,3 @@
-// Package pubsub provides Slack event ingestion and reply publishing.
+// Package pubsub provides chat transports (Slack, Mattermost and Discord) that ingest
+// command messages and publish replies.
 package pubsub
@@ -0,0 +1,203 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mattermostClient はMattermostのREST API (v4) を呼び出す
+type mattermostClient struct {
+	baseURL string // 末尾の / は含まない
+	token   string
+	http    *http.Client
+
+	mu sync.Mutex
+	me *mattermostUser // bot自身（初回の呼び出しで取得する）
+}
+
+type mattermostUser struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type mattermostFileInfo struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	MimeType string `json:"mime_type"`
+}
+
+func newMattermostClient(cfg MattermostConfig) *mattermostClient {
+	return &mattermostClient{
+		baseURL: strings.TrimRight(cfg.URL, "/"),
+		token:   cfg.Token,
+		http:    &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+// websocketURL はイベントを受信するWebSocketのURLを返す
+func (c *mattermostClient) websocketURL() (string, error) {
+	u, err := url.Parse(c.baseURL + "/api/v4/websocket")
+	if err != nil {
+		return "", err
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	return u.String(), nil
+}
+
+func (c *mattermostClient) authHeader() http.Header {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer "+c.token)
+	return h
+}
+
+// do はAPIを呼び出し、レスポンスのJSONをoutに読み込む（outがnilなら読み捨てる）
+func (c *mattermostClient) do(
+	ctx context.Context,
+	method, path string,
+	body io.Reader,
+	contentType string,
+	out interface{},
+) error {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header = c.authHeader()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(data)))
+	}
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *mattermostClient) doJSON(ctx context.Context, method, path string, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, method, path, bytes.NewReader(data), "application/json", out)
+}
+
+// getMe はbot自身のユーザー情報を返す。取得に成功した結果はキャッシュする。
+func (c *mattermostClient) getMe(ctx context.Context) (*mattermostUser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.me != nil {
+		return c.me, nil
+	}
+	var me mattermostUser
+	if err := c.do(ctx, http.MethodGet, "/api/v4/users/me", nil, "", &me); err != nil {
+		return nil, err
+	}
+	c.me = &me
+	return c.me, nil
+}
+
+func (c *mattermostClient) createPost(ctx context.Context, post *MattermostPost) error {
+	return c.doJSON(ctx, http.MethodPost, "/api/v4/posts", post, nil)
+}
+
+func (c *mattermostClient) addReaction(ctx context.Context, postID, emoji string) error {
+	me, err := c.getMe(ctx)
+	if err != nil {
+		return err
+	}
+	return c.doJSON(ctx, http.MethodPost, "/api/v4/reactions", map[string]string{
+		"user_id":    me.ID,
+		"post_id":    postID,
+		"emoji_name": emoji,
+	}, nil)
+}
+
+func (c *mattermostClient) removeReaction(ctx context.Context, postID, emoji string) error {
+	me, err := c.getMe(ctx)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v4/users/%s/posts/%s/reactions/%s",
+		url.PathEscape(me.ID), url.PathEscape(postID), url.PathEscape(emoji))
+	return c.do(ctx, http.MethodDelete, path, nil, "", nil)
+}
+
+// uploadFile はファイルをアップロードしてファイルIDを返す
+func (c *mattermostClient) uploadFile(
+	ctx context.Context,
+	channelID, filename string,
+	data []byte,
+) (string, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.WriteField("channel_id", channelID); err != nil {
+		return "", err
+	}
+	part, err := mw.CreateFormFile("files", filename)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(data); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	var resp struct {
+		FileInfos []mattermostFileInfo `json:"file_infos"`
+	}
+	err = c.do(ctx, http.MethodPost, "/api/v4/files", &buf, mw.FormDataContentType(), &resp)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.FileInfos) == 0 || resp.FileInfos[0].ID == "" {
+		return "", fmt.Errorf("uploadFile: missing file ID")
+	}
+	return resp.FileInfos[0].ID, nil
+}
+
+// openFile は添付ファイルをダウンロードする
+func (c *mattermostClient) openFile(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	var buf bytes.Buffer
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, c.baseURL+"/api/v4/files/"+url.PathEscape(fileID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = c.authHeader()
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET file %s: %s", fileID, resp.Status)
+	}
+	if _, err := io.Copy(&buf, resp.Body); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
@@ -0,0 +1,249 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// MattermostPost はMattermostの投稿。コマンドの起動元になった投稿は
+// cmd.CommandInput.ReplyInfo として使う。
+type MattermostPost struct {
+	ID        string                 `json:"id,omitempty"`
+	ChannelID string                 `json:"channel_id"`
+	UserID    string                 `json:"user_id,omitempty"`
+	RootID    string                 `json:"root_id,omitempty"`
+	Message   string                 `json:"message"`
+	Type      string                 `json:"type,omitempty"`
+	FileIDs   []string               `json:"file_ids,omitempty"`
+	Props     map[string]interface{} `json:"props,omitempty"`
+	Metadata  *struct {
+		Files []mattermostFileInfo `json:"files"`
+	} `json:"metadata,omitempty"`
+}
+
+// mattermostEvent はWebSocketで受信するイベント
+type mattermostEvent struct {
+	Event string                     `json:"event"`
+	Data  map[string]json.RawMessage `json:"data"`
+}
+
+// MattermostTransport はMattermostのWebSocket APIとREST APIを使うTransport
+type MattermostTransport struct {
+	client *mattermostClient
+	cfg    Config
+	o      *listenerOptions
+	logf   func(format string, v ...interface{})
+}
+
+// NewMattermostTransport returns a Transport for the Mattermost server in mmCfg.
+// cfg holds the allow-lists and reply settings shared with Slack.
+// Debug logs are written with logf (nil to discard them).
+func NewMattermostTransport(
+	mmCfg MattermostConfig,
+	cfg Config,
+	logf func(format string, v ...interface{}),
+	opts ...ListenerOption,
+) *MattermostTransport {
+	o := &listenerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	t := &MattermostTransport{client: newMattermostClient(mmCfg), cfg: cfg, o: o, logf: logf}
+	o.feedback = t
+	return t
+}
+
+// Name returns "mattermost".
+func (t *MattermostTransport) Name() string { return "mattermost" }
+
+// Debugf writes a debug log.
+func (t *MattermostTransport) Debugf(format string, v ...interface{}) {
+	t.logf(format, v...)
+}
+
+// Listen はWebSocketでMattermostに接続し、ctxが終了するまで投稿を監視する。
+// 接続が切れた場合は間隔を空けて再接続する。
+func (t *MattermostTransport) Listen(ctx context.Context, commandQueue chan *cmd.CommandInput) error {
+	delay := time.Second
+	for {
+		connected, err := t.listenOnce(ctx, commandQueue)
+		t.o.health.Disconnected()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if connected {
+			// 接続できていたなら、切れた後は最初の間隔から数え直す
+			delay = time.Second
+		}
+		t.Debugf("[INFO] Connection to Mattermost failed. Retrying in %v: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay < 30*time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// listenOnce は1回分の接続で投稿を監視する。接続できた後に切れた場合は connected がtrueになる。
+func (t *MattermostTransport) listenOnce(
+	ctx context.Context,
+	commandQueue chan *cmd.CommandInput,
+) (connected bool, err error) {
+	me, err := t.client.getMe(ctx)
+	t.o.health.AuthTested(err == nil)
+	if err != nil {
+		return false, err
+	}
+	wsURL, err := t.client.websocketURL()
+	if err != nil {
+		return false, err
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL, t.client.authHeader())
+	if err != nil {
+		return false, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+	defer func() { _ = conn.Close() }()
+	t.o.health.Connected()
+	t.Debugf("[INFO] Connected to Mattermost.")
+	mention := mattermostMentionPattern(me.Username)
+	for {
+		var ev mattermostEvent
+		if err := conn.ReadJSON(&ev); err != nil {
+			return true, err
+		}
+		t.o.health.Activity()
+		if ev.Event != "posted" {
+			continue
+		}
+		post, err := parsePostedEvent(ev)
+		if err != nil {
+			t.Debugf("[WARN] Ignored malformed posted event: %v", err)
+			continue
+		}
+		current := t.cfg
+		if t.o.configStore != nil {
+			current = t.o.configStore.Load()
+		}
+		t.onPosted(post, me, mention, commandQueue, current)
+	}
+}
+
+func parsePostedEvent(ev mattermostEvent) (*MattermostPost, error) {
+	// data.post はJSONを文字列として埋め込んだもの
+	var raw string
+	if err := json.Unmarshal(ev.Data["post"], &raw); err != nil {
+		return nil, err
+	}
+	var post MattermostPost
+	if err := json.Unmarshal([]byte(raw), &post); err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func shouldIgnoreMattermostPost(post *MattermostPost, me *mattermostUser, cfg Config) bool {
+	if post.UserID == me.ID || post.Type != "" {
+		// 自身の投稿とシステムメッセージは無視する
+		return true
+	}
+	if fromBot, _ := post.Props["from_bot"].(string); fromBot == "true" && !cfg.AcceptBotMessage {
+		return true
+	}
+	if post.RootID != "" && !cfg.AcceptThreadMessage {
+		return true
+	}
+	return false
+}
+
+func (t *MattermostTransport) onPosted(
+	post *MattermostPost,
+	me *mattermostUser,
+	mention *regexp.Regexp,
+	commandQueue chan *cmd.CommandInput,
+	cfg Config,
+) {
+	if shouldIgnoreMattermostPost(post, me, cfg) {
+		return
+	}
+	ctx, span := startReceiveSpan(t.Name(), "posted", post.UserID, post.ChannelID)
+	defer span.End()
+	if !checkAllowed(t.Debugf, cfg, t.o, post.UserID, post.ChannelID) {
+		return
+	}
+	text := normalizeQuotes(removeMattermostMention(post.Message, mention))
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	input := &cmd.CommandInput{
+		ReplyInfo: post,
+		Text:      text,
+		Files:     t.inputFiles(post),
+		SenderID:  post.UserID,
+		ChannelID: post.ChannelID,
+		Context:   ctx,
+	}
+	applyListenerConfig(input, cfg, t.o)
+	if !checkRateLimit(t.Debugf, t.o, input) {
+		return
+	}
+	// 実行の記録より先に残るよう、キューに入れる前に記録する
+	auditAccepted(t.o, input)
+	if !enqueueCommand(commandQueue, input) {
+		t.Debugf("[WARN] command queue is full; dropping posted command")
+		t.o.queueFull(input)
+		return
+	}
+	t.Debugf("[DEBUG]: command = '%s'", text)
+}
+
+// mattermostMentionPattern はbot宛てのメンション（@username）にマッチする正規表現を返す。
+// username が空ならnilを返す。
+func mattermostMentionPattern(username string) *regexp.Regexp {
+	if username == "" {
+		return nil
+	}
+	return regexp.MustCompile(`(^|\s)@` + regexp.QuoteMeta(username) + `\b:?`)
+}
+
+// removeMattermostMention は投稿中の mention にマッチするメンションを取り除く
+func removeMattermostMention(message string, mention *regexp.Regexp) string {
+	if mention == nil {
+		return message
+	}
+	return strings.TrimSpace(mention.ReplaceAllString(message, "$1"))
+}
+
+// inputFiles は投稿に添付されたファイルをcmd.InputFileに変換する。
+// ファイル本体は実際に必要になった時点でダウンロードする。
+func (t *MattermostTransport) inputFiles(post *MattermostPost) []*cmd.InputFile {
+	if post.Metadata == nil || len(post.Metadata.Files) == 0 {
+		return nil
+	}
+	files := make([]*cmd.InputFile, 0, len(post.Metadata.Files))
+	for _, f := range post.Metadata.Files {
+		files = append(files, &cmd.InputFile{
+			Name:     f.Name,
+			MimeType: f.MimeType,
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				return t.client.openFile(ctx, f.ID)
+			},
+		})
+	}
+	return files
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// Slackのattachmentと同じ色を使う
+var mattermostColors = map[string]string{
+	"good":   "#2eb886",
+	"danger": "#a30200",
+}
+
+// PostMessage はテキスト出力をattachmentとして投稿する
+func (t *MattermostTransport) PostMessage(output *cmd.CommandOutput) error {
+	if !hasMeaningfulText(output) {
+		return nil
+	}
+	post := t.newReplyPost(output)
+	if post == nil {
+		return errors.New("mattermost: unknown reply target")
+	}
+	post.Props["attachments"] = []map[string]string{{
+		"text":   getText(output),
+		"color":  mattermostColors[getColor(output)],
+		"footer": getFooter(output),
+	}}
+	return t.client.createPost(context.Background(), post)
+}
+
+// PostBlocks はMattermostがBlock Kitに対応していないので、JSONをコードブロックとして投稿する
+func (t *MattermostTransport) PostBlocks(output *cmd.CommandOutput) error {
+	post := t.newReplyPost(output)
+	if post == nil {
+		return errors.New("mattermost: unknown reply target")
+	}
+	post.Message = fmt.Sprintf("```json\n%s\n```", output.Blocks)
+	return t.client.createPost(context.Background(), post)
+}
+
+// UploadImage は画像をアップロードし、テキスト出力があれば添えて投稿する
+func (t *MattermostTransport) UploadImage(output *cmd.CommandOutput) error {
+	post := t.newReplyPost(output)
+	if post == nil {
+		return errors.New("mattermost: unknown reply target")
+	}
+	ctx := context.Background()
+	fileID, err := t.client.uploadFile(ctx, post.ChannelID, "output.png", output.ImageData)
+	if err != nil {
+		return err
+	}
+	post.FileIDs = []string{fileID}
+	if hasMeaningfulText(output) {
+		post.Message = getText(output)
+	}
+	return t.client.createPost(ctx, post)
+}
+
+// AddReaction はコマンドの起動元の投稿にリアクションを付ける
+func (t *MattermostTransport) AddReaction(output *cmd.CommandOutput, name string) error {
+	src, ok := output.ReplyInfo.(*MattermostPost)
+	if !ok {
+		// リアクションを付ける元の投稿がない
+		return nil
+	}
+	return t.client.addReaction(context.Background(), src.ID, name)
+}
+
+// RemoveReaction はコマンドの起動元の投稿からリアクションを外す
+func (t *MattermostTransport) RemoveReaction(output *cmd.CommandOutput, name string) error {
+	src, ok := output.ReplyInfo.(*MattermostPost)
+	if !ok {
+		return nil
+	}
+	return t.client.removeReaction(context.Background(), src.ID, name)
+}
+
+// newReplyPost は返信先と返信の設定を反映した投稿を返す。返信先が不明ならnilを返す。
+func (t *MattermostTransport) newReplyPost(output *cmd.CommandOutput) *MattermostPost {
+	cfg := getConfig(output)
+	post := &MattermostPost{Props: map[string]interface{}{}}
+	if r, ok := channelReplyOf(output); ok {
+		post.ChannelID = r.Channel
+		post.RootID = r.ThreadTimeStamp
+	}
+	if src, ok := output.ReplyInfo.(*MattermostPost); ok {
+		post.ChannelID = src.ChannelID
+		if cfg.PostAsReply {
+			post.RootID = src.RootID
+			if post.RootID == "" {
+				post.RootID = src.ID
+			}
+		}
+	}
+	if post.ChannelID == "" {
+		return nil
+	}
+	// 投稿者名とアイコンの上書きはサーバー側で許可されている場合だけ反映される
+	if cfg.Username != "" {
+		post.Props["override_username"] = cfg.Username
+	}
+	if cfg.IconURL != "" {
+		post.Props["override_icon_url"] = cfg.IconURL
+	}
+	if cfg.IconEmoji != "" {
+		post.Props["override_icon_emoji"] = cfg.IconEmoji
+	}
+	return post
+}
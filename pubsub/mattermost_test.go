@@ -0,0 +1,169 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// fakeMattermost はMattermostのAPIの一部を真似るテスト用サーバー
+type fakeMattermost struct {
+	mu       sync.Mutex
+	requests []string // "METHOD path body"
+	events   []string // WebSocketで送るイベント
+}
+
+func (f *fakeMattermost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/v4/users/me":
+		_, _ = io.WriteString(w, `{"id":"BOT","username":"commander"}`)
+		return
+	case "/api/v4/websocket":
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		for _, ev := range f.events {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(ev))
+		}
+		// クライアントが切断するまで待つ
+		_, _, _ = conn.ReadMessage()
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+" "+string(body))
+	f.mu.Unlock()
+	_, _ = io.WriteString(w, `{}`)
+}
+
+func postedEvent(t *testing.T, post MattermostPost) string {
+	t.Helper()
+	p, err := json.Marshal(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, err := json.Marshal(map[string]interface{}{
+		"event": "posted",
+		"data":  map[string]string{"post": string(p)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(ev)
+}
+
+func TestMattermostListenEnqueuesPosts(t *testing.T) {
+	fake := &fakeMattermost{}
+	fake.events = []string{
+		`{"event":"hello","data":{}}`,
+		postedEvent(t, MattermostPost{ID: "P0", ChannelID: "C1", UserID: "BOT", Message: "self"}),
+		postedEvent(t, MattermostPost{ID: "P1", ChannelID: "C1", UserID: "U2", Message: "denied"}),
+		postedEvent(t, MattermostPost{ID: "P2", ChannelID: "C1", UserID: "U1", Message: "@commander date"}),
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	tr := NewMattermostTransport(
+		MattermostConfig{URL: srv.URL, Token: "test-token"},
+		Config{AllowedUserIDs: []string{"U1"}},
+		nil,
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := make(chan *cmd.CommandInput, 10)
+	done := make(chan error, 1)
+	go func() { done <- tr.Listen(ctx, q) }()
+
+	select {
+	case input := <-q:
+		if input.Text != "date" || input.SenderID != "U1" || input.ChannelID != "C1" {
+			t.Fatalf("unexpected input: %+v", input)
+		}
+		if post, ok := input.ReplyInfo.(*MattermostPost); !ok || post.ID != "P2" {
+			t.Fatalf("unexpected reply info: %#v", input.ReplyInfo)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no command received")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Listen did not return")
+	}
+	if len(q) != 0 {
+		t.Fatalf("unexpected extra inputs: %d", len(q))
+	}
+}
+
+func TestMattermostWriter(t *testing.T) {
+	fake := &fakeMattermost{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	tr := NewMattermostTransport(MattermostConfig{URL: srv.URL, Token: "test-token"}, Config{}, nil)
+
+	src := &MattermostPost{ID: "P1", ChannelID: "C1"}
+	replyCfg := &ReplyConfig{Username: "bot", PostAsReply: true}
+	q := make(chan *cmd.CommandOutput, 10)
+	q <- &cmd.CommandOutput{ReplyInfo: src, Spawned: true}
+	q <- &cmd.CommandOutput{ReplyInfo: src, ReplyConfig: replyCfg, Text: "hello"}
+	q <- &cmd.CommandOutput{ReplyInfo: src, Finished: true}
+	close(q)
+	Writer(context.Background(), func() Transport { return tr }, q)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.requests) != 4 {
+		t.Fatalf("unexpected requests: %q", fake.requests)
+	}
+	wantPrefixes := []string{
+		`POST /api/v4/reactions {"emoji_name":"eyes","post_id":"P1","user_id":"BOT"}`,
+		`POST /api/v4/posts {"channel_id":"C1","root_id":"P1","message":"",`,
+		`POST /api/v4/reactions {"emoji_name":"white_check_mark"`,
+		`DELETE /api/v4/users/BOT/posts/P1/reactions/eyes`,
+	}
+	for i, want := range wantPrefixes {
+		if !strings.HasPrefix(fake.requests[i], want) {
+			t.Errorf("request %d = %q, want prefix %q", i, fake.requests[i], want)
+		}
+	}
+	if !strings.Contains(fake.requests[1], `"override_username":"bot"`) ||
+		!strings.Contains(fake.requests[1], `"text":"hello"`) {
+		t.Errorf("unexpected post: %s", fake.requests[1])
+	}
+}
+
+func TestRemoveMattermostMention(t *testing.T) {
+	tests := map[string]string{
+		"@commander date":     "date",
+		"@commander: date":    "date",
+		"date @commander":     "date",
+		"@commanderx date":    "@commanderx date",
+		"mail a@commander.jp": "mail a@commander.jp",
+	}
+	mention := mattermostMentionPattern("commander")
+	for in, want := range tests {
+		if got := removeMattermostMention(in, mention); got != want {
+			t.Errorf("removeMattermostMention(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := removeMattermostMention("@commander date", mattermostMentionPattern("")); got != "@commander date" {
+		t.Errorf("unexpected result without username: %q", got)
+	}
+}
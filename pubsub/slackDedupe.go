@@ -0,0 +1,163 @@
+package pubsub
+
+import (
+	"sync"
+	"time"
+)
+
+// Config.PreferEvent の値。メンションを含むメッセージで message と app_mention の両方のイベントが届いた場合に、
+// どちらのイベントからコマンドを実行するか。空なら先に届いた方を使う。
+const (
+	PreferMessage    = "message"
+	PreferAppMention = "app_mention"
+)
+
+// duplicateEventGrace は PreferEvent のイベントが届くのを待つ時間。
+// 優先しない方のイベントが先に届いた場合は、この時間だけ待ってから実行する。
+const duplicateEventGrace = 2 * time.Second
+
+// eventDeduper はSlackの同じメッセージから起動するコマンドを1回だけ実行する。
+// 再接続後の再送や、1つのメッセージに対する message と app_mention の両方のイベントを、
+// チャンネルとts、client_msg_id を元に重複と判定する。Events APIの再送は event_id でも判定する。
+// 判定に使ったキーは slackEventDedupeTTL の間覚えておく。
+type eventDeduper struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	grace time.Duration
+	now   func() time.Time
+	seen  map[string]*seenEvent
+}
+
+// seenEvent は受け取ったメッセージ
+type seenEvent struct {
+	at   time.Time
+	held *time.Timer // PreferEvent のイベントを待っている間、保留しているイベントを実行するタイマー
+}
+
+// dedupeKey は重複の判定に使うメッセージの識別子
+type dedupeKey struct {
+	Channel     string
+	TimeStamp   string
+	ClientMsgID string // 空でもよい（app_mention イベントには含まれない）
+}
+
+// MessageState はSlackのメッセージに関する状態（重複判定に使うメッセージ、botの返信）を保持する。
+// 再接続や設定の再読み込みでTransportを作り直しても引き継げるように、ワークスペースごとに1つ作って
+// WithMessageState で渡す。
+type MessageState struct {
+	dedupe  *eventDeduper
+	replies *sentReplies
+}
+
+// NewMessageState returns an empty MessageState.
+func NewMessageState() *MessageState {
+	return &MessageState{dedupe: newEventDeduper(), replies: newSentReplies()}
+}
+
+// WithMessageState makes the Slack transport keep the state of messages in s,
+// so that redeliveries after a reconnect are still detected and earlier replies
+// can still be deleted.
+func WithMessageState(s *MessageState) ListenerOption {
+	return func(o *listenerOptions) {
+		o.dedupe = s.dedupe
+		o.replies = s.replies
+	}
+}
+
+func newEventDeduper() *eventDeduper {
+	return &eventDeduper{
+		ttl:   slackEventDedupeTTL,
+		grace: duplicateEventGrace,
+		now:   time.Now,
+		seen:  map[string]*seenEvent{},
+	}
+}
+
+func (k dedupeKey) keys() []string {
+	keys := []string{"ts/" + k.Channel + "/" + k.TimeStamp}
+	if k.ClientMsgID != "" {
+		// クライアントが投稿をやり直した場合は ts が変わっても client_msg_id は同じになる
+		keys = append(keys, "client_msg_id/"+k.ClientMsgID)
+	}
+	return keys
+}
+
+// admit は key のメッセージを初めて受け取った場合に run を呼ぶ。重複なら何もしない。
+// kind はイベントの種類（message か app_mention）、mayHaveTwin は同じメッセージの
+// もう一方の種類のイベントが届きうるか。prefer が kind と異なり mayHaveTwin がtrueなら、
+// prefer のイベントが届くのを grace の間待ち、届かなかった場合だけ run を呼ぶ。
+func (d *eventDeduper) admit(key dedupeKey, kind, prefer string, mayHaveTwin bool, run func()) {
+	if d == nil {
+		run()
+		return
+	}
+	keys := key.keys()
+	d.mu.Lock()
+	d.forgetExpired()
+	for _, k := range keys {
+		e, ok := d.seen[k]
+		if !ok {
+			continue
+		}
+		if e.held != nil && kind == prefer {
+			// 保留していた方のイベントをやめて、こちらを実行する
+			e.held.Stop()
+			e.held = nil
+			d.mu.Unlock()
+			run()
+			return
+		}
+		d.mu.Unlock()
+		return
+	}
+	e := &seenEvent{at: d.now()}
+	for _, k := range keys {
+		d.seen[k] = e
+	}
+	if prefer == "" || prefer == kind || !mayHaveTwin {
+		d.mu.Unlock()
+		run()
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(d.grace, func() {
+		d.mu.Lock()
+		if e.held != timer {
+			// 優先するイベントが先に実行された
+			d.mu.Unlock()
+			return
+		}
+		e.held = nil
+		d.mu.Unlock()
+		run()
+	})
+	e.held = timer
+	d.mu.Unlock()
+}
+
+// seenEventID は event_id のイベントを既に受け取っていればtrueを返す。
+// 初めてなら受け取ったことを覚えてfalseを返す。
+func (d *eventDeduper) seenEventID(eventID string) bool {
+	if d == nil || eventID == "" {
+		return false
+	}
+	key := "event_id/" + eventID
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.forgetExpired()
+	if _, ok := d.seen[key]; ok {
+		return true
+	}
+	d.seen[key] = &seenEvent{at: d.now()}
+	return false
+}
+
+// forgetExpired は ttl より前に受け取ったメッセージを忘れる。d.mu を持った状態で呼ぶ。
+func (d *eventDeduper) forgetExpired() {
+	now := d.now()
+	for k, e := range d.seen {
+		if e.held == nil && now.Sub(e.at) > d.ttl {
+			delete(d.seen, k)
+		}
+	}
+}
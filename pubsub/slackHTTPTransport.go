@@ -0,0 +1,180 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// SlackEventsPath はEvents APIのリクエストを受け付けるパス
+const SlackEventsPath = "/slack/events"
+
+// 再送されたイベントを重複と判定するためにevent_idを覚えておく時間。
+// Slackは失敗したイベントを1時間以内に最大3回再送する。
+const slackEventDedupeTTL = time.Hour
+
+// SlackHTTPTransport はEvents APIのリクエストをHTTPで受け取るSlackのTransport
+type SlackHTTPTransport struct {
+	slackPoster
+	addr   string
+	secret string
+	cfg    Config
+	o      *listenerOptions
+	selfID string // bot自身のuser ID。Listen がサーバーを起動する前に設定する。
+}
+
+// NewSlackHTTPTransport returns a Transport that receives Events API requests
+// on cfg.HTTPListenAddr and verifies them with cfg.SlackSigningSecret.
+func NewSlackHTTPTransport(api *slack.Client, cfg Config, opts ...ListenerOption) *SlackHTTPTransport {
+	o := &listenerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.dedupe == nil {
+		o.dedupe = newEventDeduper()
+	}
+	t := &SlackHTTPTransport{
+		slackPoster: newSlackPoster(api, opts),
+		addr:        cfg.HTTPListenAddr,
+		secret:      cfg.SlackSigningSecret,
+		cfg:         cfg,
+		o:           o,
+	}
+	o.feedback = t
+	return t
+}
+
+// Listen はctxが終了するまでHTTPサーバーでEvents APIのリクエストを受け付ける
+func (t *SlackHTTPTransport) Listen(ctx context.Context, commandQueue chan *cmd.CommandInput) error {
+	authTest, err := t.api.AuthTestContext(ctx)
+	t.o.health.AuthTested(err == nil)
+	if err != nil {
+		t.Debugf("[WARN] AuthTest() failed. Continue without bot user ID: %v", err)
+	} else {
+		t.selfID = authTest.UserID
+	}
+	mux := http.NewServeMux()
+	mux.Handle(SlackEventsPath, t.Handler(commandQueue))
+	srv := &http.Server{
+		Addr:              t.addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	t.o.health.Connected()
+	defer t.o.health.Disconnected()
+	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Handler はEvents APIのリクエストを処理するhttp.Handlerを返す
+func (t *SlackHTTPTransport) Handler(commandQueue chan *cmd.CommandInput) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := t.readVerifiedBody(r)
+		if err != nil {
+			t.Debugf("[WARN] Rejected Events API request: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		t.o.health.Activity()
+		ev, err := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+		if err != nil {
+			t.Debugf("[WARN] Failed to parse Events API request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch ev.Type {
+		case slackevents.URLVerification:
+			var v slackevents.ChallengeResponse
+			if err := json.Unmarshal(body, &v); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, v.Challenge)
+		case slackevents.CallbackEvent:
+			// Slackは3秒以内に応答しないと再送するので、イベントを処理する前に応答を送り出す。
+			// WriteHeader だけではハンドラーが戻るまで送られないので Flush する。
+			w.WriteHeader(http.StatusOK)
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Debugf("[WARN] Failed to flush Events API response: %v", err)
+			}
+			if t.isDuplicate(body, r.Header.Get("X-Slack-Retry-Num")) {
+				return
+			}
+			t.onCallbackEvent(ev, commandQueue)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+}
+
+// readVerifiedBody はリクエストボディを読み、X-Slack-Signature を検証する
+func (t *SlackHTTPTransport) readVerifiedBody(r *http.Request) ([]byte, error) {
+	sv, err := slack.NewSecretsVerifier(r.Header, t.secret)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sv.Write(body); err != nil {
+		return nil, err
+	}
+	if err := sv.Ensure(); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// isDuplicate は同じevent_idのイベントを既に受け取っていればtrueを返す。
+// 受け取ったevent_idはプロセスのメモリに覚えるので、別のプロセスに届いた再送は判定できない。
+func (t *SlackHTTPTransport) isDuplicate(body []byte, retryNum string) bool {
+	var outer struct {
+		EventID string `json:"event_id"`
+	}
+	if err := json.Unmarshal(body, &outer); err != nil {
+		return false
+	}
+	if t.o.dedupe.seenEventID(outer.EventID) {
+		t.Debugf("[INFO] Ignored duplicate event %s (retry %s)", outer.EventID, retryNum)
+		return true
+	}
+	return false
+}
+
+func (t *SlackHTTPTransport) onCallbackEvent(ev slackevents.EventsAPIEvent, commandQueue chan *cmd.CommandInput) {
+	current := t.cfg
+	if t.o.configStore != nil {
+		current = t.o.configStore.Load()
+	}
+	switch inner := ev.InnerEvent.Data.(type) {
+	case *slackevents.MessageEvent:
+		onMessageEvent(t.api, t.selfID, inner, commandQueue, current, t.o)
+	case *slackevents.AppMentionEvent:
+		onAppMentionEvent(t.api, t.selfID, inner, commandQueue, current, t.o)
+	default:
+		t.Debugf("[INFO] Unsupported inner event type: %v", inner)
+	}
+}
@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+const testSigningSecret = "test-secret"
+
+func signedEventRequest(t *testing.T, body string, secret string) *http.Request {
+	t.Helper()
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = io.WriteString(mac, "v0:"+ts+":"+body)
+	req := httptest.NewRequest(http.MethodPost, SlackEventsPath, strings.NewReader(body))
+	req.Header.Set("X-Slack-Request-Timestamp", ts)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func newTestSlackHTTPTransport(opts ...ListenerOption) *SlackHTTPTransport {
+	return NewSlackHTTPTransport(
+		slack.New("xoxb-test"),
+		Config{
+			SlackSigningSecret:  testSigningSecret,
+			AllowedUserIDs:      []string{"U123"},
+			AcceptThreadMessage: true,
+		},
+		opts...,
+	)
+}
+
+func TestSlackHTTPTransportURLVerification(t *testing.T) {
+	tr := newTestSlackHTTPTransport()
+	h := tr.Handler(make(chan *cmd.CommandInput, 1))
+
+	body := `{"type":"url_verification","token":"x","challenge":"abc123"}`
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, signedEventRequest(t, body, testSigningSecret))
+	if rec.Code != http.StatusOK || rec.Body.String() != "abc123" {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, signedEventRequest(t, body, "wrong-secret"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for bad signature, got %d", rec.Code)
+	}
+}
+
+func TestSlackHTTPTransportDedupesRetries(t *testing.T) {
+	tr := newTestSlackHTTPTransport()
+	q := make(chan *cmd.CommandInput, 2)
+	h := tr.Handler(q)
+
+	body := `{"type":"event_callback","token":"x","team_id":"T1","event_id":"Ev1",` +
+		`"event":{"type":"message","user":"U123","channel":"C1","text":"date","ts":"1.0"}}`
+	for i := 0; i < 2; i++ {
+		req := signedEventRequest(t, body, testSigningSecret)
+		if i > 0 {
+			req.Header.Set("X-Slack-Retry-Num", "1")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, rec.Code)
+		}
+		if !rec.Flushed {
+			t.Fatalf("request %d: the response must be flushed before handling the event", i)
+		}
+	}
+	if len(q) != 1 {
+		t.Fatalf("expected 1 queued command, got %d", len(q))
+	}
+	if got := (<-q).Text; got != "date" {
+		t.Fatalf("unexpected command text %q", got)
+	}
+}
+
+func TestSlackHTTPTransportKeepsMessageStateAcrossRebuild(t *testing.T) {
+	state := NewMessageState()
+	q := make(chan *cmd.CommandInput, 2)
+	event := func(eventID string) string {
+		return `{"type":"event_callback","token":"x","team_id":"T1","event_id":"` + eventID + `",` +
+			`"event":{"type":"message","user":"U123","channel":"C1","text":"date","ts":"1.0"}}`
+	}
+	for i, eventID := range []string{"Ev1", "Ev2"} {
+		// 再接続や再読み込みでTransportを作り直しても、同じメッセージは1回だけ実行する
+		tr := newTestSlackHTTPTransport(WithMessageState(state))
+		rec := httptest.NewRecorder()
+		tr.Handler(q).ServeHTTP(rec, signedEventRequest(t, event(eventID), testSigningSecret))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d", i, rec.Code)
+		}
+	}
+	if len(q) != 1 {
+		t.Fatalf("expected 1 queued command, got %d", len(q))
+	}
+}
@@ -1,29 +1,50 @@
 package pubsub
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/hnw/slack-commander/audit"
 	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/health"
+	"github.com/hnw/slack-commander/metrics"
+	"github.com/hnw/slack-commander/tracing"
 )
 
 var (
-	userID          string // bot自身のuser ID（注：bot IDではない）
 	reMentionTarget = regexp.MustCompile(`<@[^>]+>`)
 	reSlackURL      = regexp.MustCompile(`<([^@!|>\s][^|>]*)(?:\|([^>]*))?>`)
 )
 
+// slackClient はイベントの処理に使うSlack APIクライアントの機能。
+// *socketmode.Client と *slack.Client が満たす。
+type slackClient interface {
+	Debugf(format string, v ...interface{})
+	GetFileContext(ctx context.Context, downloadURL string, writer io.Writer) error
+	GetConversationRepliesContext(
+		ctx context.Context, params *slack.GetConversationRepliesParameters,
+	) ([]slack.Message, bool, string, error)
+}
+
 // NewSlackInput はSlackの入力を元にpubsub.Inputを返す
 func NewSlackInput(msg *slackevents.MessageEvent, text string) *cmd.CommandInput {
 	return &cmd.CommandInput{
 		ReplyInfo: msg,
 		Text:      text,
+		SenderID:  senderIDForEvent(msg.User, msg.BotID),
+		ChannelID: msg.Channel,
 	}
 }
 
@@ -32,6 +53,88 @@ func NewSlackInputFromAppMention(msg *slackevents.AppMentionEvent, text string)
 	return &cmd.CommandInput{
 		ReplyInfo: msg,
 		Text:      text,
+		SenderID:  senderIDForEvent(msg.User, msg.BotID),
+		ChannelID: msg.Channel,
+	}
+}
+
+// ListenerOption configures optional behavior of SlackListener.
+type ListenerOption func(*listenerOptions)
+
+type listenerOptions struct {
+	audit       *audit.Logger
+	health      *health.State
+	configStore *ConfigStore
+	workspace   string
+	feedback    Transport // 受け付けられなかったコマンドを知らせる先（nilなら知らせない）
+	rateLimiter RateLimiter
+	canceler    Canceler
+	dedupe      *eventDeduper // nilなら重複を判定しない
+	replies     *sentReplies  // botの返信（nilならTransportごとに覚える）
+}
+
+// Canceler cancels commands started from a message that was edited or deleted.
+type Canceler interface {
+	// Cancel は cmd.CommandInput.SourceKey が key のコマンドを取り消し、取り消した数を返す
+	Cancel(key string) int
+}
+
+// RateLimiter decides whether a command may be enqueued.
+type RateLimiter interface {
+	// Allow は input を実行してよいかを返す。よくなければ発言者に返信する理由も返す。
+	Allow(input *cmd.CommandInput) (bool, string)
+}
+
+// WithAuditLogger records accepted and denied messages to l.
+func WithAuditLogger(l *audit.Logger) ListenerOption {
+	return func(o *listenerOptions) {
+		o.audit = l
+	}
+}
+
+// WithHealth reports the Socket Mode connection state to h.
+func WithHealth(h *health.State) ListenerOption {
+	return func(o *listenerOptions) {
+		o.health = h
+	}
+}
+
+// WithConfigStore makes the listener read the config from s on every event,
+// so that allow-lists and other settings can be replaced while running.
+func WithConfigStore(s *ConfigStore) ListenerOption {
+	return func(o *listenerOptions) {
+		o.configStore = s
+	}
+}
+
+// WithWorkspace marks commands from the listener as coming from the named workspace,
+// so that replies are routed back through the same workspace by DispatchByWorkspace.
+func WithWorkspace(name string) ListenerOption {
+	return func(o *listenerOptions) {
+		o.workspace = name
+	}
+}
+
+// WithRateLimiter makes the listener check l before enqueueing a command.
+// Commands over the limit are not enqueued and the sender is told why.
+func WithRateLimiter(l RateLimiter) ListenerOption {
+	return func(o *listenerOptions) {
+		o.rateLimiter = l
+	}
+}
+
+// WithCanceler makes the listener cancel commands through c when the message
+// that started them is edited or deleted.
+func WithCanceler(c Canceler) ListenerOption {
+	return func(o *listenerOptions) {
+		o.canceler = c
+	}
+}
+
+// withFeedback はコマンドキューが一杯で受け付けられなかったことを t で知らせる
+func withFeedback(t Transport) ListenerOption {
+	return func(o *listenerOptions) {
+		o.feedback = t
 	}
 }
 
@@ -41,7 +144,16 @@ func SlackListener(
 	smc *socketmode.Client,
 	commandQueue chan *cmd.CommandInput,
 	cfg Config,
+	opts ...ListenerOption,
 ) {
+	o := &listenerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.dedupe == nil {
+		o.dedupe = newEventDeduper()
+	}
+	selfID := "" // bot自身のuser ID（注：bot IDではない）
 	for {
 		select {
 		case <-ctx.Done():
@@ -54,13 +166,23 @@ func SlackListener(
 
 			switch evt.Type {
 			case socketmode.EventTypeConnecting:
+				metrics.SetSocketModeState(metrics.StateConnecting)
 				smc.Debugf("[INFO] Connecting to Slack with Socket Mode...")
 			case socketmode.EventTypeConnectionError:
+				metrics.SetSocketModeState(metrics.StateDisconnected)
+				o.health.Disconnected()
 				smc.Debugf("[INFO] Connection failed. Retrying later...")
+			case socketmode.EventTypeDisconnect:
+				metrics.SetSocketModeState(metrics.StateDisconnected)
+				o.health.Disconnected()
+				smc.Debugf("[INFO] Disconnected from Slack.")
 			case socketmode.EventTypeConnected:
+				metrics.SetSocketModeState(metrics.StateConnected)
+				o.health.Connected()
 				smc.Debugf("[INFO] Connected to Slack with Socket Mode.")
 
 				authTest, authTestErr := smc.AuthTest()
+				o.health.AuthTested(authTestErr == nil)
 				if authTestErr != nil {
 					smc.Debugf(
 						"[WARN] AuthTest() failed. Continue without bot user ID: %v",
@@ -68,8 +190,9 @@ func SlackListener(
 					)
 					continue
 				}
-				userID = authTest.UserID
+				selfID = authTest.UserID
 			case socketmode.EventTypeEventsAPI:
+				o.health.Activity()
 				eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
 				if !ok {
 					smc.Debugf("[INFO] Ignored %+v\n", evt)
@@ -78,11 +201,15 @@ func SlackListener(
 				switch eventsAPIEvent.Type {
 				case slackevents.CallbackEvent:
 					innerEvent := eventsAPIEvent.InnerEvent
+					current := cfg
+					if o.configStore != nil {
+						current = o.configStore.Load()
+					}
 					switch ev := innerEvent.Data.(type) {
 					case *slackevents.MessageEvent:
-						onMessageEvent(smc, ev, commandQueue, cfg)
+						onMessageEvent(smc, selfID, ev, commandQueue, current, o)
 					case *slackevents.AppMentionEvent:
-						onAppMentionEvent(smc, ev, commandQueue, cfg)
+						onAppMentionEvent(smc, selfID, ev, commandQueue, current, o)
 					default:
 						smc.Debugf("[INFO] Unsupported inner event type: %v", ev)
 					}
@@ -90,6 +217,8 @@ func SlackListener(
 					smc.Debugf("[INFO] Unsupported Events API event received")
 				}
 
+			case socketmode.EventTypeHello:
+				o.health.Activity()
 			default:
 				smc.Debugf("[INFO] Unexpected event type received: %s\n", evt.Type)
 			}
@@ -132,11 +261,11 @@ func extractEnvelopeID(raw json.RawMessage) (string, bool) {
 	return req.EnvelopeID, true
 }
 
-func shouldIgnoreMessageEvent(ev *slackevents.MessageEvent, cfg Config) bool {
+func shouldIgnoreMessageEvent(ev *slackevents.MessageEvent, cfg Config, selfID string) bool {
 	if ev.User == "USLACKBOT" && !cfg.AcceptReminder {
 		return true
 	}
-	if ev.SubType == "bot_message" && (ev.User == userID || !cfg.AcceptBotMessage) {
+	if ev.SubType == "bot_message" && (ev.User == selfID || !cfg.AcceptBotMessage) {
 		// botからのメッセージを無視する & AcceptBotMessageがtrueでも自身からのメッセージは無視する
 		return true
 	}
@@ -146,11 +275,11 @@ func shouldIgnoreMessageEvent(ev *slackevents.MessageEvent, cfg Config) bool {
 	return false
 }
 
-func shouldIgnoreAppMentionEvent(ev *slackevents.AppMentionEvent, cfg Config) bool {
+func shouldIgnoreAppMentionEvent(ev *slackevents.AppMentionEvent, cfg Config, selfID string) bool {
 	if ev.User == "USLACKBOT" && !cfg.AcceptReminder {
 		return true
 	}
-	if ev.BotID != "" && (ev.User == userID || !cfg.AcceptBotMessage) {
+	if ev.BotID != "" && (ev.User == selfID || !cfg.AcceptBotMessage) {
 		// botからのメッセージを無視する & AcceptBotMessageがtrueでも自身からのメッセージは無視する
 		return true
 	}
@@ -176,7 +305,7 @@ func extractReminderText(user, text string) (string, bool) {
 	return trimmed, true
 }
 
-func extractMessageText(smc *socketmode.Client, ev *slackevents.MessageEvent) string {
+func extractMessageText(smc slackClient, ev *slackevents.MessageEvent) string {
 	if text, ok := extractReminderText(ev.User, ev.Text); ok {
 		return text
 	}
@@ -202,7 +331,7 @@ func extractAppMentionText(ev *slackevents.AppMentionEvent) string {
 	return ev.Text
 }
 
-func attachmentText(smc *socketmode.Client, attachments []slack.Attachment) string {
+func attachmentText(smc slackClient, attachments []slack.Attachment) string {
 	if len(attachments) == 0 {
 		return ""
 	}
@@ -228,63 +357,414 @@ func normalizeCommandText(text string) string {
 	return text
 }
 
+// onMessageEvent はmessageイベントをコマンドとしてcommandQueueに投入する。
+// selfID はbot自身のuser ID（不明なら空文字列）。
 func onMessageEvent(
-	smc *socketmode.Client,
+	smc slackClient,
+	selfID string,
 	ev *slackevents.MessageEvent,
 	commandQueue chan *cmd.CommandInput,
 	cfg Config,
+	o *listenerOptions,
 ) {
-	if shouldIgnoreMessageEvent(ev, cfg) {
+	edited := false
+	switch ev.SubType {
+	case "message_changed":
+		if ev = editedMessageEvent(smc, ev, cfg); ev == nil {
+			return
+		}
+		edited = true
+	case "message_deleted":
+		onMessageDeleted(smc, ev, cfg, o)
+		return
+	}
+	if shouldIgnoreMessageEvent(ev, cfg, selfID) {
 		return
 	}
+	key := dedupeKey{Channel: ev.Channel, TimeStamp: ev.TimeStamp, ClientMsgID: ev.ClientMsgID}
+	if edited {
+		// 編集は編集するたびに別のメッセージとして扱う（編集後の app_mention イベントは元のメッセージの重複になる）
+		key = dedupeKey{Channel: ev.Channel, TimeStamp: ev.TimeStamp + "/" + ev.EventTimeStamp}
+	}
+	mayHaveTwin := !edited && mentionsSelf(ev.Text, selfID)
+	o.dedupe.admit(key, PreferMessage, cfg.PreferEvent, mayHaveTwin, func() {
+		acceptMessageEvent(smc, ev, commandQueue, cfg, o, edited)
+	})
+}
+
+// acceptMessageEvent はメッセージからコマンドを取り出してcommandQueueに投入する
+func acceptMessageEvent(
+	smc slackClient,
+	ev *slackevents.MessageEvent,
+	commandQueue chan *cmd.CommandInput,
+	cfg Config,
+	o *listenerOptions,
+	edited bool,
+) {
 	senderID := senderIDForEvent(ev.User, ev.BotID)
-	if !isAllowedUser(cfg, senderID) || !isAllowedChannel(cfg, ev.Channel) {
+	ctx, span := startReceiveSpan("slack", "message", senderID, ev.Channel)
+	defer span.End()
+	if !checkAllowed(smc.Debugf, cfg, o, senderID, ev.Channel) {
 		return
 	}
+	if edited {
+		// 編集前のコマンドを取り消し、その返信を消してから実行し直す
+		o.cancelSource(smc.Debugf, ev.Channel, ev.TimeStamp, true)
+	}
 	text := normalizeCommandText(extractMessageText(smc, ev))
 	if text == "" {
 		return
 	}
-	if !enqueueCommand(commandQueue, NewSlackInput(ev, text)) {
+	input := NewSlackInput(ev, text)
+	if ev.Message != nil {
+		input.Files = slackInputFiles(smc, ev.Message.Files)
+	}
+	input.Context = ctx
+	input.SourceKey = o.sourceKey(ev.Channel, ev.TimeStamp)
+	applyListenerConfig(input, cfg, o)
+	if !checkRateLimit(smc.Debugf, o, input) {
+		return
+	}
+	// 実行の記録より先に残るよう、キューに入れる前に記録する
+	auditAccepted(o, input)
+	if !enqueueCommand(commandQueue, input) {
 		smc.Debugf("[WARN] command queue is full; dropping message event command")
+		o.queueFull(input)
 		return
 	}
 	smc.Debugf("[DEBUG]: command = '%s'", text)
 }
 
+// editedMessageEvent は message_changed イベントから編集後のメッセージを取り出す。
+// rerun_on_edit が無効な場合、テキストが変わっていない場合（URLの展開など）、
+// 投稿から edit_window より後に編集された場合はnilを返す。
+func editedMessageEvent(smc slackClient, ev *slackevents.MessageEvent, cfg Config) *slackevents.MessageEvent {
+	if !cfg.RerunOnEdit || ev.Message == nil {
+		return nil
+	}
+	msg := ev.Message
+	if ev.PreviousMessage != nil && ev.PreviousMessage.Text == msg.Text {
+		return nil
+	}
+	postedAt, ok1 := parseSlackTimeStamp(msg.Timestamp)
+	editedAt, ok2 := parseSlackTimeStamp(ev.TimeStamp)
+	if !ok1 || !ok2 || editedAt.Sub(postedAt) > cfg.editWindow() {
+		smc.Debugf("[INFO] Ignored edit of message %s outside the edit window", msg.Timestamp)
+		return nil
+	}
+	return &slackevents.MessageEvent{
+		ClientMsgID:     msg.ClientMsgID,
+		Type:            ev.Type,
+		User:            msg.User,
+		Text:            msg.Text,
+		ThreadTimeStamp: msg.ThreadTimestamp,
+		TimeStamp:       msg.Timestamp,
+		Channel:         ev.Channel,
+		ChannelType:     ev.ChannelType,
+		EventTimeStamp:  ev.EventTimeStamp,
+		Message:         msg,
+		SubType:         msg.SubType,
+		BotID:           msg.BotID,
+		Username:        msg.Username,
+	}
+}
+
+// onMessageDeleted は削除されたメッセージから起動したコマンドを取り消す
+func onMessageDeleted(smc slackClient, ev *slackevents.MessageEvent, cfg Config, o *listenerOptions) {
+	ts := ev.DeletedTimeStamp
+	if ts == "" && ev.PreviousMessage != nil {
+		ts = ev.PreviousMessage.Timestamp
+	}
+	if ts == "" {
+		return
+	}
+	o.cancelSource(smc.Debugf, ev.Channel, ts, cfg.DeleteRepliesOnDelete)
+}
+
+// parseSlackTimeStamp は "1700000000.000100" 形式のタイムスタンプを時刻に変換する
+func parseSlackTimeStamp(ts string) (time.Time, bool) {
+	sec, frac, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var usec int64
+	if frac != "" {
+		if usec, err = strconv.ParseInt(frac, 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(s, usec*1000), true
+}
+
+// sourceKey は起動元メッセージを識別する cmd.CommandInput.SourceKey を返す
+func (o *listenerOptions) sourceKey(channel, ts string) string {
+	return o.workspace + "/" + channel + "/" + ts
+}
+
+// cancelSource は起動元メッセージ channel, ts から起動したコマンドを取り消す。
+// deleteReplies がtrueならbotの返信も削除する。
+func (o *listenerOptions) cancelSource(
+	debugf func(format string, v ...interface{}),
+	channel, ts string,
+	deleteReplies bool,
+) {
+	if o.canceler != nil {
+		if n := o.canceler.Cancel(o.sourceKey(channel, ts)); n > 0 {
+			debugf("[INFO] Cancelled %d command(s) from message %s", n, ts)
+		}
+	}
+	if !deleteReplies {
+		return
+	}
+	if d, ok := o.feedback.(replyDeleter); ok {
+		d.deleteReplies(channel, ts)
+	}
+}
+
+// slackInputFiles はメッセージに添付されたファイルをcmd.InputFileに変換する。
+// ファイル本体は実際に必要になった時点でダウンロードする。
+func slackInputFiles(smc slackClient, attached []slack.File) []*cmd.InputFile {
+	if len(attached) == 0 {
+		return nil
+	}
+	files := make([]*cmd.InputFile, 0, len(attached))
+	for _, f := range attached {
+		url := f.URLPrivateDownload
+		if url == "" {
+			url = f.URLPrivate
+		}
+		if url == "" {
+			continue
+		}
+		files = append(files, &cmd.InputFile{
+			Name:     f.Name,
+			MimeType: f.Mimetype,
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				var buf bytes.Buffer
+				if err := smc.GetFileContext(ctx, url, &buf); err != nil {
+					return nil, err
+				}
+				return io.NopCloser(&buf), nil
+			},
+		})
+	}
+	return files
+}
+
+// appMentionFiles はメンションされたメッセージの添付ファイルを返す。
+// app_mention イベントには添付ファイルが含まれないので、メッセージを取得し直して調べる。
+func appMentionFiles(ctx context.Context, smc slackClient, ev *slackevents.AppMentionEvent) ([]slack.File, error) {
+	threadTS := ev.ThreadTimeStamp
+	if threadTS == "" {
+		threadTS = ev.TimeStamp
+	}
+	// スレッド内の発言を取得しても親メッセージが先頭に付くので、tsが一致するものを探す
+	msgs, _, _, err := smc.GetConversationRepliesContext(ctx, &slack.GetConversationRepliesParameters{
+		ChannelID: ev.Channel,
+		Timestamp: threadTS,
+		Oldest:    ev.TimeStamp,
+		Latest:    ev.TimeStamp,
+		Inclusive: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range msgs {
+		if m.Timestamp == ev.TimeStamp {
+			return m.Files, nil
+		}
+	}
+	return nil, nil
+}
+
 func onAppMentionEvent(
-	smc *socketmode.Client,
+	smc slackClient,
+	selfID string,
 	ev *slackevents.AppMentionEvent,
 	commandQueue chan *cmd.CommandInput,
 	cfg Config,
+	o *listenerOptions,
 ) {
-	if shouldIgnoreAppMentionEvent(ev, cfg) {
+	if shouldIgnoreAppMentionEvent(ev, cfg, selfID) {
 		return
 	}
+	key := dedupeKey{Channel: ev.Channel, TimeStamp: ev.TimeStamp}
+	o.dedupe.admit(key, PreferAppMention, cfg.PreferEvent, true, func() {
+		acceptAppMentionEvent(smc, ev, commandQueue, cfg, o)
+	})
+}
+
+// acceptAppMentionEvent はメンションからコマンドを取り出してcommandQueueに投入する
+func acceptAppMentionEvent(
+	smc slackClient,
+	ev *slackevents.AppMentionEvent,
+	commandQueue chan *cmd.CommandInput,
+	cfg Config,
+	o *listenerOptions,
+) {
 	senderID := senderIDForEvent(ev.User, ev.BotID)
-	if !isAllowedUser(cfg, senderID) || !isAllowedChannel(cfg, ev.Channel) {
+	ctx, span := startReceiveSpan("slack", "app_mention", senderID, ev.Channel)
+	defer span.End()
+	if !checkAllowed(smc.Debugf, cfg, o, senderID, ev.Channel) {
 		return
 	}
 	text := normalizeCommandText(extractAppMentionText(ev))
 	if text == "" {
 		return
 	}
-	if !enqueueCommand(commandQueue, NewSlackInputFromAppMention(ev, text)) {
+	input := NewSlackInputFromAppMention(ev, text)
+	input.Context = ctx
+	input.SourceKey = o.sourceKey(ev.Channel, ev.TimeStamp)
+	applyListenerConfig(input, cfg, o)
+	if !checkRateLimit(smc.Debugf, o, input) {
+		return
+	}
+	// 受け付けないメンションでAPIを呼ばないよう、添付ファイルは最後に取得する
+	if attached, err := appMentionFiles(ctx, smc, ev); err != nil {
+		smc.Debugf("[WARN] failed to fetch files of the mentioned message: %s", err)
+	} else {
+		input.Files = slackInputFiles(smc, attached)
+	}
+	// 実行の記録より先に残るよう、キューに入れる前に記録する
+	auditAccepted(o, input)
+	if !enqueueCommand(commandQueue, input) {
 		smc.Debugf("[WARN] command queue is full; dropping app_mention command")
+		o.queueFull(input)
 		return
 	}
 	smc.Debugf("[DEBUG]: command = '%s'", text)
 }
 
+// startReceiveSpan はイベント受信からcommandQueueへの投入までのspanを開始する。
+// transport はspan名と属性名の接頭辞に使う（"slack" など）。
+func startReceiveSpan(transport, eventType, senderID, channelID string) (context.Context, trace.Span) {
+	return tracing.Tracer().Start(
+		context.Background(),
+		transport+".receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String(transport+".event_type", eventType),
+			attribute.String(transport+".user_id", senderID),
+			attribute.String(transport+".channel_id", channelID),
+		),
+	)
+}
+
+// checkAllowed は発言者とチャンネルが許可されているか判定し、拒否した場合はログに残す
+func checkAllowed(
+	debugf func(format string, v ...interface{}),
+	cfg Config,
+	o *listenerOptions,
+	senderID, channelID string,
+) bool {
+	return checkAllowedWith(
+		debugf, o, isAllowedUser(cfg, senderID), isAllowedChannel(cfg, channelID),
+		senderID, channelID,
+	)
+}
+
+// checkAllowedWith は判定済みの結果を元に checkAllowed と同じ処理をする
+func checkAllowedWith(
+	debugf func(format string, v ...interface{}),
+	o *listenerOptions,
+	userAllowed, channelAllowed bool,
+	senderID, channelID string,
+) bool {
+	reason := ""
+	switch {
+	case !userAllowed:
+		reason = "user is not allowed"
+	case !channelAllowed:
+		reason = "channel is not allowed"
+	default:
+		return true
+	}
+	debugf("[INFO] Denied message from user %s in channel %s: %s", senderID, channelID, reason)
+	o.audit.Log(audit.Record{
+		Event:     audit.EventDenied,
+		UserID:    senderID,
+		ChannelID: channelID,
+		Reason:    reason,
+	})
+	return false
+}
+
+// checkRateLimit は実行回数の上限を超えていないか判定し、超えていればログに残して発言者に知らせる
+func checkRateLimit(debugf func(format string, v ...interface{}), o *listenerOptions, input *cmd.CommandInput) bool {
+	if o.rateLimiter == nil {
+		return true
+	}
+	ok, reason := o.rateLimiter.Allow(input)
+	if ok {
+		return true
+	}
+	debugf("[INFO] Rate limited message from user %s in channel %s: %s", input.SenderID, input.ChannelID, reason)
+	o.audit.Log(audit.Record{
+		Event:     audit.EventDenied,
+		UserID:    input.SenderID,
+		ChannelID: input.ChannelID,
+		Text:      input.Text,
+		Reason:    "rate limit exceeded",
+	})
+	if o.feedback != nil {
+		notifyRejected(o.feedback, input, "no_entry", reason)
+	}
+	return false
+}
+
+func auditAccepted(o *listenerOptions, input *cmd.CommandInput) {
+	o.audit.Log(audit.Record{
+		Event:     audit.EventAccepted,
+		UserID:    input.SenderID,
+		ChannelID: input.ChannelID,
+		Text:      input.Text,
+	})
+}
+
+// applyListenerConfig は実行できるコマンドの制限と、返信先のワークスペースを input に反映する
+func applyListenerConfig(input *cmd.CommandInput, cfg Config, o *listenerOptions) {
+	input.AllowedCommands = cfg.AllowedCommands
+	if o.workspace != "" {
+		input.ReplyInfo = &WorkspaceReply{Workspace: o.workspace, ReplyInfo: input.ReplyInfo}
+	}
+}
+
 func enqueueCommand(commandQueue chan *cmd.CommandInput, input *cmd.CommandInput) bool {
+	input.EnqueuedAt = time.Now()
 	select {
 	case commandQueue <- input:
+		metrics.CommandReceived()
 		return true
 	default:
+		metrics.QueueDropped("command")
 		return false
 	}
 }
 
+// queueFull はコマンドキューが一杯で受け付けなかったことをログに残して発言者に知らせる
+func (o *listenerOptions) queueFull(input *cmd.CommandInput) {
+	o.audit.Log(audit.Record{
+		Event:     audit.EventDenied,
+		UserID:    input.SenderID,
+		ChannelID: input.ChannelID,
+		Text:      input.Text,
+		Reason:    "queue full",
+	})
+	if o.feedback != nil {
+		notifyRejected(o.feedback, input, "warning", queueFullMessage)
+	}
+}
+
+// mentionsSelf は text がbot自身へのメンションを含むかを返す。
+// bot自身のuser IDが不明な場合は、誰かへのメンションを含めばtrueを返す。
+func mentionsSelf(text, selfID string) bool {
+	if selfID == "" {
+		return strings.Contains(text, "<@")
+	}
+	return strings.Contains(text, "<@"+selfID+">") || strings.Contains(text, "<@"+selfID+"|")
+}
+
 // remove mention target from message text (like <@USLACKBOT>)
 func removeMentionTarget(message string) string {
 	return reMentionTarget.ReplaceAllString(message, "")
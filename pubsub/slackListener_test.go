@@ -1,10 +1,21 @@
 package pubsub
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+
+	"github.com/hnw/slack-commander/audit"
 	"github.com/hnw/slack-commander/cmd"
 )
 
@@ -147,3 +158,326 @@ func TestExtractEnvelopeID(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckAllowedRecordsDenial(t *testing.T) {
+	sink := &auditTestSink{}
+	o := &listenerOptions{audit: audit.NewLogger([]audit.Sink{sink}, nil, "", nil)}
+	smc := socketmode.New(slack.New("xoxb-test"))
+	cfg := Config{AllowedUserIDs: []string{"U1"}, AllowedChannelIDs: []string{"C1"}}
+
+	if !checkAllowed(smc.Debugf, cfg, o, "U1", "C1") {
+		t.Fatal("expected allowed")
+	}
+	if checkAllowed(smc.Debugf, cfg, o, "U2", "C1") {
+		t.Fatal("expected user to be denied")
+	}
+	if checkAllowed(smc.Debugf, cfg, o, "U1", "C2") {
+		t.Fatal("expected channel to be denied")
+	}
+
+	lines := strings.Split(strings.TrimSpace(sink.buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", sink.buf.String())
+	}
+	var rec audit.Record
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Event != audit.EventDenied || rec.UserID != "U2" || rec.Reason != "user is not allowed" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.ChannelID != "C2" || rec.Reason != "channel is not allowed" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
+
+func TestOnMessageEventAuditsBeforeEnqueue(t *testing.T) {
+	sink := &auditTestSink{}
+	o := &listenerOptions{audit: audit.NewLogger([]audit.Sink{sink}, nil, "", nil)}
+	smc := socketmode.New(slack.New("xoxb-test"))
+	cfg := Config{AllowedUserIDs: []string{"U1"}}
+	q := make(chan *cmd.CommandInput) // 受け取る側がいないので常に一杯
+
+	onMessageEvent(smc, "UBOT", decodeMessageEvent(t,
+		`{"type":"message","channel":"C1","user":"U1","text":"date","ts":"1.0"}`), q, cfg, o)
+
+	lines := strings.Split(strings.TrimSpace(sink.buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %q", sink.buf.String())
+	}
+	var rec audit.Record
+	if err := json.Unmarshal([]byte(lines[0]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Event != audit.EventAccepted || rec.Text != "date" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Event != audit.EventDenied || rec.Reason != "queue full" {
+		t.Fatalf("unexpected record: %+v", rec)
+	}
+}
+
+type auditTestSink struct {
+	buf bytes.Buffer
+}
+
+func (s *auditTestSink) Write(record []byte) error {
+	s.buf.Write(record)
+	s.buf.WriteByte('\n')
+	return nil
+}
+
+func (s *auditTestSink) Close() error { return nil }
+
+type recordingCanceler struct{ keys []string }
+
+func (c *recordingCanceler) Cancel(key string) int {
+	c.keys = append(c.keys, key)
+	return 1
+}
+
+func decodeMessageEvent(t *testing.T, raw string) *slackevents.MessageEvent {
+	t.Helper()
+	var ev slackevents.MessageEvent
+	if err := json.Unmarshal([]byte(raw), &ev); err != nil {
+		t.Fatal(err)
+	}
+	return &ev
+}
+
+func messageChangedEvent(t *testing.T, editedTS, previous, text string) *slackevents.MessageEvent {
+	return decodeMessageEvent(t, `{"type":"message","subtype":"message_changed","channel":"C1",`+
+		`"ts":"`+editedTS+`","message":{"type":"message","user":"U1","text":"`+text+`",`+
+		`"ts":"1700000000.000100"},"previous_message":{"type":"message","user":"U1",`+
+		`"text":"`+previous+`","ts":"1700000000.000100"}}`)
+}
+
+func TestOnMessageEventRerunsOnEdit(t *testing.T) {
+	smc := socketmode.New(slack.New("xoxb-test"))
+	canceler := &recordingCanceler{}
+	o := &listenerOptions{canceler: canceler}
+	cfg := Config{AllowedUserIDs: []string{"U1"}, RerunOnEdit: true, EditWindow: 60}
+	q := make(chan *cmd.CommandInput, 1)
+
+	onMessageEvent(smc, "", messageChangedEvent(t, "1700000030.000000", "date", "uptime"), q, cfg, o)
+	if len(q) != 1 {
+		t.Fatal("expected the edited command to be enqueued")
+	}
+	input := <-q
+	if input.Text != "uptime" || input.SourceKey != "/C1/1700000000.000100" {
+		t.Fatalf("unexpected input: %+v", input)
+	}
+	if ts := input.ReplyInfo.(*slackevents.MessageEvent).TimeStamp; ts != "1700000000.000100" {
+		t.Fatalf("replies must go to the original message, got ts %s", ts)
+	}
+	if len(canceler.keys) != 1 || canceler.keys[0] != input.SourceKey {
+		t.Fatalf("expected the previous run to be cancelled: %v", canceler.keys)
+	}
+
+	// URLの展開などでテキストが変わらない編集、edit_window より後の編集、rerun_on_edit が無効な場合は無視する
+	canceler.keys = nil
+	onMessageEvent(smc, "", messageChangedEvent(t, "1700000030.000000", "date", "date"), q, cfg, o)
+	onMessageEvent(smc, "", messageChangedEvent(t, "1700000090.000000", "date", "uptime"), q, cfg, o)
+	onMessageEvent(smc, "", messageChangedEvent(t, "1700000030.000000", "date", "uptime"), q, Config{
+		AllowedUserIDs: []string{"U1"},
+	}, o)
+	if len(q) != 0 || len(canceler.keys) != 0 {
+		t.Fatalf("expected edits to be ignored: %d queued, cancelled %v", len(q), canceler.keys)
+	}
+}
+
+func TestOnMessageEventCancelsOnDelete(t *testing.T) {
+	smc := socketmode.New(slack.New("xoxb-test"))
+	canceler := &recordingCanceler{}
+	o := &listenerOptions{canceler: canceler, workspace: "main"}
+	q := make(chan *cmd.CommandInput, 1)
+	ev := decodeMessageEvent(t, `{"type":"message","subtype":"message_deleted","channel":"C1",`+
+		`"ts":"1700000050.000000","deleted_ts":"1700000000.000100",`+
+		`"previous_message":{"type":"message","user":"U1","text":"date","ts":"1700000000.000100"}}`)
+
+	onMessageEvent(smc, "", ev, q, Config{AllowedUserIDs: []string{"U1"}}, o)
+	if len(q) != 0 {
+		t.Fatal("a deleted message must not run a command")
+	}
+	if len(canceler.keys) != 1 || canceler.keys[0] != "main/C1/1700000000.000100" {
+		t.Fatalf("unexpected cancelled keys: %v", canceler.keys)
+	}
+}
+
+func TestSentReplies(t *testing.T) {
+	r := newSentReplies()
+	source := func(ts string) *cmd.CommandOutput {
+		return &cmd.CommandOutput{ReplyInfo: &slackevents.MessageEvent{Channel: "C1", TimeStamp: ts}}
+	}
+	r.record(source("1.0"), "2.0")
+	r.record(source("1.0"), "3.0")
+	r.record(&cmd.CommandOutput{ReplyInfo: &ChannelReply{Channel: "C1"}}, "4.0") // 元メッセージがない
+	if got := strings.Join(r.take("C1", "1.0"), ","); got != "2.0,3.0" {
+		t.Fatalf("unexpected replies: %s", got)
+	}
+	if got := r.take("C1", "1.0"); got != nil {
+		t.Fatalf("replies must be forgotten after take: %v", got)
+	}
+
+	for i := range maxTrackedSources + 1 {
+		r.record(source(strconv.Itoa(i)), "9.0")
+	}
+	if r.take("C1", "0") != nil || r.take("C1", strconv.Itoa(maxTrackedSources)) == nil {
+		t.Fatal("expected the oldest source to be forgotten")
+	}
+}
+
+func TestSentRepliesSharedAcrossTransports(t *testing.T) {
+	state := NewMessageState()
+	api := slack.New("xoxb-test")
+	before := newSlackPoster(api, []ListenerOption{WithMessageState(state)})
+	before.replies.record(&cmd.CommandOutput{
+		ReplyInfo: &slackevents.MessageEvent{Channel: "C1", TimeStamp: "1.0"},
+	}, "2.0")
+	// 作り直したTransportでも、前のTransportが投稿した返信を削除できる
+	after := newSlackPoster(api, []ListenerOption{WithMessageState(state)})
+	if got := after.replies.take("C1", "1.0"); len(got) != 1 || got[0] != "2.0" {
+		t.Fatalf("unexpected replies: %v", got)
+	}
+}
+
+func TestOnMessageEventDedupesMentionAndRedelivery(t *testing.T) {
+	smc := socketmode.New(slack.New("xoxb-test"))
+	o := &listenerOptions{dedupe: newEventDeduper()}
+	cfg := Config{AllowedUserIDs: []string{"U1"}}
+	q := make(chan *cmd.CommandInput, 3)
+	message := decodeMessageEvent(t, `{"type":"message","channel":"C1","user":"U1",`+
+		`"text":"<@UBOT> date","ts":"1.0","client_msg_id":"m1"}`)
+	mention := &slackevents.AppMentionEvent{Channel: "C1", User: "U1", Text: "<@UBOT> date", TimeStamp: "1.0"}
+
+	onMessageEvent(smc, "UBOT", message, q, cfg, o)
+	onAppMentionEvent(smc, "UBOT", mention, q, cfg, o)
+	onMessageEvent(smc, "UBOT", message, q, cfg, o) // 再接続後の再送
+	// クライアントが投稿をやり直したメッセージは ts が違っても client_msg_id で重複とわかる
+	onMessageEvent(smc, "UBOT", decodeMessageEvent(t, `{"type":"message","channel":"C1","user":"U1",`+
+		`"text":"<@UBOT> date","ts":"2.0","client_msg_id":"m1"}`), q, cfg, o)
+	if len(q) != 1 {
+		t.Fatalf("expected the command to be enqueued once, got %d", len(q))
+	}
+	if _, ok := (<-q).ReplyInfo.(*slackevents.MessageEvent); !ok {
+		t.Fatal("expected the first event to win")
+	}
+}
+
+func TestEventDeduperPrefersEvent(t *testing.T) {
+	d := newEventDeduper()
+	d.grace = 20 * time.Millisecond
+	var mu sync.Mutex
+	var ran []string
+	run := func(kind string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			ran = append(ran, kind)
+		}
+	}
+	ranKinds := func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return strings.Join(ran, ",")
+	}
+
+	// 優先しない message が先に届いても、後から届いた app_mention を実行する
+	key := dedupeKey{Channel: "C1", TimeStamp: "1.0"}
+	d.admit(key, PreferMessage, PreferAppMention, true, run("message"))
+	d.admit(key, PreferAppMention, PreferAppMention, true, run("app_mention"))
+	time.Sleep(5 * d.grace)
+	if got := ranKinds(); got != "app_mention" {
+		t.Fatalf("unexpected runs: %s", got)
+	}
+
+	// 優先する方が届かなければ、待ってから実行する
+	ran = nil
+	d.admit(dedupeKey{Channel: "C1", TimeStamp: "2.0"}, PreferMessage, PreferAppMention, true, run("message"))
+	if got := ranKinds(); got != "" {
+		t.Fatalf("expected the message to be held: %s", got)
+	}
+	time.Sleep(5 * d.grace)
+	if got := ranKinds(); got != "message" {
+		t.Fatalf("expected the held message to run: %s", got)
+	}
+
+	// もう一方のイベントが届かないメッセージは待たない
+	ran = nil
+	d.admit(dedupeKey{Channel: "C1", TimeStamp: "3.0"}, PreferMessage, PreferAppMention, false, run("message"))
+	if got := ranKinds(); got != "message" {
+		t.Fatalf("expected the message to run at once: %s", got)
+	}
+}
+
+func TestEventDeduperForgetsExpired(t *testing.T) {
+	d := newEventDeduper()
+	now := time.Unix(0, 0)
+	d.now = func() time.Time { return now }
+	runs := 0
+	key := dedupeKey{Channel: "C1", TimeStamp: "1.0"}
+	d.admit(key, PreferMessage, "", true, func() { runs++ })
+	d.admit(key, PreferMessage, "", true, func() { runs++ })
+	now = now.Add(d.ttl + time.Second)
+	d.admit(key, PreferMessage, "", true, func() { runs++ })
+	if runs != 2 {
+		t.Fatalf("unexpected runs: %d", runs)
+	}
+}
+
+// fakeSlackClient はメッセージの取得とファイルのダウンロードだけを行うテスト用のslackClient
+type fakeSlackClient struct {
+	replies []slack.Message
+	params  *slack.GetConversationRepliesParameters
+}
+
+func (c *fakeSlackClient) Debugf(format string, v ...interface{}) {}
+
+func (c *fakeSlackClient) GetFileContext(ctx context.Context, downloadURL string, writer io.Writer) error {
+	_, err := io.WriteString(writer, "content of "+downloadURL)
+	return err
+}
+
+func (c *fakeSlackClient) GetConversationRepliesContext(
+	ctx context.Context, params *slack.GetConversationRepliesParameters,
+) ([]slack.Message, bool, string, error) {
+	c.params = params
+	return c.replies, false, "", nil
+}
+
+func TestAppMentionEventFetchesFiles(t *testing.T) {
+	smc := &fakeSlackClient{replies: []slack.Message{
+		// スレッド内のメンションでも親メッセージが先頭に返る
+		{Msg: slack.Msg{Timestamp: "1.0", Files: []slack.File{{Name: "parent.txt", URLPrivate: "https://files/parent"}}}},
+		{Msg: slack.Msg{Timestamp: "2.0", Files: []slack.File{{Name: "a.csv", Mimetype: "text/csv", URLPrivate: "https://files/a"}}}},
+	}}
+	cfg := Config{AllowedUserIDs: []string{"U1"}}
+	q := make(chan *cmd.CommandInput, 1)
+	mention := &slackevents.AppMentionEvent{
+		Channel: "C1", User: "U1", Text: "<@UBOT> upload", TimeStamp: "2.0", ThreadTimeStamp: "1.0",
+	}
+
+	acceptAppMentionEvent(smc, mention, q, cfg, &listenerOptions{})
+
+	if smc.params.Timestamp != "1.0" || smc.params.Oldest != "2.0" || smc.params.Latest != "2.0" {
+		t.Fatalf("unexpected parameters: %+v", smc.params)
+	}
+	input := <-q
+	if len(input.Files) != 1 || input.Files[0].Name != "a.csv" || input.Files[0].MimeType != "text/csv" {
+		t.Fatalf("unexpected files: %+v", input.Files)
+	}
+	r, err := input.Files[0].Open(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = r.Close() }()
+	if b, _ := io.ReadAll(r); string(b) != "content of https://files/a" {
+		t.Fatalf("unexpected content: %q", b)
+	}
+}
@@ -0,0 +1,85 @@
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// maxTrackedSources は返信を覚えておく起動元メッセージの数。超えたら古いものから忘れる。
+const maxTrackedSources = 1000
+
+// sentReplies は起動元メッセージごとにbotが投稿した返信のタイムスタンプを覚えておく。
+// 起動元メッセージが編集・削除された時に返信を削除するのに使う。
+type sentReplies struct {
+	mu       sync.Mutex
+	bySource map[string][]string // "チャンネル/起動元メッセージのts" ごとの返信のts
+	order    []string            // bySource のキーを覚えた順に並べたもの
+}
+
+func newSentReplies() *sentReplies {
+	return &sentReplies{bySource: map[string][]string{}}
+}
+
+func replySourceKey(channel, ts string) string {
+	return channel + "/" + ts
+}
+
+// record は output の起動元メッセージへの返信として ts を覚える
+func (r *sentReplies) record(output *cmd.CommandOutput, ts string) {
+	if r == nil || ts == "" || !hasSourceMessage(output) {
+		return
+	}
+	channel, sourceTS := getChannel(output), getTimeStamp(output)
+	if channel == "" || sourceTS == "" {
+		return
+	}
+	key := replySourceKey(channel, sourceTS)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.bySource[key]; !ok {
+		r.order = append(r.order, key)
+		if len(r.order) > maxTrackedSources {
+			delete(r.bySource, r.order[0])
+			r.order = r.order[1:]
+		}
+	}
+	r.bySource[key] = append(r.bySource[key], ts)
+}
+
+// take は起動元メッセージへの返信のtsを返し、忘れる
+func (r *sentReplies) take(channel, sourceTS string) []string {
+	if r == nil {
+		return nil
+	}
+	key := replySourceKey(channel, sourceTS)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	replies, ok := r.bySource[key]
+	if !ok {
+		return nil
+	}
+	delete(r.bySource, key)
+	for i, k := range r.order {
+		if k == key {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+	return replies
+}
+
+// replyDeleter は起動元メッセージへのbotの返信を削除できるTransport
+type replyDeleter interface {
+	deleteReplies(channel, sourceTS string)
+}
+
+// deleteReplies は起動元メッセージへのbotの返信のうち、覚えているものを削除する
+func (t *slackPoster) deleteReplies(channel, sourceTS string) {
+	for _, ts := range t.replies.take(channel, sourceTS) {
+		_, _, err := t.api.DeleteMessage(channel, ts)
+		if countSlackError("deleteMessage", err) != nil {
+			t.Debugf("[WARN] Failed to delete reply %s: %v", ts, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/socketmode"
+
+	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/metrics"
+)
+
+// slackPoster はSlackへの投稿を担当する。Socket ModeとEvents APIのTransportで共通。
+type slackPoster struct {
+	api     *slack.Client
+	replies *sentReplies
+}
+
+// newSlackPoster は WithMessageState で渡された返信の記録先を使うPosterを返す
+func newSlackPoster(api *slack.Client, opts []ListenerOption) slackPoster {
+	o := &listenerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.replies == nil {
+		o.replies = newSentReplies()
+	}
+	return slackPoster{api: api, replies: o.replies}
+}
+
+// SlackTransport はSocket Modeで接続するSlackのTransport
+type SlackTransport struct {
+	slackPoster
+	smc  *socketmode.Client
+	cfg  Config
+	opts []ListenerOption
+}
+
+// NewSlackTransport returns a Transport that listens with SlackListener on smc.
+// cfg and opts are passed to SlackListener.
+func NewSlackTransport(smc *socketmode.Client, cfg Config, opts ...ListenerOption) *SlackTransport {
+	return &SlackTransport{slackPoster: newSlackPoster(&smc.Client, opts), smc: smc, cfg: cfg, opts: opts}
+}
+
+// Name returns "slack".
+func (t *slackPoster) Name() string { return "slack" }
+
+// Listen はSocket Modeで接続し、ctxが終了するまでSlackListenerを動かす
+func (t *SlackTransport) Listen(ctx context.Context, commandQueue chan *cmd.CommandInput) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		opts := append(append([]ListenerOption{}, t.opts...), withFeedback(t))
+		SlackListener(ctx, t.smc, commandQueue, t.cfg, opts...)
+	}()
+	err := t.smc.RunContext(ctx)
+	cancel()
+	<-done
+	return err
+}
+
+// PostMessage posts the text output.
+func (t *slackPoster) PostMessage(output *cmd.CommandOutput) error {
+	ts, err := postMessage(t.api, output)
+	t.replies.record(output, ts)
+	return countSlackError("postMessage", err)
+}
+
+// PostBlocks posts the Block Kit output as is.
+func (t *slackPoster) PostBlocks(output *cmd.CommandOutput) error {
+	ts, err := postBlocks(t.api, output)
+	t.replies.record(output, ts)
+	return countSlackError("postBlocks", err)
+}
+
+// UploadImage uploads the image output and posts it with an image block.
+func (t *slackPoster) UploadImage(output *cmd.CommandOutput) error {
+	ts, err := uploadImage(t.api, output)
+	t.replies.record(output, ts)
+	return countSlackError("uploadImage", err)
+}
+
+// AddReaction adds a reaction to the source message.
+func (t *slackPoster) AddReaction(output *cmd.CommandOutput, name string) error {
+	return countSlackError("addReaction", addReaction(t.api, output, name))
+}
+
+// RemoveReaction removes a reaction from the source message.
+func (t *slackPoster) RemoveReaction(output *cmd.CommandOutput, name string) error {
+	return countSlackError("removeReaction", removeReaction(t.api, output, name))
+}
+
+// Debugf writes a debug log through the Socket Mode client.
+func (t *slackPoster) Debugf(format string, v ...interface{}) {
+	t.api.Debugf(format, v...)
+}
+
+func countSlackError(operation string, err error) error {
+	if err != nil {
+		metrics.SlackAPIError(operation)
+	}
+	return err
+}
@@ -3,6 +3,7 @@ package pubsub
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -16,74 +17,34 @@ import (
 
 // SlackWriter はoutputQueueから来たコマンド実行結果をSlackに書き込みます
 func SlackWriter(ctx context.Context, smc *socketmode.Client, outputQueue chan *cmd.CommandOutput) {
-	runningProcess := 0
-	for {
-		select {
-		case output, ok := <-outputQueue: // closeされると ok が false になる
-			if !ok {
-				return
-			}
-			runningProcess = handleOutput(smc, output, runningProcess)
-		case <-ctx.Done():
-			for output := range outputQueue {
-				runningProcess = handleOutput(smc, output, runningProcess)
-			}
-			return
-		}
-	}
+	t := NewSlackTransport(smc, Config{})
+	Writer(ctx, func() Transport { return t }, outputQueue)
 }
 
-func handleOutput(smc *socketmode.Client, output *cmd.CommandOutput, runningProcess int) int {
-	if output.Spawned {
-		runningProcess++
-		if err := addReaction(smc, output, "eyes"); err != nil {
-			smc.Debugf("[ERROR] addReaction: %s\n", err)
-		}
-	} else if output.Finished {
-		runningProcess--
-		if output.ExitCode == 0 {
-			if err := addReaction(smc, output, "white_check_mark"); err != nil {
-				smc.Debugf("[ERROR] addReaction: %s\n", err)
-			}
-		} else {
-			if err := addReaction(smc, output, "x"); err != nil {
-				smc.Debugf("[ERROR] addReaction: %s\n", err)
-			}
-		}
-		if err := removeReaction(smc, output, "eyes"); err != nil {
-			smc.Debugf("[ERROR] removeReaction: %s\n", err)
-		}
-	}
-	if hasMeaningfulText(output) {
-		if err := postMessage(smc, output); err != nil {
-			smc.Debugf("[ERROR] postMessage: %s\n", err)
-		}
-	}
-	if output.ImageData != nil {
-		if err := uploadImage(smc, output); err != nil {
-			smc.Debugf("[ERROR] uploadImage: %s\n", err)
-		}
-	}
-	return runningProcess
-}
-
-func addReaction(smc *socketmode.Client, output *cmd.CommandOutput, name string) error {
+func addReaction(api *slack.Client, output *cmd.CommandOutput, name string) error {
 	ch := getChannel(output)
 	ts := getTimeStamp(output)
+	if !hasSourceMessage(output) {
+		// リアクションを付ける元メッセージがない
+		return nil
+	}
 	item := slack.NewRefToMessage(ch, ts)
-	return smc.AddReaction(name, item)
+	return api.AddReaction(name, item)
 }
 
-func removeReaction(smc *socketmode.Client, output *cmd.CommandOutput, name string) error {
+func removeReaction(api *slack.Client, output *cmd.CommandOutput, name string) error {
 	ch := getChannel(output)
 	ts := getTimeStamp(output)
+	if !hasSourceMessage(output) {
+		return nil
+	}
 	item := slack.NewRefToMessage(ch, ts)
-	return smc.RemoveReaction(name, item)
+	return api.RemoveReaction(name, item)
 }
 
-func postMessage(smc *socketmode.Client, output *cmd.CommandOutput) error {
+func postMessage(api *slack.Client, output *cmd.CommandOutput) (string, error) {
 	if !hasMeaningfulText(output) {
-		return nil
+		return "", nil
 	}
 	cfg := getConfig(output)
 	params := slack.PostMessageParameters{
@@ -94,24 +55,26 @@ func postMessage(smc *socketmode.Client, output *cmd.CommandOutput) error {
 		ReplyBroadcast:  getReplyBroadcast(output),
 	}
 	attachment := slack.Attachment{
-		Text:  getText(output),
-		Color: getColor(output),
+		Text:   getText(output),
+		Color:  getColor(output),
+		Footer: getFooter(output),
 	}
 	msgOptParams := slack.MsgOptionPostMessageParameters(params)
 	msgOptAttachment := slack.MsgOptionAttachments(attachment)
 	ch := getChannel(output)
-	if _, _, err := smc.PostMessage(ch, msgOptParams, msgOptAttachment); err != nil {
-		smc.Debugf("[ERROR] %s\n", err)
-		return err
+	_, ts, err := api.PostMessage(ch, msgOptParams, msgOptAttachment)
+	if err != nil {
+		api.Debugf("[ERROR] %s\n", err)
+		return "", err
 	}
-	return nil
+	return ts, nil
 }
 
 func postMessageWithImageBlock(
-	smc *socketmode.Client,
+	api *slack.Client,
 	output *cmd.CommandOutput,
 	fileID string,
-) error {
+) (string, error) {
 	cfg := getConfig(output)
 	params := slack.PostMessageParameters{
 		Username:        cfg.Username,
@@ -139,13 +102,34 @@ func postMessageWithImageBlock(
 	}
 
 	ch := getChannel(output)
-	if _, _, err := smc.PostMessage(ch, msgOpts...); err != nil {
-		return err
+	_, ts, err := api.PostMessage(ch, msgOpts...)
+	return ts, err
+}
+
+// postBlocks はコマンドが出力したBlock KitのJSONをそのままポストする
+func postBlocks(api *slack.Client, output *cmd.CommandOutput) (string, error) {
+	var blocks slack.Blocks
+	if err := json.Unmarshal(output.Blocks, &blocks); err != nil {
+		return "", err
+	}
+	cfg := getConfig(output)
+	params := slack.PostMessageParameters{
+		Username:        cfg.Username,
+		IconEmoji:       cfg.IconEmoji,
+		IconURL:         cfg.IconURL,
+		ThreadTimestamp: getThreadTimestamp(output),
+		ReplyBroadcast:  getReplyBroadcast(output),
 	}
-	return nil
+	ch := getChannel(output)
+	_, ts, err := api.PostMessage(
+		ch,
+		slack.MsgOptionPostMessageParameters(params),
+		slack.MsgOptionBlocks(blocks.BlockSet...),
+	)
+	return ts, err
 }
 
-func uploadImage(smc *socketmode.Client, output *cmd.CommandOutput) error {
+func uploadImage(api *slack.Client, output *cmd.CommandOutput) (string, error) {
 	cfg := getConfig(output)
 	params := slack.UploadFileParameters{
 		Reader:   bytes.NewReader(output.ImageData),
@@ -153,12 +137,12 @@ func uploadImage(smc *socketmode.Client, output *cmd.CommandOutput) error {
 		Filename: "output.png",
 		Title:    cfg.Username + " output",
 	}
-	fileSummary, err := smc.UploadFile(params)
+	fileSummary, err := api.UploadFile(params)
 	if err != nil {
-		return err
+		return "", err
 	}
 	if fileSummary == nil || fileSummary.ID == "" {
-		return fmt.Errorf("uploadImage: missing file ID")
+		return "", fmt.Errorf("uploadImage: missing file ID")
 	}
 	var lastErr error
 	delay := 200 * time.Millisecond
@@ -167,17 +151,18 @@ func uploadImage(smc *socketmode.Client, output *cmd.CommandOutput) error {
 			time.Sleep(delay)
 			delay *= 2
 		}
-		if err := postMessageWithImageBlock(smc, output, fileSummary.ID); err != nil {
+		ts, err := postMessageWithImageBlock(api, output, fileSummary.ID)
+		if err != nil {
 			lastErr = err
 			if isInvalidBlocks(err) {
-				smc.Debugf("[WARN] uploadImage: invalid_blocks (attempt %d/5)\n", attempt)
+				api.Debugf("[WARN] uploadImage: invalid_blocks (attempt %d/5)\n", attempt)
 				continue
 			}
-			return err
+			return "", err
 		}
-		return nil
+		return ts, nil
 	}
-	return lastErr
+	return "", lastErr
 }
 
 func isInvalidBlocks(err error) bool {
@@ -203,6 +188,9 @@ func getConfig(output *cmd.CommandOutput) *ReplyConfig {
 }
 
 func getThreadTimestamp(output *cmd.CommandOutput) string {
+	if r, ok := channelReplyOf(output); ok {
+		return r.ThreadTimeStamp
+	}
 	cfg := getConfig(output)
 	if cfg.PostAsReply {
 		return getTimeStamp(output)
@@ -233,6 +221,13 @@ func getReplyBroadcast(output *cmd.CommandOutput) bool {
 	return false
 }
 
+func getFooter(output *cmd.CommandOutput) string {
+	if output.JobID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("job #%d", output.JobID)
+}
+
 func getColor(output *cmd.CommandOutput) string {
 	if output.IsErrOut {
 		return "danger"
@@ -240,24 +235,36 @@ func getColor(output *cmd.CommandOutput) string {
 	return "good"
 }
 
+// hasSourceMessage はコマンドの起動元になったメッセージがあるかを返す
+func hasSourceMessage(output *cmd.CommandOutput) bool {
+	_, ok := channelReplyOf(output)
+	return !ok
+}
+
+// getChannel は返信先のチャンネルを返す。不明な ReplyInfo なら空文字列を返す。
 func getChannel(output *cmd.CommandOutput) string {
+	if r, ok := channelReplyOf(output); ok {
+		return r.Channel
+	}
 	switch origMsg := output.ReplyInfo.(type) {
 	case *slackevents.MessageEvent:
 		return origMsg.Channel
 	case *slackevents.AppMentionEvent:
 		return origMsg.Channel
-	default:
-		panic("cast failed")
 	}
+	return ""
 }
 
+// getTimeStamp は起動元メッセージのタイムスタンプを返す。不明な ReplyInfo なら空文字列を返す。
 func getTimeStamp(output *cmd.CommandOutput) string {
+	if r, ok := channelReplyOf(output); ok {
+		return r.ThreadTimeStamp
+	}
 	switch origMsg := output.ReplyInfo.(type) {
 	case *slackevents.MessageEvent:
 		return origMsg.TimeStamp
 	case *slackevents.AppMentionEvent:
 		return origMsg.TimeStamp
-	default:
-		panic("cast failed")
 	}
+	return ""
 }
@@ -0,0 +1,37 @@
+package pubsub
+
+import "strings"
+
+// socketModePingMessage はslack-go/socketmodeがWebSocketのPINGを受信した時に出力するデバッグログ
+const socketModePingMessage = "WebSocket ping message received"
+
+// Logger is the logger interface accepted by slack-go (slack.OptionLog).
+type Logger interface {
+	Output(calldepth int, s string) error
+}
+
+// PingObservingLogger はslack-go/socketmodeのデバッグログからPINGの受信を検出する。
+// socketmodeはPINGの受信をイベントとして通知しないため、socketmodeのデバッグ出力を
+// 常に有効にしたうえで、このロガーでPINGを検出し、元のロガーへの出力は debug の場合だけ行う。
+type PingObservingLogger struct {
+	next   Logger
+	debug  bool
+	onPing func()
+}
+
+// NewPingObservingLogger returns a logger calling onPing whenever socketmode
+// reports a received WebSocket PING. Messages are forwarded to next only if debug is true.
+func NewPingObservingLogger(next Logger, debug bool, onPing func()) *PingObservingLogger {
+	return &PingObservingLogger{next: next, debug: debug, onPing: onPing}
+}
+
+// Output implements Logger.
+func (l *PingObservingLogger) Output(calldepth int, s string) error {
+	if l.onPing != nil && strings.Contains(s, socketModePingMessage) {
+		l.onPing()
+	}
+	if !l.debug {
+		return nil
+	}
+	return l.next.Output(calldepth+1, s)
+}
@@ -0,0 +1,33 @@
+package pubsub
+
+import "testing"
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Output(_ int, s string) error {
+	l.lines = append(l.lines, s)
+	return nil
+}
+
+func TestPingObservingLogger(t *testing.T) {
+	next := &recordingLogger{}
+	pings := 0
+	l := NewPingObservingLogger(next, false, func() { pings++ })
+
+	_ = l.Output(2, "WebSocket ping message received: 1234")
+	_ = l.Output(2, "Starting SocketMode")
+	if pings != 1 {
+		t.Fatalf("expected 1 ping, got %d", pings)
+	}
+	if len(next.lines) != 0 {
+		t.Fatalf("expected no output without debug, got %v", next.lines)
+	}
+
+	l = NewPingObservingLogger(next, true, nil)
+	_ = l.Output(2, "Starting SocketMode")
+	if len(next.lines) != 1 {
+		t.Fatalf("expected output in debug mode, got %v", next.lines)
+	}
+}
@@ -0,0 +1,173 @@
+package pubsub
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/tracing"
+)
+
+// Transport はチャットサービス（Slack, Mattermostなど）との間で
+// コマンドの受信と実行結果の投稿をやり取りする
+type Transport interface {
+	// Name はトレースのspan名などに使うTransportの名前を返す
+	Name() string
+	// Listen はctxが終了するまでメッセージを監視し、コマンドをcommandQueueに投入する
+	Listen(ctx context.Context, commandQueue chan *cmd.CommandInput) error
+	// PostMessage はコマンドのテキスト出力を投稿する
+	PostMessage(output *cmd.CommandOutput) error
+	// PostBlocks はコマンドが出力したBlock KitのJSONを投稿する。
+	// Block Kitに対応していないTransportはJSONをテキストとして投稿する。
+	PostBlocks(output *cmd.CommandOutput) error
+	// UploadImage はsixelから変換したPNG画像をアップロードする
+	UploadImage(output *cmd.CommandOutput) error
+	// AddReaction / RemoveReaction はコマンドの起動元メッセージのリアクションを操作する
+	AddReaction(output *cmd.CommandOutput, name string) error
+	RemoveReaction(output *cmd.CommandOutput, name string) error
+	// Debugf はデバッグログを出力する
+	Debugf(format string, v ...interface{})
+}
+
+// Writer はoutputQueueから来たコマンド実行結果を、書き込みのたびに transport() で得た
+// Transportに書き込む。outputQueueがcloseされると戻る。
+func Writer(ctx context.Context, transport func() Transport, outputQueue chan *cmd.CommandOutput) {
+	runningProcess := 0
+	for {
+		select {
+		case output, ok := <-outputQueue: // closeされると ok が false になる
+			if !ok {
+				return
+			}
+			runningProcess = handleOutput(transport(), output, runningProcess)
+		case <-ctx.Done():
+			for output := range outputQueue {
+				runningProcess = handleOutput(transport(), output, runningProcess)
+			}
+			return
+		}
+	}
+}
+
+// DispatchByWorkspace はoutputQueueから来た出力を、ReplyInfo の WorkspaceReply が示す
+// ワークスペースのキューに WorkspaceReply を外して送る。ワークスペースが不明な出力は fallback のキューに送る。
+// outputQueueがcloseされると queues を全てcloseして戻る。
+func DispatchByWorkspace(
+	outputQueue chan *cmd.CommandOutput,
+	queues map[string]chan *cmd.CommandOutput,
+	fallback string,
+	logf func(format string, v ...interface{}),
+) {
+	defer func() {
+		for _, q := range queues {
+			close(q)
+		}
+	}()
+	for output := range outputQueue {
+		name := fallback
+		if r, ok := output.ReplyInfo.(*WorkspaceReply); ok {
+			unwrapped := *output
+			unwrapped.ReplyInfo = r.ReplyInfo
+			output = &unwrapped
+			if r.Workspace != "" {
+				name = r.Workspace
+			}
+		}
+		q, ok := queues[name]
+		if !ok {
+			logf("[WARN] unknown workspace '%s'; replying through '%s'", name, fallback)
+			q = queues[fallback]
+		}
+		q <- output
+	}
+}
+
+func handleOutput(t Transport, output *cmd.CommandOutput, runningProcess int) int {
+	if r, ok := output.ReplyInfo.(*APIReply); ok && r.Observe != nil {
+		r.Observe(output)
+	}
+	call := func(operation string, fn func() error) {
+		if err := traceTransportCall(output, t.Name()+"."+operation, fn); err != nil {
+			t.Debugf("[ERROR] %s: %s\n", operation, err)
+		}
+	}
+	if output.QueueFull || output.Rejected {
+		// リスナーで受け付けなかった場合（notifyRejected）と同じく、リアクションとスレッドへの返信で知らせる
+		reaction := "warning"
+		if output.Rejected {
+			reaction = "no_entry"
+		}
+		rejected := *output
+		rejected.ReplyConfig = &ReplyConfig{PostAsReply: true}
+		output = &rejected
+		call("addReaction", func() error { return t.AddReaction(output, reaction) })
+	} else if output.Queued {
+		call("addReaction", func() error { return t.AddReaction(output, "hourglass") })
+	} else if output.Dequeued {
+		call("removeReaction", func() error { return t.RemoveReaction(output, "hourglass") })
+	} else if output.Spawned {
+		runningProcess++
+		call("addReaction", func() error { return t.AddReaction(output, "eyes") })
+	} else if output.Finished {
+		runningProcess--
+		reaction := "x"
+		if output.ExitCode == 0 {
+			reaction = "white_check_mark"
+		}
+		call("addReaction", func() error { return t.AddReaction(output, reaction) })
+		call("removeReaction", func() error { return t.RemoveReaction(output, "eyes") })
+	}
+	if hasMeaningfulText(output) {
+		call("postMessage", func() error { return t.PostMessage(output) })
+	}
+	if output.ImageData != nil {
+		call("uploadImage", func() error { return t.UploadImage(output) })
+	}
+	if len(output.Blocks) > 0 {
+		call("postBlocks", func() error { return t.PostBlocks(output) })
+	}
+	return runningProcess
+}
+
+// queueFullMessage はコマンドキューが一杯で受け付けなかった場合の返信
+const queueFullMessage = cmd.QueueFullMessage
+
+// notifyRejected はコマンドを受け付けなかったことを、
+// 起動元メッセージへのリアクションとスレッドへの返信で知らせる
+func notifyRejected(t Transport, input *cmd.CommandInput, reaction, message string) {
+	output := &cmd.CommandOutput{
+		ReplyInfo:   input.ReplyInfo,
+		ReplyConfig: &ReplyConfig{PostAsReply: true},
+		Text:        message,
+		IsErrOut:    true,
+		Context:     input.Context,
+	}
+	if r, ok := output.ReplyInfo.(*WorkspaceReply); ok {
+		output.ReplyInfo = r.ReplyInfo
+	}
+	if err := traceTransportCall(output, t.Name()+".addReaction", func() error {
+		return t.AddReaction(output, reaction)
+	}); err != nil {
+		t.Debugf("[ERROR] addReaction: %s\n", err)
+	}
+	handleOutput(t, output, 0)
+}
+
+// traceTransportCall はチャットサービスのAPI呼び出しをspanとして記録する。
+// output.Context はトレースの親としてだけ使い、キャンセルは引き継がない。
+func traceTransportCall(output *cmd.CommandOutput, spanName string, fn func() error) error {
+	ctx := context.Background()
+	if output.Context != nil {
+		ctx = trace.ContextWithSpanContext(ctx, trace.SpanContextFromContext(output.Context))
+	}
+	_, span := tracing.Tracer().Start(ctx, spanName, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+	err := fn()
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
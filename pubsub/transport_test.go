@@ -0,0 +1,181 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+func TestTraceTransportCall(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "executor.execute")
+	parent.End()
+	output := &cmd.CommandOutput{Context: ctx}
+
+	err := traceTransportCall(output, "slack.postMessage", func() error {
+		return errors.New("channel_not_found")
+	})
+	if err == nil {
+		t.Fatal("expected error to be returned")
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	s := spans[1]
+	if s.Name != "slack.postMessage" {
+		t.Fatalf("unexpected span name %q", s.Name)
+	}
+	if s.Parent.SpanID() != parent.SpanContext().SpanID() {
+		t.Fatal("span is not a child of the output context")
+	}
+	if s.Status.Code != codes.Error {
+		t.Fatalf("expected error status, got %v", s.Status)
+	}
+}
+
+func TestReplyTargetOfUnknownReplyInfo(t *testing.T) {
+	output := &cmd.CommandOutput{ReplyInfo: struct{}{}}
+	if getChannel(output) != "" || getTimeStamp(output) != "" {
+		t.Fatalf("expected empty reply target for unknown ReplyInfo")
+	}
+
+	var observed []*cmd.CommandOutput
+	reply := &APIReply{
+		ChannelReply: ChannelReply{Channel: "C1", ThreadTimeStamp: "1.2"},
+		Observe:      func(o *cmd.CommandOutput) { observed = append(observed, o) },
+	}
+	output = &cmd.CommandOutput{ReplyInfo: reply, Spawned: true}
+	if getChannel(output) != "C1" || getThreadTimestamp(output) != "1.2" || hasSourceMessage(output) {
+		t.Fatalf("unexpected reply target for APIReply")
+	}
+	handleOutput(&nopTransport{}, output, 0)
+	if len(observed) != 1 || observed[0] != output {
+		t.Fatalf("expected Observe to receive the output, got %v", observed)
+	}
+}
+
+// nopTransport は何もしないTransport
+type nopTransport struct{}
+
+func (nopTransport) Name() string                                         { return "nop" }
+func (nopTransport) Listen(context.Context, chan *cmd.CommandInput) error { return nil }
+func (nopTransport) PostMessage(*cmd.CommandOutput) error                 { return nil }
+func (nopTransport) PostBlocks(*cmd.CommandOutput) error                  { return nil }
+func (nopTransport) UploadImage(*cmd.CommandOutput) error                 { return nil }
+func (nopTransport) AddReaction(*cmd.CommandOutput, string) error         { return nil }
+func (nopTransport) RemoveReaction(*cmd.CommandOutput, string) error      { return nil }
+func (nopTransport) Debugf(string, ...interface{})                        {}
+
+func TestDispatchByWorkspace(t *testing.T) {
+	outputQueue := make(chan *cmd.CommandOutput, 3)
+	queues := map[string]chan *cmd.CommandOutput{
+		"a": make(chan *cmd.CommandOutput, 3),
+		"b": make(chan *cmd.CommandOutput, 3),
+	}
+	src := &ChannelReply{Channel: "C1"}
+	outputQueue <- &cmd.CommandOutput{ReplyInfo: &WorkspaceReply{Workspace: "b", ReplyInfo: src}, Text: "to b"}
+	outputQueue <- &cmd.CommandOutput{ReplyInfo: &WorkspaceReply{Workspace: "gone", ReplyInfo: src}, Text: "unknown"}
+	outputQueue <- &cmd.CommandOutput{ReplyInfo: src, Text: "no workspace"}
+	close(outputQueue)
+	DispatchByWorkspace(outputQueue, queues, "a", func(string, ...interface{}) {})
+
+	var gotB []*cmd.CommandOutput
+	for o := range queues["b"] {
+		gotB = append(gotB, o)
+	}
+	if len(gotB) != 1 || gotB[0].Text != "to b" || gotB[0].ReplyInfo != src {
+		t.Fatalf("unexpected outputs for b: %+v", gotB)
+	}
+	var gotA []string
+	for o := range queues["a"] {
+		if o.ReplyInfo != src {
+			t.Fatalf("WorkspaceReply was not unwrapped: %#v", o.ReplyInfo)
+		}
+		gotA = append(gotA, o.Text)
+	}
+	if len(gotA) != 2 {
+		t.Fatalf("expected fallback outputs in a, got %v", gotA)
+	}
+}
+
+// recordingTransport はリアクションと投稿の操作を記録する
+type recordingTransport struct {
+	nopTransport
+	calls []string
+}
+
+func (r *recordingTransport) PostMessage(output *cmd.CommandOutput) error {
+	r.calls = append(r.calls, "post:"+output.Text)
+	return nil
+}
+
+func (r *recordingTransport) AddReaction(_ *cmd.CommandOutput, name string) error {
+	r.calls = append(r.calls, "+"+name)
+	return nil
+}
+
+func (r *recordingTransport) RemoveReaction(_ *cmd.CommandOutput, name string) error {
+	r.calls = append(r.calls, "-"+name)
+	return nil
+}
+
+func TestHandleQueuedOutput(t *testing.T) {
+	tr := &recordingTransport{}
+	src := &ChannelReply{Channel: "C1"}
+	running := handleOutput(tr, &cmd.CommandOutput{ReplyInfo: src, Queued: true, QueuePosition: 2}, 0)
+	running = handleOutput(tr, &cmd.CommandOutput{ReplyInfo: src, Dequeued: true}, running)
+	running = handleOutput(tr, &cmd.CommandOutput{ReplyInfo: src, Spawned: true}, running)
+	running = handleOutput(tr, &cmd.CommandOutput{ReplyInfo: src, QueueFull: true, Text: queueFullMessage}, running)
+	running = handleOutput(tr, &cmd.CommandOutput{ReplyInfo: src, Rejected: true, Text: "busy"}, running)
+	want := "+hourglass,-hourglass,+eyes,+warning,post:" + queueFullMessage + ",+no_entry,post:busy"
+	if got := strings.Join(tr.calls, ","); got != want || running != 1 {
+		t.Fatalf("got %s (running %d), want %s", got, running, want)
+	}
+}
+
+func TestNotifyQueueFull(t *testing.T) {
+	tr := &recordingTransport{}
+	o := &listenerOptions{feedback: tr}
+	src := &ChannelReply{Channel: "C1"}
+	o.queueFull(&cmd.CommandInput{ReplyInfo: &WorkspaceReply{Workspace: "main", ReplyInfo: src}})
+	want := "+warning,post:" + queueFullMessage
+	if got := strings.Join(tr.calls, ","); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	// 知らせる先がなければ何もしない
+	(&listenerOptions{}).queueFull(&cmd.CommandInput{ReplyInfo: src})
+}
+
+type denyAll struct{}
+
+func (denyAll) Allow(*cmd.CommandInput) (bool, string) { return false, "too many" }
+
+func TestCheckRateLimit(t *testing.T) {
+	tr := &recordingTransport{}
+	input := &cmd.CommandInput{ReplyInfo: &ChannelReply{Channel: "C1"}, SenderID: "U1"}
+	debugf := func(string, ...interface{}) {}
+	if !checkRateLimit(debugf, &listenerOptions{feedback: tr}, input) {
+		t.Fatal("no limiter must allow everything")
+	}
+	if checkRateLimit(debugf, &listenerOptions{feedback: tr, rateLimiter: denyAll{}}, input) {
+		t.Fatal("expected denial")
+	}
+	if got, want := strings.Join(tr.calls, ","), "+no_entry,post:too many"; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config は [rate_limit] セクションの設定
+type Config struct {
+	PerUser    string `toml:"per_user"`    // ユーザーごとの上限（例: "10/min"）。空なら制限しない
+	PerChannel string `toml:"per_channel"` // チャンネルごとの上限。空なら制限しない
+}
+
+// Enabled reports whether any of the limits is configured.
+func (c *Config) Enabled() bool {
+	return c.PerUser != "" || c.PerChannel != ""
+}
+
+// Validate checks the configuration.
+func (c *Config) Validate() error {
+	if _, err := ParseRate(c.PerUser); c.PerUser != "" && err != nil {
+		return fmt.Errorf("rate_limit.per_user: %w", err)
+	}
+	if _, err := ParseRate(c.PerChannel); c.PerChannel != "" && err != nil {
+		return fmt.Errorf("rate_limit.per_channel: %w", err)
+	}
+	return nil
+}
+
+// Rate は Per の間に Count 回まで実行できることを表す
+type Rate struct {
+	Count int
+	Per   time.Duration
+}
+
+// 単位の別名
+var rateUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "second": time.Second,
+	"m": time.Minute, "min": time.Minute, "minute": time.Minute,
+	"h": time.Hour, "hour": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour,
+}
+
+// ParseRate parses a rate such as "5/min", "100/hour" or "3/30s".
+func ParseRate(s string) (Rate, error) {
+	count, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate '%s' (want e.g. \"5/min\")", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || n < 1 {
+		return Rate{}, fmt.Errorf("invalid count in rate '%s'", s)
+	}
+	per = strings.ToLower(strings.TrimSpace(per))
+	d, ok := rateUnits[per]
+	if !ok {
+		d, err = time.ParseDuration(per)
+		if err != nil || d <= 0 {
+			return Rate{}, errors.New("invalid period in rate '" + s + "' (use s, min, hour, day or a duration like 30s)")
+		}
+	}
+	return Rate{Count: n, Per: d}, nil
+}
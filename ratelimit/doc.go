@@ -0,0 +1,3 @@
+// Package ratelimit limits how often commands can be run per user, per channel
+// and per command with token buckets, and provides the built-in limits command.
+package ratelimit
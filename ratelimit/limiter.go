@@ -0,0 +1,225 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// maxBuckets を超えたら、満杯に戻ったバケットを忘れる
+const maxBuckets = 4096
+
+// バケットの種類
+const (
+	ScopeUser    = "user"
+	ScopeChannel = "channel"
+	ScopeCommand = "command"
+)
+
+// Limiter は per_user、per_channel とコマンドごとの rate_limit をトークンバケットで数える。
+// nilの *Limiter は何も制限しない。
+type Limiter struct {
+	definitions func(input *cmd.CommandInput) []*cmd.Definition
+	now         func() time.Time
+
+	mu      sync.Mutex
+	cfg     Config
+	buckets map[bucketKey]*bucket
+}
+
+type bucketKey struct {
+	scope string // ScopeUser、ScopeChannel、ScopeCommand のいずれか
+	key   string // ユーザーID、チャンネルID、コマンドのキーワード
+}
+
+type bucket struct {
+	limit  string // 設定に書かれた上限（例: "5/min"）
+	rate   Rate
+	tokens float64
+	last   time.Time
+}
+
+// Status はバケットの状態
+type Status struct {
+	Scope     string // ScopeUser、ScopeChannel、ScopeCommand のいずれか
+	Key       string // ユーザーID、チャンネルID、コマンドのキーワード
+	Limit     string // 設定に書かれた上限（例: "5/min"）
+	Remaining int    // 今すぐ実行できる回数
+}
+
+// New returns a Limiter. definitions returns the command definitions that an
+// input would run; their RateLimit is applied per keyword.
+func New(cfg Config, definitions func(input *cmd.CommandInput) []*cmd.Definition) *Limiter {
+	return &Limiter{
+		definitions: definitions,
+		now:         time.Now,
+		cfg:         cfg,
+		buckets:     map[bucketKey]*bucket{},
+	}
+}
+
+// Update replaces per_user and per_channel. Counts made so far are kept.
+func (l *Limiter) Update(cfg Config) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg = cfg
+}
+
+// limitFor はバケットと、そのバケットに適用する上限
+type limitFor struct {
+	bucketKey
+	limit string
+}
+
+// userLimits は userID と channelID に適用する上限を返す。l.mu を持った状態で呼ぶ。
+func (l *Limiter) userLimits(userID, channelID string) []limitFor {
+	var limits []limitFor
+	if l.cfg.PerUser != "" && userID != "" {
+		limits = append(limits, limitFor{bucketKey{ScopeUser, userID}, l.cfg.PerUser})
+	}
+	if l.cfg.PerChannel != "" && channelID != "" {
+		limits = append(limits, limitFor{bucketKey{ScopeChannel, channelID}, l.cfg.PerChannel})
+	}
+	return limits
+}
+
+// Allow reports whether input may run now and, if so, takes a token from every
+// bucket that applies to it. Otherwise it returns a message explaining which
+// limit was exceeded. An input that matches no command is always allowed and
+// takes no token.
+func (l *Limiter) Allow(input *cmd.CommandInput) (bool, string) {
+	if l == nil {
+		return true, ""
+	}
+	var defs []*cmd.Definition
+	if l.definitions != nil {
+		defs = l.definitions(input)
+		if len(defs) == 0 {
+			// コマンドではない普通の発言でバケットを減らさない
+			return true, ""
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	limits := l.userLimits(input.SenderID, input.ChannelID)
+	for _, def := range defs {
+		if def.RateLimit != "" {
+			limits = append(limits, limitFor{bucketKey{ScopeCommand, def.Keyword}, def.RateLimit})
+		}
+	}
+	buckets := make([]*bucket, 0, len(limits))
+	for _, lim := range limits {
+		b := l.bucket(lim, now)
+		if b == nil {
+			continue // 設定の検証で弾いているので通常は起きない
+		}
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) * float64(b.rate.Per) / float64(b.rate.Count))
+			return false, fmt.Sprintf("%s実行回数の上限（%s）に達しました。%s後に再度実行してください",
+				describe(lim), lim.limit, roundUp(wait))
+		}
+		buckets = append(buckets, b)
+	}
+	// 全ての上限を確認してから消費する
+	for _, b := range buckets {
+		b.tokens--
+	}
+	l.forgetFullBuckets(now)
+	return true, ""
+}
+
+// Status returns the buckets that apply to userID and channelID, and those of
+// every command with rate_limit that has been run.
+func (l *Limiter) Status(userID, channelID string) []Status {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	limits := l.userLimits(userID, channelID)
+	var commands []limitFor
+	for key, b := range l.buckets {
+		if key.scope == ScopeCommand {
+			commands = append(commands, limitFor{key, b.limit})
+		}
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].key < commands[j].key })
+	limits = append(limits, commands...)
+
+	statuses := make([]Status, 0, len(limits))
+	for _, lim := range limits {
+		b := l.bucket(lim, now)
+		if b == nil {
+			continue
+		}
+		statuses = append(statuses, Status{
+			Scope:     lim.scope,
+			Key:       lim.key,
+			Limit:     lim.limit,
+			Remaining: int(math.Floor(b.tokens)),
+		})
+	}
+	return statuses
+}
+
+// bucket は lim のバケットを、now までに貯まったトークンを足して返す。
+// 上限の設定が変わっていたら新しい上限で作り直す。
+func (l *Limiter) bucket(lim limitFor, now time.Time) *bucket {
+	b, ok := l.buckets[lim.bucketKey]
+	if !ok || b.limit != lim.limit {
+		rate, err := ParseRate(lim.limit)
+		if err != nil {
+			return nil
+		}
+		tokens := float64(rate.Count)
+		if ok {
+			tokens = math.Min(b.tokens, tokens)
+		}
+		b = &bucket{limit: lim.limit, rate: rate, tokens: tokens, last: now}
+		l.buckets[lim.bucketKey] = b
+	}
+	elapsed := now.Sub(b.last)
+	b.last = now
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(b.rate.Count), b.tokens+elapsed.Seconds()*float64(b.rate.Count)/b.rate.Per.Seconds())
+	}
+	return b
+}
+
+// forgetFullBuckets はバケットが多すぎる場合に、満杯に戻ったもの（忘れても結果が変わらないもの）を消す
+func (l *Limiter) forgetFullBuckets(now time.Time) {
+	if len(l.buckets) <= maxBuckets {
+		return
+	}
+	for key, b := range l.buckets {
+		elapsed := now.Sub(b.last).Seconds()
+		if b.tokens+elapsed*float64(b.rate.Count)/b.rate.Per.Seconds() >= float64(b.rate.Count) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func describe(lim limitFor) string {
+	switch lim.scope {
+	case ScopeUser:
+		return "ユーザーごとの"
+	case ScopeChannel:
+		return "チャンネルごとの"
+	default:
+		return "コマンド '" + lim.key + "' の"
+	}
+}
+
+// roundUp は待ち時間を秒単位に切り上げる
+func roundUp(d time.Duration) time.Duration {
+	return (d + time.Second - 1).Truncate(time.Second)
+}
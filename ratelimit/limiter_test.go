@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Rate
+	}{
+		{"5/min", Rate{5, time.Minute}},
+		{" 100 / hour ", Rate{100, time.Hour}},
+		{"3/30s", Rate{3, 30 * time.Second}},
+		{"1/day", Rate{1, 24 * time.Hour}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "5", "0/min", "x/min", "5/fortnight", "5/-1s"} {
+		if _, err := ParseRate(in); err == nil {
+			t.Errorf("ParseRate(%q) should fail", in)
+		}
+	}
+}
+
+func newTestLimiter(cfg Config, defs ...*cmd.Definition) (*Limiter, *time.Time) {
+	now := time.Unix(0, 0)
+	l := New(cfg, func(*cmd.CommandInput) []*cmd.Definition { return defs })
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLimiterPerUserAndRefill(t *testing.T) {
+	l, now := newTestLimiter(Config{PerUser: "2/min"}, &cmd.Definition{Keyword: "date"})
+	input := &cmd.CommandInput{SenderID: "U1", ChannelID: "C1"}
+	for i := 0; i < 2; i++ {
+		if ok, reason := l.Allow(input); !ok {
+			t.Fatalf("run %d denied: %s", i, reason)
+		}
+	}
+	ok, reason := l.Allow(input)
+	if ok || !strings.Contains(reason, "ユーザーごと") || !strings.Contains(reason, "30s後") {
+		t.Fatalf("expected denial with wait time, got %v %q", ok, reason)
+	}
+	// 他のユーザーは制限されない
+	if ok, _ := l.Allow(&cmd.CommandInput{SenderID: "U2", ChannelID: "C1"}); !ok {
+		t.Fatal("other user must not be limited")
+	}
+	// 30秒で1回分回復する
+	*now = now.Add(30 * time.Second)
+	if ok, _ := l.Allow(input); !ok {
+		t.Fatal("expected a token after 30s")
+	}
+	if ok, _ := l.Allow(input); ok {
+		t.Fatal("expected denial again")
+	}
+}
+
+func TestLimiterChecksAllBucketsBeforeConsuming(t *testing.T) {
+	deploy := &cmd.Definition{Keyword: "deploy *", RateLimit: "1/hour"}
+	l, _ := newTestLimiter(Config{PerChannel: "10/min"}, deploy)
+	if ok, _ := l.Allow(&cmd.CommandInput{SenderID: "U1", ChannelID: "C1"}); !ok {
+		t.Fatal("first deploy must be allowed")
+	}
+	ok, reason := l.Allow(&cmd.CommandInput{SenderID: "U2", ChannelID: "C1"})
+	if ok || !strings.Contains(reason, "deploy *") {
+		t.Fatalf("expected command limit, got %v %q", ok, reason)
+	}
+	// 拒否した実行ではチャンネルのトークンを消費しない
+	statuses := l.Status("U1", "C1")
+	want := []Status{
+		{Scope: ScopeChannel, Key: "C1", Limit: "10/min", Remaining: 9},
+		{Scope: ScopeCommand, Key: "deploy *", Limit: "1/hour", Remaining: 0},
+	}
+	if len(statuses) != len(want) {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("status[%d] = %+v, want %+v", i, statuses[i], want[i])
+		}
+	}
+}
+
+func TestLimiterIgnoresInputWithoutCommand(t *testing.T) {
+	l, _ := newTestLimiter(Config{PerUser: "1/min", PerChannel: "1/min"})
+	input := &cmd.CommandInput{Text: "おはようございます", SenderID: "U1", ChannelID: "C1"}
+	for i := 0; i < 3; i++ {
+		if ok, reason := l.Allow(input); !ok {
+			t.Fatalf("chat %d denied: %s", i, reason)
+		}
+	}
+	// コマンドにマッチしない発言ではトークンを消費しない
+	if statuses := l.Status("U1", "C1"); len(statuses) != 2 || statuses[0].Remaining != 1 || statuses[1].Remaining != 1 {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+}
+
+func TestLimitsCommand(t *testing.T) {
+	l, _ := newTestLimiter(Config{PerUser: "5/min"}, &cmd.Definition{Keyword: "date"})
+	input := &cmd.CommandInput{SenderID: "U1", ChannelID: "C1"}
+	l.Allow(input)
+
+	c := NewRunner(l).CommandContext(t.Context(), "limits").(*limitsCmd)
+	var out bytes.Buffer
+	c.SetStdout(&out)
+	c.SetInput(input)
+	if ret := c.Run(0); ret != 0 {
+		t.Fatalf("unexpected exit code: %d", ret)
+	}
+	if got := out.String(); got != "user U1: 4 left (5/min)\n" {
+		t.Fatalf("unexpected output: %q", got)
+	}
+
+	var nilLimiter *Limiter
+	if ok, _ := nilLimiter.Allow(input); !ok {
+		t.Fatal("nil limiter must allow everything")
+	}
+}
@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// Definitions returns the built-in command definitions served by NewRunner.
+func Definitions() []*cmd.Definition {
+	return []*cmd.Definition{
+		{Keyword: "limits", Command: "limits", Runner: "limits"},
+	}
+}
+
+type runner struct {
+	limiter *Limiter
+}
+
+// NewRunner returns a runner implementing the built-in `limits` command, which
+// shows the remaining runs for the sender, the channel and each command.
+func NewRunner(l *Limiter) cmd.CommandRunner {
+	return &runner{limiter: l}
+}
+
+func (r *runner) CommandContext(_ context.Context, _ string, _ ...string) cmd.Cmd {
+	return &limitsCmd{limiter: r.limiter}
+}
+
+type limitsCmd struct {
+	limiter *Limiter
+	input   *cmd.CommandInput
+	stdout  io.Writer
+}
+
+func (c *limitsCmd) SetStdin(_ io.Reader) {}
+
+func (c *limitsCmd) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *limitsCmd) SetStderr(_ io.Writer) {}
+
+func (c *limitsCmd) SetInput(input *cmd.CommandInput) {
+	c.input = input
+}
+
+func (c *limitsCmd) Run(_ int) int {
+	var userID, channelID string
+	if c.input != nil {
+		userID, channelID = c.input.SenderID, c.input.ChannelID
+	}
+	statuses := c.limiter.Status(userID, channelID)
+	if len(statuses) == 0 {
+		_, _ = io.WriteString(c.stdout, "No rate limits.")
+		return 0
+	}
+	var sb strings.Builder
+	for _, s := range statuses {
+		fmt.Fprintf(&sb, "%s %s: %d left (%s)\n", s.Scope, s.Key, s.Remaining, s.Limit)
+	}
+	_, _ = io.WriteString(c.stdout, sb.String())
+	return 0
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/hnw/slack-commander/api"
+	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/pubsub"
+	"github.com/hnw/slack-commander/ratelimit"
+)
+
+// reloader は設定ファイルを再読み込みし、実行中のコマンドテーブルとリスナー設定を差し替える
+type reloader struct {
+	path           string
+	builtinDefs    []*cmd.Definition
+	table          *cmd.CommandTable
+	listenerConfig *pubsub.ConfigStore
+	rateLimiter    *ratelimit.Limiter
+	apiServer      *api.Server // [api] を設定していなければnil
+	// workspaceConfigs は [[workspaces]] のワークスペース名ごとのリスナー設定
+	workspaceConfigs map[string]*pubsub.ConfigStore
+	reconnect        chan struct{} // 接続先かトークンが変わったときに通知する（容量1）
+	infof            func(template string, args ...interface{})
+	warnf            func(template string, args ...interface{})
+	errorf           func(template string, args ...interface{})
+
+	mu  sync.Mutex
+	cfg *Config
+}
+
+// reload は設定ファイルを読み直す。読み込みや検証に失敗した場合は現在の設定を維持する。
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	newCfg, err := loadConfig(r.path)
+	if err != nil {
+		r.errorf("config reload failed; keeping the current config: %v", err)
+		return err
+	}
+	for _, name := range restartRequiredChanges(r.cfg, newCfg) {
+		r.warnf("config reload: %s changed; restart is required to apply it", name)
+	}
+	// 実行中のジョブは古い定義のまま完了し、次の入力から新しい定義を使う
+	r.table.Update(buildCommandConfigs(newCfg, r.builtinDefs))
+	r.listenerConfig.Store(newCfg.PubSubConfig)
+	r.rateLimiter.Update(newCfg.RateLimit)
+	r.apiServer.UpdateTokens(newCfg.API.Tokens)
+	for _, ws := range newCfg.Workspaces {
+		// 追加されたワークスペースには再起動するまで接続しない
+		if store, ok := r.workspaceConfigs[ws.Name]; ok {
+			store.Store(ws.PubSubConfig)
+		}
+	}
+	reconnect := connectionChanged(r.cfg, newCfg)
+	r.cfg = newCfg
+	if reconnect {
+		select {
+		case r.reconnect <- struct{}{}:
+		default: // 再接続の要求が既に溜まっている
+		}
+	}
+	r.infof("config reloaded from %s", r.path)
+	return nil
+}
+
+// current は現在の設定を返す
+func (r *reloader) current() *Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// connectionChanged はチャットサービスへの接続し直しが必要な設定の変更があったかを返す
+func connectionChanged(oldCfg, newCfg *Config) bool {
+	return oldCfg.Transport != newCfg.Transport ||
+		oldCfg.SlackBotToken != newCfg.SlackBotToken ||
+		oldCfg.SlackAppToken != newCfg.SlackAppToken ||
+		oldCfg.Mode != newCfg.Mode ||
+		oldCfg.HTTPListenAddr != newCfg.HTTPListenAddr ||
+		oldCfg.SlackSigningSecret != newCfg.SlackSigningSecret ||
+		oldCfg.Mattermost != newCfg.Mattermost ||
+		!reflect.DeepEqual(oldCfg.Discord, newCfg.Discord)
+}
+
+// run はctxが終了するまで、hupを受け取るか設定ファイルの更新を検出するたびに再読み込みする。
+// watchInterval が0ならファイルの監視はしない。
+func (r *reloader) run(ctx context.Context, hup <-chan os.Signal, watchInterval time.Duration) {
+	var tick <-chan time.Time
+	if watchInterval > 0 {
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	modTime := r.modTime()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			modTime = r.modTime()
+			_ = r.reload() // エラーはreload内でログに出している
+		case <-tick:
+			if t := r.modTime(); !t.Equal(modTime) {
+				modTime = t
+				_ = r.reload()
+			}
+		}
+	}
+}
+
+func (r *reloader) modTime() time.Time {
+	fi, err := os.Stat(r.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// restartRequiredChanges は再読み込みでは反映できない設定のうち、変更されたものの名前を返す
+func restartRequiredChanges(oldCfg, newCfg *Config) []string {
+	var changed []string
+	check := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	check("num_workers", oldCfg.NumWorkers, newCfg.NumWorkers)
+	check("queue_size", oldCfg.QueueSize, newCfg.QueueSize)
+	check("history_file", oldCfg.HistoryFile, newCfg.HistoryFile)
+	check("history_retention_days", oldCfg.HistoryRetentionDays, newCfg.HistoryRetentionDays)
+	check("history_max_jobs", oldCfg.HistoryMaxJobs, newCfg.HistoryMaxJobs)
+	check("metrics_addr", oldCfg.MetricsAddr, newCfg.MetricsAddr)
+	check("health_addr", oldCfg.HealthAddr, newCfg.HealthAddr)
+	check("health_liveness_window", oldCfg.HealthLivenessWindow, newCfg.HealthLivenessWindow)
+	check("config_watch_interval", oldCfg.ConfigWatchInterval, newCfg.ConfigWatchInterval)
+	check("api.addr", oldCfg.API.Addr, newCfg.API.Addr)
+	check("audit", oldCfg.Audit, newCfg.Audit)
+	check("tracing", oldCfg.Tracing, newCfg.Tracing)
+	check("schedules", oldCfg.Schedules, newCfg.Schedules)
+	check("workspaces", workspaceConnections(oldCfg), workspaceConnections(newCfg))
+	return changed
+}
@@ -0,0 +1,2 @@
+// Package schedule runs configured commands periodically according to cron expressions.
+package schedule
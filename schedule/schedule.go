@@ -0,0 +1,210 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+// missedRunThreshold を超えて起動が遅れた場合（スリープ復帰等）は実行し損ねたと見なす
+const missedRunThreshold = time.Minute
+
+// missed_runs に指定できる値
+const (
+	MissedRunsSkip    = "skip"     // 実行し損ねた回は実行しない
+	MissedRunsRunOnce = "run_once" // 実行し損ねた回があれば1回だけ実行する
+)
+
+// overlap に指定できる値
+const (
+	OverlapSkip  = "skip"  // 前回の実行が終わっていなければ今回の実行をスキップする
+	OverlapAllow = "allow" // 前回の実行が終わっていなくても実行する
+)
+
+// Config は [[schedules]] の設定項目
+type Config struct {
+	Cron       string `toml:"cron"`
+	Timezone   string `toml:"timezone"`
+	Command    string `toml:"command"`
+	Channel    string `toml:"channel"`
+	Workspace  string `toml:"workspace"` // [[workspaces]] を使う場合に投稿先のワークスペース名
+	MissedRuns string `toml:"missed_runs"`
+	Overlap    string `toml:"overlap"`
+	Jitter     int    `toml:"jitter"` // 秒
+}
+
+// Validate は設定値を検証し、省略された値にデフォルト値を埋める
+func (c *Config) Validate() error {
+	if _, err := c.parse(); err != nil {
+		return fmt.Errorf("invalid schedule '%s': %w", c.Cron, err)
+	}
+	if strings.TrimSpace(c.Command) == "" {
+		return fmt.Errorf("command is required for schedule '%s'", c.Cron)
+	}
+	if strings.TrimSpace(c.Channel) == "" {
+		return fmt.Errorf("channel is required for schedule '%s'", c.Cron)
+	}
+	c.MissedRuns = strings.ToLower(strings.TrimSpace(c.MissedRuns))
+	switch c.MissedRuns {
+	case "":
+		c.MissedRuns = MissedRunsSkip
+	case MissedRunsSkip, MissedRunsRunOnce:
+	default:
+		return fmt.Errorf("unknown missed_runs '%s' for schedule '%s'", c.MissedRuns, c.Cron)
+	}
+	c.Overlap = strings.ToLower(strings.TrimSpace(c.Overlap))
+	switch c.Overlap {
+	case "":
+		c.Overlap = OverlapSkip
+	case OverlapSkip, OverlapAllow:
+	default:
+		return fmt.Errorf("unknown overlap '%s' for schedule '%s'", c.Overlap, c.Cron)
+	}
+	if c.Jitter < 0 {
+		return fmt.Errorf("jitter must be >= 0 for schedule '%s'", c.Cron)
+	}
+	return nil
+}
+
+func (c *Config) parse() (cron.Schedule, error) {
+	spec := strings.TrimSpace(c.Cron)
+	if spec == "" {
+		return nil, errors.New("cron is required")
+	}
+	if tz := strings.TrimSpace(c.Timezone); tz != "" {
+		if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+			return nil, errors.New("timezone must not be set in both cron and timezone")
+		}
+		if _, err := time.LoadLocation(tz); err != nil {
+			return nil, err
+		}
+		spec = "CRON_TZ=" + tz + " " + spec
+	}
+	return cron.ParseStandard(spec)
+}
+
+// ReplyInfoFunc は定期実行するコマンドの CommandInput.ReplyInfo を作る
+type ReplyInfoFunc func(cfg *Config) interface{}
+
+// Scheduler は設定に従ってcommandQueueにコマンドを投入します
+type Scheduler struct {
+	entries      []*entry
+	commandQueue chan *cmd.CommandInput
+	newReplyInfo ReplyInfoFunc
+	logf         func(format string, args ...interface{})
+	now          func() time.Time
+}
+
+type entry struct {
+	cfg      *Config
+	schedule cron.Schedule
+	running  atomic.Int32 // 実行中のジョブ数
+}
+
+// New returns a Scheduler for the given configs.
+// logf is used to report skipped runs; it may be nil.
+func New(
+	cfgs []*Config,
+	commandQueue chan *cmd.CommandInput,
+	newReplyInfo ReplyInfoFunc,
+	logf func(format string, args ...interface{}),
+) (*Scheduler, error) {
+	if logf == nil {
+		logf = func(string, ...interface{}) {}
+	}
+	s := &Scheduler{
+		commandQueue: commandQueue,
+		newReplyInfo: newReplyInfo,
+		logf:         logf,
+		now:          time.Now,
+	}
+	for _, c := range cfgs {
+		sched, err := c.parse()
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule '%s': %w", c.Cron, err)
+		}
+		s.entries = append(s.entries, &entry{cfg: c, schedule: sched})
+	}
+	return s, nil
+}
+
+// Run はctxがキャンセルされるまでスケジュールに従ってコマンドを投入します
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range s.entries {
+		wg.Add(1)
+		go func(e *entry) {
+			defer wg.Done()
+			s.runEntry(ctx, e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) runEntry(ctx context.Context, e *entry) {
+	next := e.schedule.Next(s.now())
+	for {
+		planned := next.Add(s.jitter(e.cfg))
+		timer := time.NewTimer(planned.Sub(s.now()))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		now := s.now()
+		if isMissed(planned, now) {
+			s.logf("[WARN] schedule '%s': missed run at %s", e.cfg.Cron, next.Format(time.RFC3339))
+			if e.cfg.MissedRuns == MissedRunsRunOnce {
+				s.fire(e)
+			}
+			next = e.schedule.Next(now)
+			continue
+		}
+		s.fire(e)
+		next = e.schedule.Next(next)
+	}
+}
+
+func (s *Scheduler) jitter(c *Config) time.Duration {
+	if c.Jitter <= 0 {
+		return 0
+	}
+	return rand.N(time.Duration(c.Jitter) * time.Second) // #nosec G404 -- 暗号用途ではない
+}
+
+func isMissed(planned, now time.Time) bool {
+	return now.Sub(planned) > missedRunThreshold
+}
+
+// fire は1回分のコマンドをcommandQueueに投入する
+func (s *Scheduler) fire(e *entry) {
+	if e.cfg.Overlap == OverlapSkip && e.running.Load() > 0 {
+		s.logf("[WARN] schedule '%s': previous run is still running; skipped", e.cfg.Cron)
+		return
+	}
+	e.running.Add(1)
+	input := &cmd.CommandInput{
+		ReplyInfo: s.newReplyInfo(e.cfg),
+		Text:      e.cfg.Command,
+		ChannelID: e.cfg.Channel,
+		Done: func(int) {
+			e.running.Add(-1)
+		},
+	}
+	select {
+	case s.commandQueue <- input:
+	default:
+		e.running.Add(-1)
+		s.logf("[WARN] schedule '%s': command queue is full; skipped", e.cfg.Cron)
+	}
+}
@@ -0,0 +1,144 @@
+package schedule
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hnw/slack-commander/cmd"
+)
+
+func TestConfigValidate(t *testing.T) {
+	c := &Config{Cron: "0 9 * * 1-5", Timezone: "Asia/Tokyo", Command: "date", Channel: "C1"}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.MissedRuns != MissedRunsSkip || c.Overlap != OverlapSkip {
+		t.Fatalf("unexpected defaults: missed_runs=%q overlap=%q", c.MissedRuns, c.Overlap)
+	}
+
+	invalid := []*Config{
+		{Cron: "", Command: "date", Channel: "C1"},
+		{Cron: "61 * * * *", Command: "date", Channel: "C1"},
+		{Cron: "@daily", Timezone: "Mars/Olympus", Command: "date", Channel: "C1"},
+		{Cron: "@daily", Channel: "C1"},
+		{Cron: "@daily", Command: "date"},
+		{Cron: "@daily", Command: "date", Channel: "C1", MissedRuns: "catch_up"},
+		{Cron: "@daily", Command: "date", Channel: "C1", Overlap: "queue"},
+		{Cron: "@daily", Command: "date", Channel: "C1", Jitter: -1},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected error for %+v", c)
+		}
+	}
+}
+
+func TestConfigTimezone(t *testing.T) {
+	c := &Config{Cron: "0 9 * * *", Timezone: "Asia/Tokyo"}
+	sched, err := c.parse()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) // 09:00 JST
+	got := sched.Next(from)
+	want := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	if !got.Equal(want) {
+		t.Fatalf("next run = %v, want %v", got, want)
+	}
+}
+
+func newTestScheduler(t *testing.T, c *Config, queue chan *cmd.CommandInput) *Scheduler {
+	t.Helper()
+	if err := c.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s, err := New([]*Config{c}, queue, func(c *Config) interface{} { return c.Channel }, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return s
+}
+
+func TestFireSkipsOverlappingRun(t *testing.T) {
+	queue := make(chan *cmd.CommandInput, 10)
+	s := newTestScheduler(t, &Config{Cron: "@daily", Command: "date", Channel: "C1"}, queue)
+	e := s.entries[0]
+
+	s.fire(e)
+	s.fire(e)
+	if len(queue) != 1 {
+		t.Fatalf("expected 1 queued input, got %d", len(queue))
+	}
+	input := <-queue
+	if input.Text != "date" || input.ChannelID != "C1" || input.ReplyInfo != "C1" {
+		t.Fatalf("unexpected input: %+v", input)
+	}
+
+	input.Done(0)
+	s.fire(e)
+	if len(queue) != 1 {
+		t.Fatalf("expected run after previous one finished, got %d", len(queue))
+	}
+}
+
+func TestFireAllowsOverlappingRun(t *testing.T) {
+	queue := make(chan *cmd.CommandInput, 10)
+	s := newTestScheduler(t, &Config{
+		Cron:    "@daily",
+		Command: "date",
+		Channel: "C1",
+		Overlap: OverlapAllow,
+	}, queue)
+	s.fire(s.entries[0])
+	s.fire(s.entries[0])
+	if len(queue) != 2 {
+		t.Fatalf("expected 2 queued inputs, got %d", len(queue))
+	}
+}
+
+func TestFireQueueFullReleasesRunningState(t *testing.T) {
+	queue := make(chan *cmd.CommandInput)
+	s := newTestScheduler(t, &Config{Cron: "@daily", Command: "date", Channel: "C1"}, queue)
+	e := s.entries[0]
+	s.fire(e)
+	if n := e.running.Load(); n != 0 {
+		t.Fatalf("expected no running job after queue-full, got %d", n)
+	}
+}
+
+func TestIsMissed(t *testing.T) {
+	planned := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	if isMissed(planned, planned.Add(time.Second)) {
+		t.Fatal("slightly late run must not be treated as missed")
+	}
+	if !isMissed(planned, planned.Add(2*time.Hour)) {
+		t.Fatal("run after sleep must be treated as missed")
+	}
+}
+
+func TestRunEnqueuesCommand(t *testing.T) {
+	queue := make(chan *cmd.CommandInput, 10)
+	s := newTestScheduler(t, &Config{Cron: "@every 1s", Command: "date", Channel: "C1"}, queue)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case input := <-queue:
+		if input.Text != "date" {
+			t.Fatalf("unexpected input: %+v", input)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("scheduled command was not enqueued")
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not stop after cancel")
+	}
+}
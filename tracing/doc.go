@@ -0,0 +1,3 @@
+// Package tracing sets up OpenTelemetry tracing and provides the tracer
+// used across the listener, executor, runners and writer.
+package tracing
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hnw/slack-commander/cmd"
+	"github.com/hnw/slack-commander/pubsub"
+)
+
+// WorkspaceConfig は [[workspaces]] の1つ分の設定。
+// トークンとメッセージの受付条件はトップレベルから引き継がず、ワークスペースごとに指定する。
+type WorkspaceConfig struct {
+	Name string `toml:"name"`
+	PubSubConfig
+}
+
+// workspaceConnection はワークスペースの設定のうち、再読み込みでは反映できない接続設定
+type workspaceConnection struct {
+	Name, BotToken, AppToken, Mode, HTTPListenAddr, SigningSecret string
+}
+
+func workspaceConnections(cfg *Config) []workspaceConnection {
+	conns := make([]workspaceConnection, 0, len(cfg.Workspaces))
+	for _, ws := range cfg.Workspaces {
+		conns = append(conns, workspaceConnection{
+			Name:           ws.Name,
+			BotToken:       ws.SlackBotToken,
+			AppToken:       ws.SlackAppToken,
+			Mode:           ws.Mode,
+			HTTPListenAddr: ws.HTTPListenAddr,
+			SigningSecret:  ws.SlackSigningSecret,
+		})
+	}
+	return conns
+}
+
+func validateWorkspaces(cfg *Config) error {
+	names := map[string]bool{}
+	for i, ws := range cfg.Workspaces {
+		if ws.Name == "" {
+			return fmt.Errorf("workspaces[%d]: name is required", i)
+		}
+		if names[ws.Name] {
+			return fmt.Errorf("duplicate workspace name '%s'", ws.Name)
+		}
+		names[ws.Name] = true
+		if err := validateWorkspace(ws); err != nil {
+			return fmt.Errorf("workspace '%s': %w", ws.Name, err)
+		}
+	}
+	if len(cfg.Workspaces) > 0 {
+		if cfg.Transport != transportSlack {
+			return errors.New("workspaces are only available for slack transport")
+		}
+		if cfg.SlackBotToken != "" || cfg.SlackAppToken != "" {
+			return errors.New("set slack_bot_token and slack_app_token in each [[workspaces]] instead of the top level")
+		}
+	}
+	for _, s := range cfg.Schedules {
+		if s.Workspace != "" && !names[s.Workspace] {
+			return fmt.Errorf("unknown workspace '%s' for schedule '%s'", s.Workspace, s.Cron)
+		}
+	}
+	return nil
+}
+
+func validateWorkspace(ws *WorkspaceConfig) error {
+	if err := validateSlackConfig(&ws.PubSubConfig); err != nil {
+		return err
+	}
+	if ws.SlackBotToken == "" {
+		return errors.New("slack_bot_token is required")
+	}
+	if ws.Mode == slackModeSocket && ws.SlackAppToken == "" {
+		return errors.New("slack_app_token is required for socket mode")
+	}
+	return checkOpenAccess(&ws.PubSubConfig, nil)
+}
+
+// newWorkspaceConfigStores はワークスペースごとのリスナー設定を保持するストアを作る
+func newWorkspaceConfigStores(cfg *Config) map[string]*pubsub.ConfigStore {
+	stores := make(map[string]*pubsub.ConfigStore, len(cfg.Workspaces))
+	for _, ws := range cfg.Workspaces {
+		stores[ws.Name] = pubsub.NewConfigStore(ws.PubSubConfig)
+	}
+	return stores
+}
+
+// workspaceSet は [[workspaces]] の全てのワークスペースに接続する
+type workspaceSet struct {
+	cfg          *Config
+	configs      map[string]*pubsub.ConfigStore
+	newTransport func(c *Config, opts ...pubsub.ListenerOption) pubsub.Transport
+	logf         func(template string, args ...interface{})
+	errorf       func(template string, args ...interface{})
+}
+
+// run はワークスペースごとにTransportとWriterを起動し、ctxが終了するまでコマンドを受け付ける。
+// 全てのワークスペースで commandQueue と outputQueue（つまりExecutor）を共有し、
+// 出力は DispatchByWorkspace でコマンドを受信したワークスペースに返す。
+// Writerは writerWG に登録し、outputQueue がcloseされると終了する。
+func (s *workspaceSet) run(
+	ctx context.Context,
+	commandQueue chan *cmd.CommandInput,
+	outputQueue chan *cmd.CommandOutput,
+	writerWG *sync.WaitGroup,
+) {
+	queues := make(map[string]chan *cmd.CommandOutput, len(s.cfg.Workspaces))
+	var listenerWG sync.WaitGroup
+	for _, ws := range s.cfg.Workspaces {
+		wsCfg := *s.cfg
+		wsCfg.PubSubConfig = ws.PubSubConfig
+		// 接続し直してもメッセージの重複判定とbotの返信の記録を引き継ぐ
+		messageState := pubsub.NewMessageState()
+		newTransport := func() pubsub.Transport {
+			return s.newTransport(&wsCfg,
+				pubsub.WithConfigStore(s.configs[ws.Name]),
+				pubsub.WithMessageState(messageState),
+				pubsub.WithWorkspace(ws.Name),
+			)
+		}
+		var current atomic.Value // pubsub.Transport
+		current.Store(newTransport())
+		q := make(chan *cmd.CommandOutput, cap(outputQueue))
+		queues[ws.Name] = q
+		writerWG.Add(1)
+		go func() {
+			defer writerWG.Done()
+			pubsub.Writer(ctx, func() pubsub.Transport { return current.Load().(pubsub.Transport) }, q)
+		}()
+		errorf := func(template string, args ...interface{}) {
+			s.errorf("workspace '"+ws.Name+"': "+template, args...)
+		}
+		listenerWG.Add(1)
+		go func() {
+			defer listenerWG.Done()
+			var backoff sessionBackoff
+			for {
+				// ワークスペースの接続設定の変更は再起動するまで反映しないので、再接続の要求は受け取らない
+				startedAt := time.Now()
+				runTransportSession(ctx, current.Load().(pubsub.Transport), commandQueue, nil, errorf)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff.next(time.Since(startedAt))):
+				}
+				current.Store(newTransport())
+			}
+		}()
+	}
+	writerWG.Add(1)
+	go func() {
+		defer writerWG.Done()
+		pubsub.DispatchByWorkspace(outputQueue, queues, s.cfg.Workspaces[0].Name, s.logf)
+	}()
+	listenerWG.Wait()
+}